@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/audit"
+	"github.com/akrantz01/tailfed/internal/database"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type auditQuery struct {
+	Backend string `koanf:"backend"`
+	Path    string `koanf:"path"`
+	Table   string `koanf:"table"`
+	Driver  string `koanf:"driver"`
+	DSN     string `koanf:"dsn"`
+
+	JTI    string `koanf:"jti"`
+	Node   string `koanf:"node"`
+	Since  string `koanf:"since"`
+	Until  string `koanf:"until"`
+	Limit  int    `koanf:"limit"`
+	Output string `koanf:"output"`
+}
+
+func newAudit() *cobra.Command {
+	aq := &auditQuery{}
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Query the record of issued tokens",
+		Long: "Searches the audit log for issued tokens by their jti, the node they were issued to, or when they were " +
+			"issued. Times can be RFC 3339 timestamps or durations relative to now (e.g. 24h).",
+		Args:    cobra.NoArgs,
+		PreRunE: structureConfigInto(aq),
+		RunE:    aq.Run,
+	}
+
+	cmd.Flags().String("backend", "dynamo", "Where the audit log is stored (choices: dynamo, filesystem, sql)")
+	cmd.Flags().String("path", "audit.jsonl", "The file path used by the filesystem backend")
+	cmd.Flags().String("table", "TailfedAudit", "The name of the DynamoDB table used by the dynamo backend")
+	cmd.Flags().String("driver", "sqlite", "The type of database used by the sql backend (choices: sqlite, postgres)")
+	cmd.Flags().String("dsn", "tailfed.db", "The data source name used to connect to the database for the sql backend")
+
+	cmd.Flags().String("jti", "", "Find the token with the given ID")
+	cmd.Flags().String("node", "", "Find tokens issued to the given node ID")
+	cmd.Flags().String("since", "", "Find tokens issued at or after the given time")
+	cmd.Flags().String("until", "", "Find tokens issued at or before the given time")
+	cmd.Flags().Int("limit", 50, "The maximum number of records to show, 0 for unlimited")
+	cmd.Flags().StringP("output", "o", "table", "How to display the records (choices: table, json)")
+
+	return cmd
+}
+
+func (aq *auditQuery) Run(cmd *cobra.Command, _ []string) error {
+	if aq.Output != "table" && aq.Output != "json" {
+		return fmt.Errorf("unknown output format %q", aq.Output)
+	}
+
+	filter, err := aq.filter()
+	if err != nil {
+		return err
+	}
+
+	backend, err := aq.backend(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	records, err := backend.Query(cmd.Context(), filter)
+	if err != nil {
+		return fmt.Errorf("failed to query audit log: %w", err)
+	}
+
+	if aq.Output == "json" {
+		if records == nil {
+			records = []audit.Record{}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+
+	return printRecords(records)
+}
+
+// filter builds the query filter from the flags
+func (aq *auditQuery) filter() (audit.Filter, error) {
+	now := time.Now()
+
+	since, err := parseTime(aq.Since, now)
+	if err != nil {
+		return audit.Filter{}, fmt.Errorf("invalid --since: %w", err)
+	}
+
+	until, err := parseTime(aq.Until, now)
+	if err != nil {
+		return audit.Filter{}, fmt.Errorf("invalid --until: %w", err)
+	}
+
+	return audit.Filter{
+		ID:    aq.JTI,
+		Node:  aq.Node,
+		Since: since,
+		Until: until,
+		Limit: aq.Limit,
+	}, nil
+}
+
+// backend opens the configured audit log
+func (aq *auditQuery) backend(ctx context.Context) (audit.Backend, error) {
+	logger := logrus.WithFields(map[string]any{
+		"component": "audit",
+		"backend":   aq.Backend,
+	})
+
+	switch aq.Backend {
+	case "filesystem":
+		return audit.NewFilesystem(logger, aq.Path)
+	case "dynamo":
+		config, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+
+		return audit.NewDynamo(logger, config, aq.Table)
+	case "sql":
+		db, err := database.Open(ctx, logger, database.Dialect(aq.Driver), aq.DSN)
+		if err != nil {
+			return nil, err
+		}
+
+		return audit.NewSQL(logger, db)
+	default:
+		return nil, errors.New("unknown audit backend")
+	}
+}
+
+// parseTime accepts either an RFC 3339 timestamp or a duration before now
+func parseTime(raw string, now time.Time) (time.Time, error) {
+	if len(raw) == 0 {
+		return time.Time{}, nil
+	}
+
+	if ago, err := time.ParseDuration(raw); err == nil {
+		return now.Add(-ago), nil
+	}
+
+	return time.Parse(time.RFC3339, raw)
+}
+
+func printRecords(records []audit.Record) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JTI\tNODE\tDNS NAME\tTAGS\tISSUED AT\tEXPIRES AT\tCLIENT\tSOURCE IP")
+
+	for _, record := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.ID,
+			record.Node,
+			record.DNSName,
+			strings.Join(record.Tags, ","),
+			record.IssuedAt.Local().Format(time.RFC3339),
+			record.ExpiresAt.Local().Format(time.RFC3339),
+			record.ClientVersion,
+			record.SourceIP,
+		)
+	}
+
+	return w.Flush()
+}
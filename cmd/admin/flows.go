@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+func newFlows() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "flows",
+		Short: "Inspect in-flight token issuance flows",
+	}
+
+	cmd.AddCommand(newFlowsList())
+
+	return cmd
+}
+
+type flowsList struct {
+	storageOptions `koanf:",squash"`
+
+	Status  string `koanf:"status"`
+	Node    string `koanf:"node"`
+	Expired bool   `koanf:"expired"`
+	Limit   int    `koanf:"limit"`
+	Output  string `koanf:"output"`
+}
+
+func newFlowsList() *cobra.Command {
+	fl := &flowsList{}
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List flows in storage",
+		Long: "Lists the flows currently in storage, optionally filtered by status, node, or whether they have " +
+			"expired. Useful for finding pending or stuck flows.",
+		Args:    cobra.NoArgs,
+		PreRunE: structureConfigInto(fl),
+		RunE:    fl.Run,
+	}
+
+	addStorageFlags(cmd.Flags())
+
+	cmd.Flags().String("status", "", "Find flows in the given status (choices: pending, failed, success)")
+	cmd.Flags().String("node", "", "Find flows started by the given node ID")
+	cmd.Flags().Bool("expired", false, "Only find flows that have expired")
+	cmd.Flags().Int("limit", 50, "The maximum number of flows to show, 0 for unlimited")
+	cmd.Flags().StringP("output", "o", "table", "How to display the flows (choices: table, json)")
+
+	return cmd
+}
+
+func (fl *flowsList) Run(cmd *cobra.Command, _ []string) error {
+	if fl.Output != "table" && fl.Output != "json" {
+		return fmt.Errorf("unknown output format %q", fl.Output)
+	}
+
+	filter := storage.Filter{Node: fl.Node, Limit: fl.Limit}
+	if len(fl.Status) != 0 {
+		if err := filter.Status.UnmarshalText(fl.Status); err != nil {
+			return fmt.Errorf("invalid --status: %w", err)
+		}
+	}
+	if fl.Expired {
+		filter.ExpiredBefore = time.Now()
+	}
+
+	store, db, err := fl.open(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	flows, err := store.List(cmd.Context(), filter)
+	if err != nil {
+		return fmt.Errorf("failed to list flows: %w", err)
+	}
+
+	sort.SliceStable(flows, func(i, j int) bool {
+		return time.Time(flows[i].ExpiresAt).After(time.Time(flows[j].ExpiresAt))
+	})
+
+	if fl.Output == "json" {
+		if flows == nil {
+			flows = []storage.Flow{}
+		}
+
+		// never display the flow secrets
+		for i := range flows {
+			flows[i].Secret = nil
+			flows[i].DataKey = nil
+
+			for _, field := range []*string{&flows[i].PublicKey, &flows[i].DNSName, &flows[i].MachineName, &flows[i].Hostname} {
+				*field = displayField(*field)
+			}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(flows)
+	}
+
+	return printFlows(flows)
+}
+
+func printFlows(flows []storage.Flow) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tNODE\tHOSTNAME\tTAGS\tEXPIRES AT")
+
+	for _, flow := range flows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			flow.ID,
+			flow.Status,
+			flow.Node,
+			displayField(flow.Hostname),
+			strings.Join(flow.Tags, ","),
+			time.Time(flow.ExpiresAt).Local().Format(time.RFC3339),
+		)
+	}
+
+	return w.Flush()
+}
+
+// displayField marks fields encrypted at rest rather than showing their ciphertext
+func displayField(value string) string {
+	if storage.IsEncrypted(value) {
+		return "(encrypted)"
+	}
+
+	return value
+}
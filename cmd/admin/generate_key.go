@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/akrantz01/tailfed/internal/signing"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type generateKey struct {
+	Algorithm string `koanf:"algorithm"`
+	Format    string `koanf:"format"`
+	Output    string `koanf:"output"`
+	Force     bool   `koanf:"force"`
+}
+
+func newGenerateKey() *cobra.Command {
+	gk := &generateKey{}
+	cmd := &cobra.Command{
+		Use:     "generate-key",
+		Short:   "Generate a new token signing key",
+		Long:    "Generates a new private key for the static signing backend, readable only by the current user.",
+		Args:    cobra.NoArgs,
+		PreRunE: structureConfigInto(gk),
+		RunE:    gk.Run,
+	}
+
+	cmd.Flags().StringP("algorithm", "a", string(jose.ES256), "The signing algorithm the key is for (choices: RS256, RS384, RS512, ES256, ES384, ES512, EdDSA)")
+	cmd.Flags().StringP("format", "f", string(signing.KeyFormatPEM), "The encoding to write the key in (choices: pem, jwk)")
+	cmd.Flags().StringP("output", "o", "signing-key.pem", "The path to write the private key to")
+	cmd.Flags().Bool("force", false, "Overwrite the output file if it already exists")
+
+	return cmd
+}
+
+func (gk *generateKey) Run(*cobra.Command, []string) error {
+	key, err := signing.GenerateKey(jose.SignatureAlgorithm(gk.Algorithm))
+	if err != nil {
+		return err
+	}
+
+	encoded, err := signing.EncodePrivateKey(key, signing.KeyFormat(gk.Format))
+	if err != nil {
+		return err
+	}
+
+	if err := gk.write(encoded); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+
+	logrus.
+		WithFields(map[string]any{
+			"algorithm": gk.Algorithm,
+			"format":    gk.Format,
+			"path":      gk.Output,
+		}).
+		Info("generated new signing key")
+	return nil
+}
+
+// write saves the key to the output file, ensuring only the current user can read it
+func (gk *generateKey) write(encoded []byte) error {
+	if err := os.MkdirAll(filepath.Dir(gk.Output), 0o700|os.ModeDir); err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if gk.Force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(gk.Output, flags, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%q already exists, use --force to overwrite it", gk.Output)
+		}
+
+		return err
+	}
+	defer file.Close()
+
+	// OpenFile only applies the permissions when creating the file
+	if err := file.Chmod(0o600); err != nil {
+		return err
+	}
+
+	_, err = file.Write(encoded)
+	return err
+}
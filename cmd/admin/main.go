@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/akrantz01/tailfed/internal/configloader"
+	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/version"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	info := version.GetInfo()
+
+	cmd := &cobra.Command{
+		Use:               "admin",
+		Version:           info.Version,
+		Short:             "Administrative utilities for operating a Tailfed deployment",
+		SilenceUsage:      true,
+		SilenceErrors:     true,
+		PersistentPreRunE: preRun,
+	}
+
+	cmd.SetVersionTemplate("{{.Version}}\n")
+
+	cmd.PersistentFlags().StringP("log-level", "l", "info", "The minimum level to log at (choices: panic, fatal, error, warn, info, debug, trace)")
+
+	cmd.AddCommand(newAudit())
+	cmd.AddCommand(newFlows())
+	cmd.AddCommand(newGenerateKey())
+	cmd.AddCommand(newSweep())
+
+	err := cmd.Execute()
+	if err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+}
+
+// preRun loads the raw configuration for the subcommand and configures logging
+func preRun(cmd *cobra.Command, _ []string) error {
+	config, err := configloader.Load(
+		configloader.WithFlags(cmd.Flags()),
+		configloader.WithEnvPrefix("TAILFED_ADMIN_"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	var root struct {
+		LogLevel string `koanf:"log-level"`
+	}
+	if err := config.Structure(&root); err != nil {
+		return fmt.Errorf("failed to structure root config: %w", err)
+	}
+
+	if err := logging.Initialize(root.LogLevel); err != nil {
+		return err
+	}
+
+	cmd.SetContext(config.InContext(cmd.Context()))
+	return nil
+}
+
+// structureConfigInto generates a command pre-run step to structure raw config into command-specific config. Assumes
+// the raw config was parsed by the root command and exists in the command context.
+func structureConfigInto[T any](dest *T) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, _ []string) error {
+		config := configloader.FromContext(cmd.Context())
+		if config == nil {
+			return errors.New("config not present in command context")
+		}
+
+		if err := config.Structure(dest); err != nil {
+			return fmt.Errorf("failed to structure command config: %w", err)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/akrantz01/tailfed/internal/database"
+	"github.com/akrantz01/tailfed/internal/storage"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// storageOptions selects the flow storage a command operates on
+type storageOptions struct {
+	Backend string `koanf:"backend"`
+	Path    string `koanf:"path"`
+	Table   string `koanf:"table"`
+	Driver  string `koanf:"driver"`
+	DSN     string `koanf:"dsn"`
+}
+
+// addStorageFlags registers the flags for selecting the flow storage
+func addStorageFlags(flags *pflag.FlagSet) {
+	flags.String("backend", "dynamo", "Where flows are stored (choices: dynamo, filesystem, sql)")
+	flags.String("path", "flows", "The directory path used by the filesystem backend")
+	flags.String("table", "TailfedStorage", "The name of the DynamoDB table used by the dynamo backend")
+	flags.String("driver", "sqlite", "The type of database used by the sql backend (choices: sqlite, postgres)")
+	flags.String("dsn", "tailfed.db", "The data source name used to connect to the database for the sql backend")
+}
+
+// open connects to the configured storage. The database is only returned for the sql backend.
+func (so *storageOptions) open(ctx context.Context) (storage.Backend, *database.DB, error) {
+	logger := logrus.WithFields(map[string]any{
+		"component": "storage",
+		"backend":   so.Backend,
+	})
+
+	switch so.Backend {
+	case "filesystem":
+		store, err := storage.NewFilesystem(logger, so.Path)
+		return store, nil, err
+	case "dynamo":
+		config, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+
+		store, err := storage.NewDynamo(logger, config, so.Table)
+		return store, nil, err
+	case "sql":
+		db, err := database.Open(ctx, logger, database.Dialect(so.Driver), so.DSN)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		store, err := storage.NewSQL(logger, db)
+		return store, db, err
+	default:
+		return nil, nil, errors.New("unknown storage backend")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/sweeper"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type sweep struct {
+	storageOptions `koanf:",squash"`
+
+	BatchSize int  `koanf:"batch-size"`
+	DryRun    bool `koanf:"dry-run"`
+}
+
+func newSweep() *cobra.Command {
+	s := &sweep{}
+	cmd := &cobra.Command{
+		Use:     "sweep",
+		Short:   "Delete expired flows from storage",
+		Long:    "Deletes every flow that has expired, in batches. Expired rate limit counters and idempotency keys are also removed from SQL databases.",
+		Args:    cobra.NoArgs,
+		PreRunE: structureConfigInto(s),
+		RunE:    s.Run,
+	}
+
+	addStorageFlags(cmd.Flags())
+
+	cmd.Flags().Int("batch-size", sweeper.DefaultBatchSize, "How many expired flows are removed at once")
+	cmd.Flags().Bool("dry-run", false, "Only count the expired flows without deleting them")
+
+	return cmd
+}
+
+func (s *sweep) Run(cmd *cobra.Command, _ []string) error {
+	store, db, err := s.open(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	var opts []sweeper.Option
+	if db != nil {
+		defer db.Close()
+		opts = append(opts, sweeper.WithPruner(db))
+	}
+	if pruner, ok := store.(sweeper.Pruner); ok {
+		opts = append(opts, sweeper.WithPruner(pruner))
+	}
+
+	now := time.Now()
+	if s.DryRun {
+		flows, err := store.List(cmd.Context(), storage.Filter{ExpiredBefore: now})
+		if err != nil {
+			return fmt.Errorf("failed to list flows: %w", err)
+		}
+
+		logrus.WithField("expired", len(flows)).Info("found expired flows")
+		return nil
+	}
+
+	opts = append(opts, sweeper.WithBatchSize(s.BatchSize))
+	removed, err := sweeper.New(logrus.WithField("component", "sweeper"), store, opts...).Sweep(cmd.Context(), now)
+	if err != nil {
+		return fmt.Errorf("failed to sweep flows: %w", err)
+	}
+
+	logrus.WithField("removed", removed).Info("swept expired flows")
+	return nil
+}
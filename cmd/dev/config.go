@@ -4,13 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"time"
 
+	"github.com/akrantz01/tailfed/internal/http/gateway"
 	"github.com/akrantz01/tailfed/internal/launcher"
-	"github.com/akrantz01/tailfed/internal/metadata"
-	"github.com/akrantz01/tailfed/internal/signing"
-	"github.com/akrantz01/tailfed/internal/storage"
-	"github.com/akrantz01/tailfed/internal/tailscale"
+	"github.com/akrantz01/tailfed/internal/server"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/sirupsen/logrus"
@@ -19,32 +16,71 @@ import (
 var cfg config
 
 type config struct {
-	LogLevel string `koanf:"log-level"`
-	Address  string `koanf:"address"`
-
-	Launcher  launcherConfig  `koanf:"launcher"`
-	Metadata  metadataConfig  `koanf:"metadata"`
-	Signing   signingConfig   `koanf:"signing"`
-	Storage   storageConfig   `koanf:"storage"`
-	Tailscale tailscaleConfig `koanf:"tailscale"`
+	LogLevel      string                `koanf:"log-level"`
+	Address       string                `koanf:"address"`
+	PayloadFormat gateway.PayloadFormat `koanf:"payload-format"`
+
+	Audit     server.AuditConfig     `koanf:"audit"`
+	Database  server.DatabaseConfig  `koanf:"database"`
+	Issuer    server.IssuerConfig    `koanf:"issuer"`
+	Launcher  launcherConfig         `koanf:"launcher"`
+	Limits    server.LimitsConfig    `koanf:"limits"`
+	Metadata  server.MetadataConfig  `koanf:"metadata"`
+	Signing   server.SigningConfig   `koanf:"signing"`
+	Storage   server.StorageConfig   `koanf:"storage"`
+	Sweeper   server.SweeperConfig   `koanf:"sweeper"`
+	Tailscale server.TailscaleConfig `koanf:"tailscale"`
+	Verifier  server.VerifierConfig  `koanf:"verifier"`
 }
 
 func (c *config) LoadAWSConfig() (aws.Config, error) {
-	if c.Launcher.Backend == "step-function" ||
-		c.Metadata.Bucket == "s3" ||
+	if c.Audit.Backend == "dynamo" ||
+		c.Launcher.Backend == "step-function" ||
+		c.Launcher.Backend == "sqs" ||
+		c.Metadata.Backend == "s3" ||
 		c.Signing.Backend == "kms" ||
-		c.Storage.Backend == "dynamo" {
+		c.Storage.Backend == "dynamo" ||
+		len(c.Storage.Encryption.KMSKey) != 0 {
 		return awsconfig.LoadDefaultConfig(context.Background())
 	}
 
 	return aws.Config{}, nil
 }
 
+// UsesDatabase checks whether any backend stores its data in the SQL database
+func (c *config) UsesDatabase() bool {
+	return c.Audit.Backend == "sql" || c.Storage.Backend == "sql"
+}
+
 func (c *config) Validate() error {
+	if err := c.PayloadFormat.Validate(); err != nil {
+		return err
+	} else if c.PayloadFormat == gateway.PayloadALB {
+		return errors.New("alb payload format cannot be served locally")
+	}
+
+	if err := c.Audit.Validate(); err != nil {
+		return fmt.Errorf("audit configuration is invalid: %w", err)
+	}
+
+	if c.UsesDatabase() {
+		if err := c.Database.Validate(); err != nil {
+			return fmt.Errorf("database configuration is invalid: %w", err)
+		}
+	}
+
+	if err := c.Issuer.Validate(); err != nil {
+		return fmt.Errorf("issuer configuration is invalid: %w", err)
+	}
+
 	if err := c.Launcher.Validate(); err != nil {
 		return fmt.Errorf("launcher configuration is invalid: %w", err)
 	}
 
+	if err := c.Limits.Validate(); err != nil {
+		return fmt.Errorf("limits configuration is invalid: %w", err)
+	}
+
 	if err := c.Metadata.Validate(); err != nil {
 		return fmt.Errorf("metadata configuration is invalid: %w", err)
 	}
@@ -57,12 +93,22 @@ func (c *config) Validate() error {
 		return fmt.Errorf("storage configuration is invalid: %w", err)
 	}
 
+	if err := c.Sweeper.Validate(); err != nil {
+		return fmt.Errorf("sweeper configuration is invalid: %w", err)
+	}
+
 	if err := c.Tailscale.Validate(); err != nil {
 		return fmt.Errorf("tailscale configuration is invalid: %w", err)
 	}
 
-	if c.Launcher.Backend == "step-function" && c.Storage.Backend != "dynamo" {
-		return errors.New("step-function launcher backend requires dynamo storage backend")
+	if c.Launcher.Backend == "local" || c.Launcher.Backend == "inline" {
+		if err := c.Verifier.Validate(); err != nil {
+			return fmt.Errorf("verifier configuration is invalid: %w", err)
+		}
+	}
+
+	if (c.Launcher.Backend == "step-function" || c.Launcher.Backend == "sqs") && c.Storage.Backend != "dynamo" {
+		return fmt.Errorf("%s launcher backend requires dynamo storage backend", c.Launcher.Backend)
 	}
 
 	return nil
@@ -71,6 +117,7 @@ func (c *config) Validate() error {
 type launcherConfig struct {
 	Backend      string `koanf:"backend"`
 	StateMachine string `koanf:"state-machine"`
+	QueueURL     string `koanf:"queue-url"`
 }
 
 func (l *launcherConfig) Validate() error {
@@ -78,178 +125,25 @@ func (l *launcherConfig) Validate() error {
 		return errors.New("missing state machine arn for step-function backend")
 	}
 
+	if l.Backend == "sqs" && len(l.QueueURL) == 0 {
+		return errors.New("missing queue url for sqs backend")
+	}
+
 	return nil
 }
 
-func (l *launcherConfig) NewBackend(config aws.Config, bus chan<- launcher.Request) (launcher.Backend, error) {
+// NewBackend creates a remote launcher, the local and inline launchers are created from the verifier configuration
+func (l *launcherConfig) NewBackend(config aws.Config) (launcher.Backend, error) {
 	logger := logrus.WithFields(map[string]any{
 		"component": "launcher",
 		"backend":   l.Backend,
 	})
 	switch l.Backend {
-	case "local":
-		return launcher.NewLocal(logger, bus), nil
 	case "step-function":
 		return launcher.NewStepFunction(logger, config, l.StateMachine)
+	case "sqs":
+		return launcher.NewSQS(logger, config, l.QueueURL)
 	default:
 		return nil, errors.New("unknown launcher backend")
 	}
 }
-
-type metadataConfig struct {
-	Backend string `koanf:"backend"`
-	Bucket  string `koanf:"bucket"`
-	Path    string `koanf:"path"`
-}
-
-func (m *metadataConfig) Validate() error {
-	if m.Backend == "filesystem" && len(m.Path) == 0 {
-		return errors.New("missing path for filesystem backend")
-	}
-
-	if m.Backend == "dynamo" && len(m.Backend) == 0 {
-		return errors.New("missing bucket for s3 backend")
-	}
-
-	return nil
-}
-
-func (m *metadataConfig) NewBackend(config aws.Config) (metadata.Backend, error) {
-	logger := logrus.WithFields(map[string]any{
-		"component": "metadata",
-		"backend":   m.Backend,
-	})
-	switch m.Backend {
-	case "filesystem":
-		return metadata.NewFilesystem(logger, m.Path)
-	case "s3":
-		return metadata.NewS3(logger, config, m.Bucket)
-	default:
-		return nil, errors.New("unknown metadata backend")
-	}
-}
-
-type signingConfig struct {
-	Backend  string        `koanf:"backend"`
-	Validity time.Duration `koanf:"validity"`
-	Key      string        `koanf:"key"`
-	Audience string        `koanf:"audience"`
-}
-
-func (s *signingConfig) Validate() error {
-	if len(s.Audience) == 0 {
-		return errors.New("token audience cannot be empty")
-	}
-
-	if s.Validity <= 0 {
-		return errors.New("token validity must be positive")
-	}
-
-	if s.Backend == "kms" && len(s.Key) == 0 {
-		return errors.New("missing key for kms backend")
-	}
-
-	return nil
-}
-
-func (s *signingConfig) NewBackend(config aws.Config) (signing.Backend, error) {
-	logger := logrus.WithFields(map[string]any{
-		"component": "signer",
-		"backend":   s.Backend,
-	})
-	switch s.Backend {
-	case "memory":
-		return signing.NewInMemory(logger)
-	case "kms":
-		return signing.NewKMS(logger, config, s.Key)
-	default:
-		return nil, errors.New("unknown signing backend")
-	}
-}
-
-type storageConfig struct {
-	Backend string `koanf:"backend"`
-	Path    string `koanf:"path"`
-	Table   string `koanf:"table"`
-}
-
-func (s *storageConfig) Validate() error {
-	if s.Backend == "filesystem" && len(s.Path) == 0 {
-		return errors.New("missing path for filesystem backend")
-	}
-
-	if s.Backend == "dynamo" && len(s.Table) == 0 {
-		return errors.New("missing table for dynamo backend")
-	}
-
-	return nil
-}
-
-func (s *storageConfig) NewBackend(config aws.Config) (storage.Backend, error) {
-	logger := logrus.WithFields(map[string]any{
-		"component": "storage",
-		"backend":   s.Backend,
-	})
-	switch s.Backend {
-	case "filesystem":
-		return storage.NewFilesystem(logger, s.Path)
-	case "dynamo":
-		return storage.NewDynamo(logger, config, s.Table)
-	default:
-		return nil, errors.New("unknown storage backend")
-	}
-}
-
-type tailscaleConfig struct {
-	Backend string `koanf:"backend"`
-	BaseUrl string `koanf:"base-url"`
-
-	Tailnet string `koanf:"tailnet"`
-
-	ApiKey string               `koanf:"api-key"`
-	OAuth  tailscaleOAuthConfig `koanf:"oauth"`
-
-	TLSMode tailscale.TLSMode `koanf:"tls-mode"`
-}
-
-func (t *tailscaleConfig) Validate() error {
-	if len(t.BaseUrl) == 0 {
-		return errors.New("a base url must be configured")
-	}
-
-	if len(t.Tailnet) == 0 {
-		return errors.New("a tailnet must be configured")
-	}
-
-	if (len(t.ApiKey) > 0) == t.OAuth.Enabled() {
-		return errors.New("exactly one tailscale authentication method must be enabled")
-	}
-
-	if t.Backend == "headscale" && t.OAuth.Enabled() {
-		return errors.New("oauth-based authentication not supported by headscale")
-	}
-
-	return nil
-}
-
-func (t *tailscaleConfig) NewClient() (tailscale.ControlPlane, error) {
-	logger := logrus.WithField("component", "tailscale")
-	return tailscale.NewControlPlane(logger, t.Backend, t.BaseUrl, t.Tailnet, t.Authentication(), t.TLSMode)
-}
-
-func (t *tailscaleConfig) Authentication() tailscale.Authentication {
-	if len(t.ApiKey) > 0 {
-		return tailscale.ApiKey(t.ApiKey)
-	}
-
-	return tailscale.OAuth(t.OAuth.ClientId, t.OAuth.ClientSecret)
-}
-
-type tailscaleOAuthConfig struct {
-	ClientId     string `koanf:"client-id"`
-	ClientSecret string `koanf:"client-secret"`
-}
-
-func (o *tailscaleOAuthConfig) Enabled() bool {
-	return len(o.ClientId) != 0 && len(o.ClientSecret) != 0
-}
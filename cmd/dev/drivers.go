@@ -0,0 +1,7 @@
+package main
+
+// Register the database drivers used by the sql backends
+import (
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
@@ -11,10 +11,13 @@ import (
 	"time"
 
 	"github.com/akrantz01/tailfed/internal/configloader"
+	"github.com/akrantz01/tailfed/internal/database"
 	"github.com/akrantz01/tailfed/internal/generator"
 	"github.com/akrantz01/tailfed/internal/http/gateway"
 	"github.com/akrantz01/tailfed/internal/launcher"
 	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/server"
+	"github.com/akrantz01/tailfed/internal/sweeper"
 	"github.com/akrantz01/tailfed/internal/types"
 	"github.com/akrantz01/tailfed/internal/version"
 	"github.com/sirupsen/logrus"
@@ -38,21 +41,63 @@ func main() {
 
 	cmd.Flags().StringP("log-level", "l", "info", "The minimum level to log at (choices: panic, fatal, error, warn, info, debug, trace)")
 	cmd.Flags().StringP("address", "a", "127.0.0.1:8000", "The address and port combination to listen on")
+	cmd.Flags().String("payload-format", string(gateway.PayloadREST), "The event format to convert requests through (choices: rest, http, url)")
 
-	cmd.Flags().String("launcher.backend", "local", "Where to launch the verification flow (choices: local, step-function)")
+	cmd.Flags().String("audit.backend", "filesystem", "Where to record issued tokens (choices: memory, filesystem, dynamo, sql)")
+	cmd.Flags().String("audit.path", "audit.jsonl", "The file path used by the filesystem backend")
+	cmd.Flags().String("audit.table", "", "The name of the DynamoDB table used by the dynamo backend")
+
+	cmd.Flags().String("database.driver", "sqlite", "The type of database used by the sql backends (choices: sqlite, postgres)")
+	cmd.Flags().String("database.dsn", "tailfed.db", "The data source name used to connect to the database")
+
+	cmd.Flags().String("issuer.url", gateway.BaseUrl, "The canonical issuer URL included in tokens and the discovery document")
+	cmd.Flags().StringSlice("issuer.alternates", []string{"localhost", "127.0.0.1"}, "Additional hostnames requests are accepted on")
+	cmd.Flags().String("issuer.policy", "log", "What to do with requests on unknown hostnames (choices: reject, log)")
+	cmd.Flags().Bool("issuer.trust-proxy", false, "Use the X-Forwarded-Host header to determine the hostname, only enable behind a proxy that sets it")
+
+	cmd.Flags().String("launcher.backend", "local", "Where to launch the verification flow (choices: local, inline, sqs, step-function)")
 	cmd.Flags().String("launcher.state-machine", "", "The ARN of the state machine to use for the step-function backend")
+	cmd.Flags().String("launcher.queue-url", "", "The URL of the queue to use for the sqs backend")
+
+	cmd.Flags().Int("limits.node.requests", 0, "How many flows can be started per node within the window, 0 for unlimited")
+	cmd.Flags().Duration("limits.node.window", 1*time.Hour, "The window node request limits apply over")
+	cmd.Flags().Int("limits.source.requests", 0, "How many flows can be started per source address within the window, 0 for unlimited")
+	cmd.Flags().Duration("limits.source.window", 1*time.Hour, "The window source address request limits apply over")
+	cmd.Flags().Int("limits.pending", 0, "How many flows can be in-progress for a node at once, 0 for unlimited")
 
 	cmd.Flags().String("metadata.backend", "filesystem", "Where to store OpenID Connect metadata (choices: filesystem, s3)")
 	cmd.Flags().String("metadata.bucket", "", "The bucket to store metadata in for the s3 backend")
 	cmd.Flags().String("metadata.path", "metadata", "The directory path used by the filesystem backend")
+	cmd.Flags().String("metadata.service-documentation", "", "A URL to human-readable documentation to advertise in the discovery document")
+	cmd.Flags().StringToString("metadata.extensions", nil, "Additional fields to include in the discovery document")
 
-	cmd.Flags().String("signing.backend", "memory", "The method used to sign JWTs (choices: memory, kms)")
+	cmd.Flags().String("signing.backend", "memory", "The method used to sign JWTs (choices: memory, kms, static, vault)")
 	cmd.Flags().Duration("signing.validity", 1*time.Hour, "How long the generated tokens should be valid for")
 	cmd.Flags().String("signing.audience", "sts.amazonaws.com", "The audience the tokens are issued for")
-
-	cmd.Flags().String("storage.backend", "filesystem", "Where to store data for in-flight flows (choices: dynamo, filesystem)")
+	cmd.Flags().String("signing.key", "", "The KMS key ID, ARN, or alias used by the kms backend")
+	cmd.Flags().String("signing.path", "", "The path to a PEM or JWK encoded private key used by the static backend")
+	cmd.Flags().String("signing.private-key", "", "A PEM or JWK encoded private key used by the static backend, can be a file:// or AWS secret reference")
+	cmd.Flags().String("signing.vault.address", "", "The address of the Vault server, defaults to VAULT_ADDR")
+	cmd.Flags().String("signing.vault.mount", "transit", "The path the Vault transit secrets engine is mounted at")
+	cmd.Flags().String("signing.vault.key", "", "The name of the Vault transit key to sign with")
+	cmd.Flags().String("signing.vault.token", "", "The Vault token to authenticate with")
+	cmd.Flags().String("signing.vault.approle.mount", "approle", "The path the Vault AppRole auth method is mounted at")
+	cmd.Flags().String("signing.vault.approle.role-id", "", "The AppRole role ID to authenticate with")
+	cmd.Flags().String("signing.vault.approle.secret-id", "", "The AppRole secret ID to authenticate with")
+	cmd.Flags().String("signing.vault.jwt.mount", "jwt", "The path the Vault JWT auth method is mounted at")
+	cmd.Flags().String("signing.vault.jwt.role", "", "The JWT auth role to authenticate as")
+	cmd.Flags().String("signing.vault.jwt.token", "", "The JWT to authenticate with, can be a file:// reference")
+
+	cmd.Flags().String("storage.backend", "filesystem", "Where to store data for in-flight flows (choices: dynamo, filesystem, sql)")
 	cmd.Flags().String("storage.path", "flows", "The directory path used by the filesystem backend")
 	cmd.Flags().String("storage.table", "", "The name of the DynamoDB table used by the dynamo backend")
+	cmd.Flags().String("storage.encryption.kms-key", "", "The KMS key ID, ARN, or alias used to wrap flow encryption keys")
+	cmd.Flags().String("storage.encryption.key-file", "", "The path to a base64-encoded 32-byte key used to wrap flow encryption keys")
+	cmd.Flags().Bool("storage.encryption.identity", false, "Also encrypt the node's public key, DNS name, machine name, and hostname")
+	cmd.Flags().Bool("storage.encryption.allow-plaintext", false, "Accept flows stored before encryption was enabled, only while migrating")
+
+	cmd.Flags().Duration("sweeper.interval", 1*time.Minute, "How often expired flows are removed from storage, 0 to disable")
+	cmd.Flags().Int("sweeper.batch-size", sweeper.DefaultBatchSize, "How many expired flows are removed at once")
 
 	cmd.Flags().String("tailscale.backend", "hosted", "The control plane API type to use (choices: hosted, headscale)")
 	cmd.Flags().String("tailscale.base-url", "https://api.tailscale.com", "The base URL to use for the Tailscale API")
@@ -62,6 +107,14 @@ func main() {
 	cmd.Flags().String("tailscale.oauth.client-secret", "", "The Tailscale OAuth client secret to authenticate with")
 	cmd.Flags().String("tailscale.tls-mode", "full", "The level of TLS security for the headscale connection (choices: none, insecure, full)")
 
+	cmd.Flags().String("verifier.policy", "any", "Which addresses must respond to a challenge for it to be verified (choices: any, all, prefer-ipv4, prefer-ipv6)")
+	cmd.Flags().Duration("verifier.timeout", 1*time.Second, "How long to wait for a client to respond to a challenge")
+	cmd.Flags().Int("verifier.workers", 4, "How many flows are verified concurrently by the local launcher")
+	cmd.Flags().Int("verifier.queue-size", 16, "How many flows can wait for a worker before new flows are rejected")
+	cmd.Flags().Int("verifier.max-attempts", 6, "How many times a challenge is attempted before the flow fails")
+	cmd.Flags().Duration("verifier.backoff", 500*time.Millisecond, "How long to wait after the first failed attempt, doubling each time")
+	cmd.Flags().Duration("verifier.max-backoff", 10*time.Second, "The longest to wait between attempts")
+
 	err := cmd.Execute()
 	if err != nil {
 		logrus.Error(err)
@@ -97,10 +150,23 @@ func run(*cobra.Command, []string) error {
 		return fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	bus := make(chan launcher.Request, 3)
-	launch, err := cfg.Launcher.NewBackend(awsConfig, bus)
+	issuer, err := cfg.Issuer.NewIssuer()
+	if err != nil {
+		return fmt.Errorf("failed to create issuer: %w", err)
+	}
+
+	var db *database.DB
+	if cfg.UsesDatabase() {
+		db, err = cfg.Database.Open(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+		defer db.Close()
+	}
+
+	auditLog, err := cfg.Audit.NewBackend(awsConfig, db)
 	if err != nil {
-		return fmt.Errorf("failed to create launcher backend: %w", err)
+		return fmt.Errorf("failed to create audit backend: %w", err)
 	}
 
 	meta, err := cfg.Metadata.NewBackend(awsConfig)
@@ -113,7 +179,7 @@ func run(*cobra.Command, []string) error {
 		return fmt.Errorf("failed to create signing backend: %w", err)
 	}
 
-	store, err := cfg.Storage.NewBackend(awsConfig)
+	store, err := cfg.Storage.NewBackend(awsConfig, db)
 	if err != nil {
 		return fmt.Errorf("failed to open storage: %w", err)
 	}
@@ -124,19 +190,46 @@ func run(*cobra.Command, []string) error {
 	}
 
 	logrus.Info("generating metadata documents")
-	gen := generator.New(cfg.Signing.Validity, meta, signer)
-	if err := gen.Serve(context.Background(), types.GenerateRequest{Issuer: gateway.BaseUrl}); err != nil {
+	gen := generator.New(issuer, cfg.Signing.Validity, meta, signer, cfg.Metadata.DiscoveryOptions()...)
+	if err := gen.Serve(context.Background(), types.GenerateRequest{}); err != nil {
 		return fmt.Errorf("failed to generate metadata documents: %w", err)
 	}
 
-	var stopLauncher func()
-	if cfg.Launcher.Backend == "local" {
-		stopLauncher = startLauncher(bus, store, cfg.Tailscale.Tailnet)
-	} else {
-		stopLauncher = func() {}
+	var launch launcher.Backend
+	stopLauncher := func() {}
+	switch cfg.Launcher.Backend {
+	case "local":
+		pool := cfg.Verifier.NewLauncher(&http.Client{}, store, cfg.Tailscale.Tailnet)
+		pool.Start()
+		launch, stopLauncher = pool, pool.Stop
+	case "inline":
+		launch = cfg.Verifier.NewInlineLauncher(&http.Client{}, store, cfg.Tailscale.Tailnet)
+	default:
+		launch, err = cfg.Launcher.NewBackend(awsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create launcher backend: %w", err)
+		}
+	}
+
+	stopSweeper := func() {}
+	if cfg.Sweeper.Enabled() {
+		ctx, cancel := context.WithCancel(context.Background())
+		go cfg.Sweeper.NewSweeper(store, db).Run(ctx, cfg.Sweeper.Interval)
+		stopSweeper = cancel
 	}
 
-	srv, serverErrors := startGateway(tsClient, launch, meta, signer, store)
+	srv, serverErrors := startGateway(&server.Backends{
+		Issuer:    issuer,
+		Limits:    cfg.Limits.Limits(),
+		Tailscale: tsClient,
+		Launcher:  launch,
+		Signer:    signer,
+		Store:     store,
+		Audit:     auditLog,
+		Audience:  cfg.Signing.Audience,
+		Validity:  cfg.Signing.Validity,
+		Payload:   cfg.PayloadFormat,
+	}, meta)
 
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
@@ -154,6 +247,7 @@ func run(*cobra.Command, []string) error {
 		defer cancel()
 
 		stopLauncher()
+		stopSweeper()
 
 		if err := srv.Shutdown(ctx); err != nil {
 			logrus.WithError(err).Fatal("failed to shutdown server")
@@ -4,11 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/akrantz01/tailfed/internal/audit"
 	"github.com/akrantz01/tailfed/internal/configloader"
 	"github.com/akrantz01/tailfed/internal/finalizer"
+	"github.com/akrantz01/tailfed/internal/http/gateway"
 	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/oidc"
 	"github.com/akrantz01/tailfed/internal/signing"
 	"github.com/akrantz01/tailfed/internal/storage"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -34,6 +38,11 @@ func main() {
 		logrus.WithError(err).Fatal("failed to initialize logging")
 	}
 
+	issuer, err := config.Issuer.NewIssuer()
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to initialize issuer")
+	}
+
 	signer, err := signing.NewKMS(logrus.WithField("component", "signer"), awsConfig, config.Signing.Key)
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to initialize signer")
@@ -44,18 +53,55 @@ func main() {
 		logrus.WithError(err).Fatal("failed to initialize store")
 	}
 
-	handler := finalizer.New(config.Signing.Audience, config.Signing.Validity, signer, store)
-	lambda.Start(handler.Serve)
+	if len(config.Storage.EncryptionKey) != 0 {
+		wrapper := storage.NewKMSKeyWrapper(logrus.WithField("component", "storage.keys"), awsConfig, config.Storage.EncryptionKey)
+		store = storage.NewEncrypted(logrus.WithField("component", "storage"), store, wrapper, config.Storage.EncryptIdentity, config.Storage.AllowPlaintext)
+	}
+
+	auditLog, err := audit.NewDynamo(logrus.WithField("component", "audit"), awsConfig, config.Audit.Table)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to initialize audit log")
+	}
+
+	handler := gateway.Methods{
+		http.MethodPost: finalizer.New(issuer, config.Signing.Audience, config.Signing.Validity, signer, store, auditLog),
+		// Function URLs and load balancers do not extract path parameters
+		http.MethodGet: gateway.WithPathParameters("/flows/{id}", finalizer.NewStatus(issuer, store)),
+	}
+
+	adapted, err := gateway.Adapt(config.PayloadFormat, handler)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to adapt handler to payload format")
+	}
+	lambda.Start(adapted)
 }
 
 type Config struct {
-	LogLevel string `koanf:"log-level"`
+	LogLevel      string                `koanf:"log-level"`
+	Issuer        oidc.Config           `koanf:"issuer"`
+	PayloadFormat gateway.PayloadFormat `koanf:"payload-format"`
 
+	Audit   Audit   `koanf:"audit"`
 	Signing Signing `koanf:"signing"`
 	Storage Storage `koanf:"storage"`
 }
 
 func (c *Config) Validate() error {
+	if len(c.PayloadFormat) == 0 {
+		c.PayloadFormat = gateway.PayloadREST
+	}
+	if err := c.PayloadFormat.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Issuer.Validate(); err != nil {
+		return fmt.Errorf("invalid issuer config: %w", err)
+	}
+
+	if err := c.Audit.Validate(); err != nil {
+		return fmt.Errorf("invalid audit config: %w", err)
+	}
+
 	if err := c.Signing.Validate(); err != nil {
 		return fmt.Errorf("invalid signing config: %w", err)
 	}
@@ -67,6 +113,18 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+type Audit struct {
+	Table string `koanf:"table"`
+}
+
+func (a *Audit) Validate() error {
+	if len(a.Table) == 0 {
+		return errors.New("missing DynamoDB audit table name")
+	}
+
+	return nil
+}
+
 type Signing struct {
 	Audience string        `koanf:"audience"`
 	Key      string        `koanf:"key"`
@@ -90,7 +148,10 @@ func (s *Signing) Validate() error {
 }
 
 type Storage struct {
-	Table string `koanf:"table"`
+	Table           string `koanf:"table"`
+	EncryptionKey   string `koanf:"encryption-key"`
+	EncryptIdentity bool   `koanf:"encrypt-identity"`
+	AllowPlaintext  bool   `koanf:"allow-plaintext"`
 }
 
 func (s *Storage) Validate() error {
@@ -10,6 +10,7 @@ import (
 	"github.com/akrantz01/tailfed/internal/generator"
 	"github.com/akrantz01/tailfed/internal/logging"
 	"github.com/akrantz01/tailfed/internal/metadata"
+	"github.com/akrantz01/tailfed/internal/oidc"
 	"github.com/akrantz01/tailfed/internal/signing"
 	"github.com/aws/aws-lambda-go/lambda"
 	aws "github.com/aws/aws-sdk-go-v2/config"
@@ -34,6 +35,11 @@ func main() {
 		logrus.WithError(err).Fatal("failed to initialize logging")
 	}
 
+	issuer, err := config.Issuer.NewIssuer()
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to initialize issuer")
+	}
+
 	meta, err := metadata.NewS3(logrus.WithField("component", "metadata"), awsConfig, config.Metadata.Bucket)
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to initialize metadata")
@@ -44,18 +50,23 @@ func main() {
 		logrus.WithError(err).Fatal("failed to initialize signer")
 	}
 
-	handler := generator.New(config.Signing.Validity, meta, signer)
+	handler := generator.New(issuer, config.Signing.Validity, meta, signer, config.Metadata.DiscoveryOptions()...)
 	lambda.Start(handler.Serve)
 }
 
 type Config struct {
-	LogLevel string `koanf:"log-level"`
+	LogLevel string      `koanf:"log-level"`
+	Issuer   oidc.Config `koanf:"issuer"`
 
 	Metadata Metadata `koanf:"metadata"`
 	Signing  Signing  `koanf:"signing"`
 }
 
 func (c *Config) Validate() error {
+	if err := c.Issuer.Validate(); err != nil {
+		return fmt.Errorf("invalid issuer config: %w", err)
+	}
+
 	if err := c.Metadata.Validate(); err != nil {
 		return fmt.Errorf("invalid metadata config: %w", err)
 	}
@@ -69,6 +80,9 @@ func (c *Config) Validate() error {
 
 type Metadata struct {
 	Bucket string `koanf:"bucket"`
+
+	ServiceDocumentation string         `koanf:"service-documentation"`
+	Extensions           map[string]any `koanf:"extensions"`
 }
 
 func (m *Metadata) Validate() error {
@@ -79,6 +93,14 @@ func (m *Metadata) Validate() error {
 	return nil
 }
 
+// DiscoveryOptions builds the optional fields to include in the discovery document
+func (m *Metadata) DiscoveryOptions() []oidc.DiscoveryOption {
+	return []oidc.DiscoveryOption{
+		oidc.WithServiceDocumentation(m.ServiceDocumentation),
+		oidc.WithExtensions(m.Extensions),
+	}
+}
+
 type Signing struct {
 	Key      string        `koanf:"key"`
 	Validity time.Duration `koanf:"validity"`
@@ -5,20 +5,23 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/akrantz01/tailfed/internal/configloader"
+	"github.com/akrantz01/tailfed/internal/http/gateway"
 	"github.com/akrantz01/tailfed/internal/initializer"
-	"github.com/akrantz01/tailfed/internal/launcher"
+	"github.com/akrantz01/tailfed/internal/launcher/setup"
 	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/oidc"
 	"github.com/akrantz01/tailfed/internal/storage"
 	"github.com/akrantz01/tailfed/internal/tailscale"
 	"github.com/aws/aws-lambda-go/lambda"
-	aws "github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
-	awsConfig, err := aws.LoadDefaultConfig(context.Background())
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background())
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to load AWS config from environment")
 	}
@@ -35,14 +38,14 @@ func main() {
 		logrus.WithError(err).Fatal("failed to initialize logging")
 	}
 
-	tsClient, err := config.Tailscale.Client()
+	issuer, err := config.Issuer.NewIssuer()
 	if err != nil {
-		logrus.WithError(err).Fatal("failed to create tailscale client")
+		logrus.WithError(err).Fatal("failed to initialize issuer")
 	}
 
-	launch, err := launcher.NewStepFunction(logrus.WithField("component", "launcher"), awsConfig, config.Launcher.StateMachine)
+	tsClient, err := config.Tailscale.Client()
 	if err != nil {
-		logrus.WithError(err).Fatal("failed to initialize launcher")
+		logrus.WithError(err).Fatal("failed to create tailscale client")
 	}
 
 	store, err := storage.NewDynamo(logrus.WithField("component", "storage"), awsConfig, config.Storage.Table)
@@ -50,23 +53,56 @@ func main() {
 		logrus.WithError(err).Fatal("failed to initialize store")
 	}
 
-	handler := initializer.New(tsClient, launch, store)
-	lambda.Start(handler.Serve)
+	if len(config.Storage.EncryptionKey) != 0 {
+		wrapper := storage.NewKMSKeyWrapper(logrus.WithField("component", "storage.keys"), awsConfig, config.Storage.EncryptionKey)
+		store = storage.NewEncrypted(logrus.WithField("component", "storage"), store, wrapper, config.Storage.EncryptIdentity, config.Storage.AllowPlaintext)
+	}
+
+	launch, err := config.Launcher.Build(awsConfig, store, config.Tailscale.Tailnet)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to initialize launcher")
+	}
+
+	handler := initializer.New(issuer, config.Limits.Limits(), tsClient, launch, store)
+
+	adapted, err := gateway.Adapt(config.PayloadFormat, handler)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to adapt handler to payload format")
+	}
+	lambda.Start(adapted)
 }
 
 type Config struct {
-	LogLevel string `koanf:"log-level"`
-
-	Launcher  Launcher  `koanf:"launcher"`
-	Tailscale Tailscale `koanf:"tailscale"`
-	Storage   Storage   `koanf:"storage"`
+	LogLevel      string                `koanf:"log-level"`
+	Issuer        oidc.Config           `koanf:"issuer"`
+	PayloadFormat gateway.PayloadFormat `koanf:"payload-format"`
+
+	Launcher  setup.Launcher `koanf:"launcher"`
+	Limits    Limits         `koanf:"limits"`
+	Tailscale Tailscale      `koanf:"tailscale"`
+	Storage   Storage        `koanf:"storage"`
 }
 
 func (c *Config) Validate() error {
+	if len(c.PayloadFormat) == 0 {
+		c.PayloadFormat = gateway.PayloadREST
+	}
+	if err := c.PayloadFormat.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Issuer.Validate(); err != nil {
+		return fmt.Errorf("invalid issuer config: %w", err)
+	}
+
 	if err := c.Launcher.Validate(); err != nil {
 		return fmt.Errorf("invalid launcher config: %w", err)
 	}
 
+	if err := c.Limits.Validate(); err != nil {
+		return fmt.Errorf("invalid limits config: %w", err)
+	}
+
 	if err := c.Tailscale.Validate(); err != nil {
 		return fmt.Errorf("invalid tailscale config: %w", err)
 	}
@@ -78,13 +114,49 @@ func (c *Config) Validate() error {
 	return nil
 }
 
-type Launcher struct {
-	StateMachine string `koanf:"state-machine"`
+type Limits struct {
+	Node    Rate `koanf:"node"`
+	Source  Rate `koanf:"source"`
+	Pending int  `koanf:"pending"`
 }
 
-func (l *Launcher) Validate() error {
-	if len(l.StateMachine) == 0 {
-		return errors.New("missing state machine identifier")
+func (l *Limits) Validate() error {
+	if err := l.Node.Validate(); err != nil {
+		return fmt.Errorf("invalid node rate: %w", err)
+	}
+
+	if err := l.Source.Validate(); err != nil {
+		return fmt.Errorf("invalid source rate: %w", err)
+	}
+
+	if l.Pending < 0 {
+		return errors.New("pending flow cap cannot be negative")
+	}
+
+	return nil
+}
+
+// Limits converts the configuration into initializer limits
+func (l *Limits) Limits() initializer.Limits {
+	return initializer.Limits{
+		Node:    initializer.Rate{Requests: l.Node.Requests, Window: l.Node.Window},
+		Source:  initializer.Rate{Requests: l.Source.Requests, Window: l.Source.Window},
+		Pending: l.Pending,
+	}
+}
+
+type Rate struct {
+	Requests int           `koanf:"requests"`
+	Window   time.Duration `koanf:"window"`
+}
+
+func (r *Rate) Validate() error {
+	if r.Requests < 0 {
+		return errors.New("requests cannot be negative")
+	}
+
+	if r.Requests > 0 && r.Window <= 0 {
+		return errors.New("window must be positive")
 	}
 
 	return nil
@@ -139,7 +211,10 @@ func (t *Tailscale) Client() (tailscale.ControlPlane, error) {
 }
 
 type Storage struct {
-	Table string `koanf:"table"`
+	Table           string `koanf:"table"`
+	EncryptionKey   string `koanf:"encryption-key"`
+	EncryptIdentity bool   `koanf:"encrypt-identity"`
+	AllowPlaintext  bool   `koanf:"allow-plaintext"`
 }
 
 func (s *Storage) Validate() error {
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/audit"
+	"github.com/akrantz01/tailfed/internal/configloader"
+	"github.com/akrantz01/tailfed/internal/finalizer"
+	"github.com/akrantz01/tailfed/internal/generator"
+	"github.com/akrantz01/tailfed/internal/http/gateway"
+	"github.com/akrantz01/tailfed/internal/initializer"
+	"github.com/akrantz01/tailfed/internal/launcher/setup"
+	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/oidc"
+	"github.com/akrantz01/tailfed/internal/signing"
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/tailscale"
+	"github.com/aws/aws-lambda-go/lambda"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to load AWS config from environment")
+	}
+
+	var config Config
+	if err := configloader.LoadInto(&config, configloader.WithEnvPrefix("TAILFED_"), configloader.WithSecrets(awsConfig)); err != nil {
+		logrus.WithError(err).Fatal("failed to load configuration")
+	}
+	if err := config.Validate(); err != nil {
+		logrus.WithError(err).Fatal("invalid configuration")
+	}
+
+	if err := logging.Initialize(config.LogLevel); err != nil {
+		logrus.WithError(err).Fatal("failed to initialize logging")
+	}
+
+	issuer, err := config.Issuer.NewIssuer()
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to initialize issuer")
+	}
+
+	tsClient, err := config.Tailscale.Client()
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to create tailscale client")
+	}
+
+	signer, err := signing.NewKMS(logrus.WithField("component", "signer"), awsConfig, config.Signing.Key)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to initialize signer")
+	}
+
+	store, err := storage.NewDynamo(logrus.WithField("component", "storage"), awsConfig, config.Storage.Table)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to initialize store")
+	}
+
+	if len(config.Storage.EncryptionKey) != 0 {
+		wrapper := storage.NewKMSKeyWrapper(logrus.WithField("component", "storage.keys"), awsConfig, config.Storage.EncryptionKey)
+		store = storage.NewEncrypted(logrus.WithField("component", "storage"), store, wrapper, config.Storage.EncryptIdentity, config.Storage.AllowPlaintext)
+	}
+
+	launch, err := config.Launcher.Build(awsConfig, store, config.Tailscale.Tailnet)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to initialize launcher")
+	}
+
+	auditLog, err := audit.NewDynamo(logrus.WithField("component", "audit"), awsConfig, config.Audit.Table)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to initialize audit log")
+	}
+
+	// Documents are generated from the signer on first use rather than read from the metadata bucket
+	cache := generator.NewCache(logrus.WithField("component", "metadata"), issuer, config.Signing.Validity, config.Metadata.Refresh, signer, config.Metadata.DiscoveryOptions()...)
+
+	router := gateway.NewRouter()
+	router.Handle(http.MethodPost, "/start", initializer.New(issuer, config.Limits.Limits(), tsClient, launch, store))
+	router.Handle(http.MethodPost, "/finalize", finalizer.New(issuer, config.Signing.Audience, config.Signing.Validity, signer, store, auditLog))
+	router.Handle(http.MethodGet, "/flows/{id}", finalizer.NewStatus(issuer, store))
+	router.Handle(http.MethodGet, "/config.json", cache.Handler("config.json"))
+	router.Handle(http.MethodGet, "/version.json", cache.Handler("version.json"))
+	router.Handle(http.MethodGet, "/.well-known/openid-configuration", cache.Handler("openid-configuration"))
+	router.Handle(http.MethodGet, "/.well-known/jwks.json", cache.Handler("jwks.json"))
+
+	adapted, err := gateway.Adapt(config.PayloadFormat, router)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to adapt handler to payload format")
+	}
+	lambda.Start(adapted)
+}
+
+type Config struct {
+	LogLevel      string                `koanf:"log-level"`
+	Issuer        oidc.Config           `koanf:"issuer"`
+	PayloadFormat gateway.PayloadFormat `koanf:"payload-format"`
+
+	Audit     Audit          `koanf:"audit"`
+	Launcher  setup.Launcher `koanf:"launcher"`
+	Limits    Limits         `koanf:"limits"`
+	Metadata  Metadata       `koanf:"metadata"`
+	Signing   Signing        `koanf:"signing"`
+	Storage   Storage        `koanf:"storage"`
+	Tailscale Tailscale      `koanf:"tailscale"`
+}
+
+func (c *Config) Validate() error {
+	if len(c.PayloadFormat) == 0 {
+		c.PayloadFormat = gateway.PayloadREST
+	}
+	if err := c.PayloadFormat.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Issuer.Validate(); err != nil {
+		return fmt.Errorf("invalid issuer config: %w", err)
+	}
+
+	if err := c.Audit.Validate(); err != nil {
+		return fmt.Errorf("invalid audit config: %w", err)
+	}
+
+	if err := c.Launcher.Validate(); err != nil {
+		return fmt.Errorf("invalid launcher config: %w", err)
+	}
+
+	if err := c.Limits.Validate(); err != nil {
+		return fmt.Errorf("invalid limits config: %w", err)
+	}
+
+	if err := c.Metadata.Validate(); err != nil {
+		return fmt.Errorf("invalid metadata config: %w", err)
+	}
+
+	if err := c.Signing.Validate(); err != nil {
+		return fmt.Errorf("invalid signing config: %w", err)
+	}
+
+	if err := c.Storage.Validate(); err != nil {
+		return fmt.Errorf("invalid storage config: %w", err)
+	}
+
+	if err := c.Tailscale.Validate(); err != nil {
+		return fmt.Errorf("invalid tailscale config: %w", err)
+	}
+
+	return nil
+}
+
+type Audit struct {
+	Table string `koanf:"table"`
+}
+
+func (a *Audit) Validate() error {
+	if len(a.Table) == 0 {
+		return errors.New("missing DynamoDB audit table name")
+	}
+
+	return nil
+}
+
+type Limits struct {
+	Node    Rate `koanf:"node"`
+	Source  Rate `koanf:"source"`
+	Pending int  `koanf:"pending"`
+}
+
+func (l *Limits) Validate() error {
+	if err := l.Node.Validate(); err != nil {
+		return fmt.Errorf("invalid node rate: %w", err)
+	}
+
+	if err := l.Source.Validate(); err != nil {
+		return fmt.Errorf("invalid source rate: %w", err)
+	}
+
+	if l.Pending < 0 {
+		return errors.New("pending flow cap cannot be negative")
+	}
+
+	return nil
+}
+
+// Limits converts the configuration into initializer limits
+func (l *Limits) Limits() initializer.Limits {
+	return initializer.Limits{
+		Node:    initializer.Rate{Requests: l.Node.Requests, Window: l.Node.Window},
+		Source:  initializer.Rate{Requests: l.Source.Requests, Window: l.Source.Window},
+		Pending: l.Pending,
+	}
+}
+
+type Rate struct {
+	Requests int           `koanf:"requests"`
+	Window   time.Duration `koanf:"window"`
+}
+
+func (r *Rate) Validate() error {
+	if r.Requests < 0 {
+		return errors.New("requests cannot be negative")
+	}
+
+	if r.Requests > 0 && r.Window <= 0 {
+		return errors.New("window must be positive")
+	}
+
+	return nil
+}
+
+type Metadata struct {
+	Refresh time.Duration `koanf:"refresh"`
+
+	ServiceDocumentation string         `koanf:"service-documentation"`
+	Extensions           map[string]any `koanf:"extensions"`
+}
+
+func (m *Metadata) Validate() error {
+	if m.Refresh == 0 {
+		m.Refresh = time.Hour
+	} else if m.Refresh < 0 {
+		return errors.New("refresh interval must be positive")
+	}
+
+	return nil
+}
+
+// DiscoveryOptions builds the optional fields to include in the discovery document
+func (m *Metadata) DiscoveryOptions() []oidc.DiscoveryOption {
+	return []oidc.DiscoveryOption{
+		oidc.WithServiceDocumentation(m.ServiceDocumentation),
+		oidc.WithExtensions(m.Extensions),
+	}
+}
+
+type Signing struct {
+	Audience string        `koanf:"audience"`
+	Key      string        `koanf:"key"`
+	Validity time.Duration `koanf:"validity"`
+}
+
+func (s *Signing) Validate() error {
+	if len(s.Audience) == 0 {
+		return errors.New("missing audience identifier")
+	}
+
+	if len(s.Key) == 0 {
+		return errors.New("missing KMS signing key")
+	}
+
+	if s.Validity <= 0 {
+		return errors.New("token validity must be positive")
+	}
+
+	return nil
+}
+
+type Storage struct {
+	Table           string `koanf:"table"`
+	EncryptionKey   string `koanf:"encryption-key"`
+	EncryptIdentity bool   `koanf:"encrypt-identity"`
+	AllowPlaintext  bool   `koanf:"allow-plaintext"`
+}
+
+func (s *Storage) Validate() error {
+	if len(s.Table) == 0 {
+		return errors.New("missing DynamoDB table name")
+	}
+
+	return nil
+}
+
+type Tailscale struct {
+	Backend string `koanf:"backend"`
+	BaseUrl string `koanf:"base-url"`
+
+	Tailnet string `koanf:"tailnet"`
+
+	ApiKey            string            `koanf:"api-key"`
+	OAuthClientId     string            `koanf:"oauth-client-id"`
+	OAuthClientSecret string            `koanf:"oauth-client-secret"`
+	TLSMode           tailscale.TLSMode `koanf:"tls-mode"`
+
+	auth tailscale.Authentication
+}
+
+func (t *Tailscale) Validate() error {
+	if len(t.BaseUrl) == 0 {
+		t.BaseUrl = "https://api.tailscale.com"
+	}
+	if baseUrl, err := url.Parse(t.BaseUrl); err != nil {
+		return fmt.Errorf("invalid base url: %w", err)
+	} else if baseUrl.Scheme != "http" && baseUrl.Scheme != "https" {
+		return errors.New("base url scheme must be http or https")
+	}
+
+	if len(t.Tailnet) == 0 {
+		return errors.New("missing tailnet name")
+	}
+
+	apiKeyEnabled := len(t.ApiKey) != 0
+	oauthEnabled := len(t.OAuthClientId) != 0 && len(t.OAuthClientSecret) != 0
+	if apiKeyEnabled == oauthEnabled {
+		return errors.New("exactly one authentication method must be configured")
+	}
+
+	if apiKeyEnabled {
+		t.auth = tailscale.ApiKey(t.ApiKey)
+	} else {
+		t.auth = tailscale.OAuth(t.OAuthClientId, t.OAuthClientSecret)
+	}
+
+	return nil
+}
+
+func (t *Tailscale) Client() (tailscale.ControlPlane, error) {
+	logger := logrus.WithField("component", "tailscale")
+	return tailscale.NewControlPlane(logger, t.Backend, t.BaseUrl, t.Tailnet, t.auth, t.TLSMode)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/server"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+var cfg config
+
+type config struct {
+	LogLevel          string        `koanf:"log-level"`
+	Address           string        `koanf:"address"`
+	TrustForwardedFor bool          `koanf:"trust-forwarded-for"`
+	ShutdownTimeout   time.Duration `koanf:"shutdown-timeout"`
+
+	Audit     server.AuditConfig     `koanf:"audit"`
+	Database  server.DatabaseConfig  `koanf:"database"`
+	Generator generatorConfig        `koanf:"generator"`
+	Issuer    server.IssuerConfig    `koanf:"issuer"`
+	Limits    server.LimitsConfig    `koanf:"limits"`
+	Metadata  server.MetadataConfig  `koanf:"metadata"`
+	Node      nodeConfig             `koanf:"node"`
+	Signing   server.SigningConfig   `koanf:"signing"`
+	Storage   server.StorageConfig   `koanf:"storage"`
+	Sweeper   server.SweeperConfig   `koanf:"sweeper"`
+	Tailscale server.TailscaleConfig `koanf:"tailscale"`
+	TLS       tlsConfig              `koanf:"tls"`
+	Verifier  server.VerifierConfig  `koanf:"verifier"`
+}
+
+func (c *config) LoadAWSConfig() (aws.Config, error) {
+	if c.Audit.Backend == "dynamo" ||
+		c.Metadata.Backend == "s3" ||
+		c.Signing.Backend == "kms" ||
+		c.Storage.Backend == "dynamo" ||
+		len(c.Storage.Encryption.KMSKey) != 0 {
+		return awsconfig.LoadDefaultConfig(context.Background())
+	}
+
+	return aws.Config{}, nil
+}
+
+// UsesDatabase checks whether any backend stores its data in the SQL database
+func (c *config) UsesDatabase() bool {
+	return c.Audit.Backend == "sql" || c.Storage.Backend == "sql"
+}
+
+func (c *config) Validate() error {
+	if len(c.Address) == 0 {
+		return errors.New("missing listen address")
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		return errors.New("shutdown timeout must be positive")
+	}
+
+	if err := c.Audit.Validate(); err != nil {
+		return fmt.Errorf("audit configuration is invalid: %w", err)
+	}
+
+	if c.UsesDatabase() {
+		if err := c.Database.Validate(); err != nil {
+			return fmt.Errorf("database configuration is invalid: %w", err)
+		}
+	}
+
+	if err := c.Generator.Validate(); err != nil {
+		return fmt.Errorf("generator configuration is invalid: %w", err)
+	}
+
+	if err := c.Issuer.Validate(); err != nil {
+		return fmt.Errorf("issuer configuration is invalid: %w", err)
+	}
+
+	if err := c.Limits.Validate(); err != nil {
+		return fmt.Errorf("limits configuration is invalid: %w", err)
+	}
+
+	if err := c.Metadata.Validate(); err != nil {
+		return fmt.Errorf("metadata configuration is invalid: %w", err)
+	}
+
+	if err := c.Node.Validate(); err != nil {
+		return fmt.Errorf("node configuration is invalid: %w", err)
+	}
+
+	if err := c.Signing.Validate(); err != nil {
+		return fmt.Errorf("signing configuration is invalid: %w", err)
+	}
+
+	if err := c.Storage.Validate(); err != nil {
+		return fmt.Errorf("storage configuration is invalid: %w", err)
+	}
+
+	if err := c.Sweeper.Validate(); err != nil {
+		return fmt.Errorf("sweeper configuration is invalid: %w", err)
+	}
+
+	if err := c.Tailscale.Validate(); err != nil {
+		return fmt.Errorf("tailscale configuration is invalid: %w", err)
+	}
+
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("tls configuration is invalid: %w", err)
+	}
+
+	if err := c.Verifier.Validate(); err != nil {
+		return fmt.Errorf("verifier configuration is invalid: %w", err)
+	}
+
+	if c.Node.API.Enabled && c.TLS.Mode == "tailscale" {
+		return errors.New("tailscale tls mode cannot be used with the tailnet-only api, the discovery documents must be public")
+	}
+
+	// Tokens and audit records must survive restarts, otherwise issued tokens cannot be verified or traced
+	if c.Signing.Backend == "memory" {
+		return errors.New("memory signing backend uses ephemeral keys, use one of kms, static, or vault")
+	}
+
+	if c.Audit.Backend == "memory" {
+		return errors.New("memory audit backend is not durable, use one of filesystem, dynamo, or sql")
+	}
+
+	return nil
+}
+
+type generatorConfig struct {
+	Interval time.Duration `koanf:"interval"`
+}
+
+func (g *generatorConfig) Validate() error {
+	if g.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+
+	return nil
+}
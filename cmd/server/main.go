@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/configloader"
+	"github.com/akrantz01/tailfed/internal/database"
+	"github.com/akrantz01/tailfed/internal/generator"
+	"github.com/akrantz01/tailfed/internal/http/forwarded"
+	"github.com/akrantz01/tailfed/internal/http/requestid"
+	"github.com/akrantz01/tailfed/internal/http/whois"
+	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/scheduler"
+	"github.com/akrantz01/tailfed/internal/server"
+	"github.com/akrantz01/tailfed/internal/sweeper"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/akrantz01/tailfed/internal/version"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	info := version.GetInfo()
+
+	cmd := &cobra.Command{
+		Use:     "tailfed-server",
+		Version: info.Version,
+		Short:   "Runs the Tailfed API as a single self-hosted service",
+		Long: `
+Runs every Tailfed component in a single process for deployments without AWS Lambda. Challenges are
+verified through an embedded Tailscale node, and the OpenID Connect metadata is regenerated on a schedule.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PreRunE:       preRun,
+		RunE:          run,
+	}
+
+	cmd.SetVersionTemplate("{{.Version}}\n")
+
+	cmd.Flags().StringP("config", "c", "tailfed-server.yml", "The path to the configuration file")
+	cmd.Flags().StringP("log-level", "l", "info", "The minimum level to log at (choices: panic, fatal, error, warn, info, debug, trace)")
+	cmd.Flags().StringP("address", "a", ":8000", "The address and port combination to listen on")
+	cmd.Flags().Bool("trust-forwarded-for", false, "Use the X-Forwarded-For header to determine the client address, only enable behind a proxy that sets it")
+	cmd.Flags().Duration("shutdown-timeout", 30*time.Second, "How long to wait for in-flight requests to complete when shutting down")
+
+	cmd.Flags().String("audit.backend", "sql", "Where to record issued tokens (choices: filesystem, dynamo, sql)")
+	cmd.Flags().String("audit.path", "audit.jsonl", "The file path used by the filesystem backend")
+	cmd.Flags().String("audit.table", "", "The name of the DynamoDB table used by the dynamo backend")
+
+	cmd.Flags().String("database.driver", "sqlite", "The type of database used by the sql backends (choices: sqlite, postgres)")
+	cmd.Flags().String("database.dsn", "tailfed.db", "The data source name used to connect to the database")
+
+	cmd.Flags().Duration("generator.interval", 24*time.Hour, "How often the OpenID Connect metadata documents are regenerated")
+
+	cmd.Flags().String("issuer.url", "", "The canonical issuer URL included in tokens and the discovery document")
+	cmd.Flags().StringSlice("issuer.alternates", nil, "Additional hostnames requests are accepted on")
+	cmd.Flags().String("issuer.policy", "reject", "What to do with requests on unknown hostnames (choices: reject, log)")
+	cmd.Flags().Bool("issuer.trust-proxy", false, "Use the X-Forwarded-Host header to determine the hostname, only enable behind a proxy that sets it")
+
+	cmd.Flags().Int("limits.node.requests", 0, "How many flows can be started per node within the window, 0 for unlimited")
+	cmd.Flags().Duration("limits.node.window", 1*time.Hour, "The window node request limits apply over")
+	cmd.Flags().Int("limits.source.requests", 0, "How many flows can be started per source address within the window, 0 for unlimited")
+	cmd.Flags().Duration("limits.source.window", 1*time.Hour, "The window source address request limits apply over")
+	cmd.Flags().Int("limits.pending", 0, "How many flows can be in-progress for a node at once, 0 for unlimited")
+
+	cmd.Flags().String("metadata.backend", "filesystem", "Where to store OpenID Connect metadata (choices: filesystem, s3)")
+	cmd.Flags().String("metadata.bucket", "", "The bucket to store metadata in for the s3 backend")
+	cmd.Flags().String("metadata.path", "metadata", "The directory path used by the filesystem backend")
+	cmd.Flags().String("metadata.service-documentation", "", "A URL to human-readable documentation to advertise in the discovery document")
+	cmd.Flags().StringToString("metadata.extensions", nil, "Additional fields to include in the discovery document")
+
+	cmd.Flags().String("node.hostname", "tailfed", "The hostname of the embedded Tailscale node")
+	cmd.Flags().String("node.state-dir", "tailscale", "The directory the embedded Tailscale node persists its state in")
+	cmd.Flags().String("node.auth-key", "", "The auth key used to register the embedded Tailscale node, only required on first start")
+	cmd.Flags().String("node.control-url", "", "The coordination server URL, defaults to Tailscale's (set for Headscale)")
+	cmd.Flags().Bool("node.ephemeral", false, "Whether the embedded Tailscale node is removed from the tailnet when it goes offline")
+	cmd.Flags().Bool("node.api.enabled", false, "Serve the client API only on the embedded Tailscale node, the discovery documents remain public")
+	cmd.Flags().String("node.api.address", ":80", "The address and port combination to listen on within the tailnet")
+	cmd.Flags().Bool("node.api.tls", false, "Use the tailnet's HTTPS certificates for the tailnet listener")
+
+	cmd.Flags().String("signing.backend", "static", "The method used to sign JWTs (choices: kms, static, vault)")
+	cmd.Flags().Duration("signing.validity", 1*time.Hour, "How long the generated tokens should be valid for")
+	cmd.Flags().String("signing.audience", "sts.amazonaws.com", "The audience the tokens are issued for")
+	cmd.Flags().String("signing.key", "", "The KMS key ID, ARN, or alias used by the kms backend")
+	cmd.Flags().String("signing.path", "", "The path to a PEM or JWK encoded private key used by the static backend")
+	cmd.Flags().String("signing.private-key", "", "A PEM or JWK encoded private key used by the static backend, can be a file:// or AWS secret reference")
+	cmd.Flags().String("signing.vault.address", "", "The address of the Vault server, defaults to VAULT_ADDR")
+	cmd.Flags().String("signing.vault.mount", "transit", "The path the Vault transit secrets engine is mounted at")
+	cmd.Flags().String("signing.vault.key", "", "The name of the Vault transit key to sign with")
+	cmd.Flags().String("signing.vault.token", "", "The Vault token to authenticate with")
+	cmd.Flags().String("signing.vault.approle.mount", "approle", "The path the Vault AppRole auth method is mounted at")
+	cmd.Flags().String("signing.vault.approle.role-id", "", "The AppRole role ID to authenticate with")
+	cmd.Flags().String("signing.vault.approle.secret-id", "", "The AppRole secret ID to authenticate with")
+	cmd.Flags().String("signing.vault.jwt.mount", "jwt", "The path the Vault JWT auth method is mounted at")
+	cmd.Flags().String("signing.vault.jwt.role", "", "The JWT auth role to authenticate as")
+	cmd.Flags().String("signing.vault.jwt.token", "", "The JWT to authenticate with, can be a file:// reference")
+
+	cmd.Flags().String("storage.backend", "sql", "Where to store data for in-flight flows (choices: dynamo, filesystem, sql)")
+	cmd.Flags().String("storage.path", "flows", "The directory path used by the filesystem backend")
+	cmd.Flags().String("storage.table", "", "The name of the DynamoDB table used by the dynamo backend")
+	cmd.Flags().String("storage.encryption.kms-key", "", "The KMS key ID, ARN, or alias used to wrap flow encryption keys")
+	cmd.Flags().String("storage.encryption.key-file", "", "The path to a base64-encoded 32-byte key used to wrap flow encryption keys")
+	cmd.Flags().Bool("storage.encryption.identity", false, "Also encrypt the node's public key, DNS name, machine name, and hostname")
+	cmd.Flags().Bool("storage.encryption.allow-plaintext", false, "Accept flows stored before encryption was enabled, only while migrating")
+
+	cmd.Flags().Duration("sweeper.interval", 1*time.Minute, "How often expired flows are removed from storage, 0 to disable")
+	cmd.Flags().Int("sweeper.batch-size", sweeper.DefaultBatchSize, "How many expired flows are removed at once")
+
+	cmd.Flags().String("tailscale.backend", "hosted", "The control plane API type to use (choices: hosted, headscale)")
+	cmd.Flags().String("tailscale.base-url", "https://api.tailscale.com", "The base URL to use for the Tailscale API")
+	cmd.Flags().String("tailscale.tailnet", "", "The name of the tailnet to issue tokens for")
+	cmd.Flags().String("tailscale.api-key", "", "The Tailscale API key to authenticate with")
+	cmd.Flags().String("tailscale.oauth.client-id", "", "The Tailscale OAuth client ID to authenticate with")
+	cmd.Flags().String("tailscale.oauth.client-secret", "", "The Tailscale OAuth client secret to authenticate with")
+	cmd.Flags().String("tailscale.tls-mode", "full", "The level of TLS security for the headscale connection (choices: none, insecure, full)")
+
+	cmd.Flags().String("tls.mode", "none", "How TLS is terminated (choices: none, static, acme, tailscale)")
+	cmd.Flags().String("tls.cert-file", "", "The path to a PEM encoded certificate chain used by static mode")
+	cmd.Flags().String("tls.key-file", "", "The path to a PEM encoded private key used by static mode")
+	cmd.Flags().Duration("tls.reload-interval", 1*time.Minute, "How often the certificate and key are checked for changes in static mode")
+	cmd.Flags().String("tls.acme.email", "", "The contact email to register with the ACME directory")
+	cmd.Flags().String("tls.acme.cache-dir", "acme", "The directory ACME accounts and certificates are cached in")
+	cmd.Flags().String("tls.acme.directory-url", "", "The ACME directory to request certificates from, defaults to Let's Encrypt")
+	cmd.Flags().String("tls.acme.http-address", "", "The address to serve HTTP-01 challenges on, TLS-ALPN-01 is used when empty")
+
+	cmd.Flags().String("verifier.policy", "any", "Which addresses must respond to a challenge for it to be verified (choices: any, all, prefer-ipv4, prefer-ipv6)")
+	cmd.Flags().Duration("verifier.timeout", 5*time.Second, "How long to wait for a client to respond to a challenge")
+	cmd.Flags().Int("verifier.workers", 8, "How many flows are verified concurrently")
+	cmd.Flags().Int("verifier.queue-size", 64, "How many flows can wait for a worker before new flows are rejected")
+	cmd.Flags().Int("verifier.max-attempts", 6, "How many times a challenge is attempted before the flow fails")
+	cmd.Flags().Duration("verifier.backoff", 500*time.Millisecond, "How long to wait after the first failed attempt, doubling each time")
+	cmd.Flags().Duration("verifier.max-backoff", 10*time.Second, "The longest to wait between attempts")
+
+	err := cmd.Execute()
+	if err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+}
+
+// preRun loads and validates the configuration
+func preRun(cmd *cobra.Command, _ []string) error {
+	path, _ := cmd.Flags().GetString("config")
+	err := configloader.LoadInto(&cfg,
+		configloader.WithFlags(cmd.Flags()),
+		configloader.WithEnvPrefix("TAILFED_SERVER_"),
+		configloader.IncludeConfigFile(path),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if err := logging.Initialize(cfg.LogLevel); err != nil {
+		return err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	return nil
+}
+
+// run starts every component and serves the API until a shutdown signal is received
+func run(*cobra.Command, []string) error {
+	awsConfig, err := cfg.LoadAWSConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	readiness := server.NewReadiness()
+
+	var db *database.DB
+	if cfg.UsesDatabase() {
+		db, err = cfg.Database.Open(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+		defer db.Close()
+
+		readiness.Add("database", db.PingContext)
+	}
+
+	auditLog, err := cfg.Audit.NewBackend(awsConfig, db)
+	if err != nil {
+		return fmt.Errorf("failed to create audit backend: %w", err)
+	}
+
+	meta, err := cfg.Metadata.NewBackend(awsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata backend: %w", err)
+	}
+
+	signer, err := cfg.Signing.NewBackend(awsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create signing backend: %w", err)
+	}
+
+	store, err := cfg.Storage.NewBackend(awsConfig, db)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	tsClient, err := cfg.Tailscale.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to create tailscale api client: %w", err)
+	}
+
+	node, status, err := cfg.Node.Connect(cfg.Tailscale.Tailnet)
+	if err != nil {
+		return fmt.Errorf("failed to connect to tailscale: %w", err)
+	}
+	defer node.Close()
+
+	readiness.Add("node", nodeReady(node))
+
+	// Clients reach the tailnet listener using the node's MagicDNS name
+	if cfg.Node.API.Enabled && status.Self != nil {
+		cfg.Issuer.Alternates = append(cfg.Issuer.Alternates, status.Self.DNSName)
+	}
+
+	issuer, err := cfg.Issuer.NewIssuer()
+	if err != nil {
+		return fmt.Errorf("failed to create issuer: %w", err)
+	}
+
+	logrus.Info("generating metadata documents")
+	gen := generator.New(issuer, cfg.Signing.Validity, meta, signer, cfg.Metadata.DiscoveryOptions()...)
+	if err := gen.Serve(context.Background(), types.GenerateRequest{}); err != nil {
+		return fmt.Errorf("failed to generate metadata documents: %w", err)
+	}
+
+	regenerate := scheduler.NewScheduler(context.Background(), cfg.Generator.Interval, func(ctx context.Context) error {
+		return gen.Serve(ctx, types.GenerateRequest{})
+	})
+	regenerate.Start()
+	defer regenerate.Stop()
+
+	launch := cfg.Verifier.NewLauncher(node.HTTPClient(), store, cfg.Tailscale.Tailnet)
+	launch.Start()
+	defer launch.Stop()
+
+	if cfg.Sweeper.Enabled() {
+		go cfg.Sweeper.NewSweeper(store, db).Run(ctx, cfg.Sweeper.Interval)
+	}
+
+	backends := &server.Backends{
+		Issuer:    issuer,
+		Limits:    cfg.Limits.Limits(),
+		Tailscale: tsClient,
+		Launcher:  launch,
+		Signer:    signer,
+		Store:     store,
+		Audit:     auditLog,
+		Audience:  cfg.Signing.Audience,
+		Validity:  cfg.Signing.Validity,
+	}
+
+	// The discovery documents must always be public so AWS can fetch them
+	mux := http.NewServeMux()
+	mux.Handle("GET /health", http.HandlerFunc(server.Health))
+	mux.Handle("GET /ready", readiness)
+	server.RegisterMetadata(mux, meta)
+	if !cfg.Node.API.Enabled {
+		server.RegisterAPI(mux, backends)
+	}
+
+	lis, err := cfg.TLS.Listen(ctx, cfg.Address, issuer.Hostname(), node)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	middleware := []func(http.Handler) http.Handler{requestid.Middleware}
+	if cfg.TrustForwardedFor {
+		middleware = append(middleware, forwarded.Middleware)
+	}
+	middleware = append(middleware, logging.Middleware)
+
+	servers := []*http.Server{server.New(cfg.Address, mux, middleware...)}
+	listeners := []net.Listener{lis}
+
+	if cfg.Node.API.Enabled {
+		whoIs, err := node.LocalClient()
+		if err != nil {
+			return fmt.Errorf("failed to get local tailscale client: %w", err)
+		}
+
+		tailnetMux := http.NewServeMux()
+		server.RegisterMetadata(tailnetMux, meta)
+		server.RegisterAPI(tailnetMux, backends)
+
+		tailnetLis, err := cfg.Node.API.Listen(node)
+		if err != nil {
+			return fmt.Errorf("failed to listen on tailnet: %w", err)
+		}
+
+		servers = append(servers, server.New(cfg.Node.API.Address, tailnetMux, requestid.Middleware, logging.Middleware, whois.Middleware(whoIs)))
+		listeners = append(listeners, tailnetLis)
+	}
+
+	serverErrors := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func() {
+			logrus.WithField("address", listeners[i].Addr().String()).Info("listening for requests")
+			serverErrors <- srv.Serve(listeners[i])
+		}()
+	}
+
+	logrus.WithFields(map[string]any{"tls": cfg.TLS.Mode, "tailnet-only": cfg.Node.API.Enabled}).Info("server is ready")
+	readiness.SetReady(true)
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErrors:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+
+	case sig := <-shutdown:
+		logrus.WithField("signal", sig.String()).Info("signal received, shutting down...")
+		readiness.SetReady(false)
+
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancelShutdown()
+
+		// Waits for in-flight requests to finish, including any finalize requests waiting on the verifier
+		for _, srv := range servers {
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				return fmt.Errorf("failed to shutdown server: %w", err)
+			}
+		}
+	}
+
+	logrus.Info("successfully shutdown, goodbye!")
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tsnet"
+)
+
+// nodeStartTimeout is how long to wait for the embedded node to connect to the tailnet
+const nodeStartTimeout = 30 * time.Second
+
+type nodeConfig struct {
+	Hostname   string `koanf:"hostname"`
+	StateDir   string `koanf:"state-dir"`
+	AuthKey    string `koanf:"auth-key"`
+	ControlURL string `koanf:"control-url"`
+	Ephemeral  bool   `koanf:"ephemeral"`
+
+	API nodeAPIConfig `koanf:"api"`
+}
+
+func (n *nodeConfig) Validate() error {
+	if len(n.Hostname) == 0 {
+		return errors.New("missing hostname")
+	}
+
+	if len(n.StateDir) == 0 {
+		return errors.New("missing state directory")
+	}
+
+	if err := n.API.Validate(); err != nil {
+		return fmt.Errorf("invalid api config: %w", err)
+	}
+
+	return nil
+}
+
+// Connect starts the embedded node used to reach clients over the tailnet
+func (n *nodeConfig) Connect(tailnet string) (*tsnet.Server, *ipnstate.Status, error) {
+	logger := logrus.WithField("component", "node")
+
+	ts := &tsnet.Server{
+		Hostname:   n.Hostname,
+		Dir:        n.StateDir,
+		AuthKey:    n.AuthKey,
+		ControlURL: n.ControlURL,
+		Ephemeral:  n.Ephemeral,
+		UserLogf:   logger.Infof,
+		Logf:       logger.Tracef,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), nodeStartTimeout)
+	defer cancel()
+
+	status, err := ts.Up(ctx)
+	if err != nil {
+		_ = ts.Close()
+		return nil, nil, err
+	}
+
+	if status.CurrentTailnet != nil && status.CurrentTailnet.Name != tailnet {
+		_ = ts.Close()
+		return nil, nil, fmt.Errorf("mismatch tailnets: expected %q but got %q", tailnet, status.CurrentTailnet.Name)
+	}
+
+	fields := map[string]any{"status": status.BackendState}
+	if status.CurrentTailnet != nil {
+		fields["tailnet"] = status.CurrentTailnet.Name
+	}
+	if status.Self != nil {
+		fields["id"] = status.Self.ID
+		fields["ips"] = status.Self.TailscaleIPs
+	}
+
+	logger.WithFields(fields).Info("successfully connected to tailscale")
+	return ts, status, nil
+}
+
+// nodeReady checks that the embedded node is still connected to the tailnet
+func nodeReady(ts *tsnet.Server) func(context.Context) error {
+	return func(ctx context.Context) error {
+		client, err := ts.LocalClient()
+		if err != nil {
+			return err
+		}
+
+		status, err := client.StatusWithoutPeers(ctx)
+		if err != nil {
+			return err
+		}
+
+		if status.BackendState != ipn.Running.String() {
+			return fmt.Errorf("node is %s", status.BackendState)
+		}
+
+		return nil
+	}
+}
+
+type nodeAPIConfig struct {
+	Enabled bool   `koanf:"enabled"`
+	Address string `koanf:"address"`
+	TLS     bool   `koanf:"tls"`
+}
+
+func (a *nodeAPIConfig) Validate() error {
+	if a.Enabled && len(a.Address) == 0 {
+		return errors.New("missing listen address")
+	}
+
+	return nil
+}
+
+// Listen binds the API listener on the embedded node, using the tailnet's HTTPS certificates if enabled
+func (a *nodeAPIConfig) Listen(node *tsnet.Server) (net.Listener, error) {
+	if !a.TLS {
+		return node.Listen("tcp", a.Address)
+	}
+
+	if len(node.CertDomains()) == 0 {
+		return nil, errors.New("HTTPS certificates must be enabled for the tailnet")
+	}
+
+	return node.ListenTLS("tcp", a.Address)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/server"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"tailscale.com/tsnet"
+)
+
+type tlsConfig struct {
+	Mode           string        `koanf:"mode"`
+	CertFile       string        `koanf:"cert-file"`
+	KeyFile        string        `koanf:"key-file"`
+	ReloadInterval time.Duration `koanf:"reload-interval"`
+
+	ACME tlsACMEConfig `koanf:"acme"`
+}
+
+func (t *tlsConfig) Validate() error {
+	switch t.Mode {
+	case "none", "tailscale":
+	case "static":
+		if len(t.CertFile) == 0 || len(t.KeyFile) == 0 {
+			return errors.New("cert file and key file are required for static mode")
+		}
+
+		if t.ReloadInterval <= 0 {
+			return errors.New("reload interval must be positive")
+		}
+	case "acme":
+		if err := t.ACME.Validate(); err != nil {
+			return fmt.Errorf("invalid acme config: %w", err)
+		}
+	default:
+		return errors.New("unknown tls mode")
+	}
+
+	return nil
+}
+
+// Listen binds the address, terminating TLS with a certificate valid for the hostname. Any background tasks needed
+// to maintain the certificate run until the context is cancelled.
+func (t *tlsConfig) Listen(ctx context.Context, address, hostname string, node *tsnet.Server) (net.Listener, error) {
+	logger := logrus.WithFields(map[string]any{
+		"component": "tls",
+		"mode":      t.Mode,
+	})
+
+	if t.Mode == "tailscale" {
+		if !slices.Contains(node.CertDomains(), hostname) {
+			return nil, fmt.Errorf("issuer hostname %q is not one of the node's certificate domains %v, HTTPS certificates must be enabled for the tailnet", hostname, node.CertDomains())
+		}
+
+		return node.ListenTLS("tcp", address)
+	}
+
+	var config *tls.Config
+	switch t.Mode {
+	case "none":
+		logger.Warn("serving without tls, the server must be behind a tls-terminating proxy")
+		return net.Listen("tcp", address)
+
+	case "static":
+		cert, err := server.NewStaticCertificate(logger, t.CertFile, t.KeyFile, hostname)
+		if err != nil {
+			return nil, err
+		}
+		go cert.Watch(ctx, t.ReloadInterval)
+
+		config = &tls.Config{
+			GetCertificate: cert.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}
+
+	case "acme":
+		var err error
+		if config, err = t.ACME.Config(ctx, logger, hostname); err != nil {
+			return nil, err
+		}
+	}
+
+	config.MinVersion = tls.VersionTLS12
+
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.NewListener(lis, config), nil
+}
+
+type tlsACMEConfig struct {
+	Email        string `koanf:"email"`
+	CacheDir     string `koanf:"cache-dir"`
+	DirectoryURL string `koanf:"directory-url"`
+	HTTPAddress  string `koanf:"http-address"`
+}
+
+func (a *tlsACMEConfig) Validate() error {
+	if len(a.CacheDir) == 0 {
+		return errors.New("missing cache directory")
+	}
+
+	return nil
+}
+
+// Config creates a TLS config that obtains certificates for the hostname from the ACME directory. Certificates are
+// issued using the TLS-ALPN-01 challenge, or the HTTP-01 challenge when an HTTP address is configured.
+func (a *tlsACMEConfig) Config(ctx context.Context, logger logrus.FieldLogger, hostname string) (*tls.Config, error) {
+	if net.ParseIP(hostname) != nil {
+		return nil, fmt.Errorf("acme certificates cannot be issued for ip address %q, the issuer must use a domain name", hostname)
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(a.CacheDir),
+		HostPolicy: autocert.HostWhitelist(hostname),
+		Email:      a.Email,
+	}
+	if len(a.DirectoryURL) != 0 {
+		manager.Client = &acme.Client{DirectoryURL: a.DirectoryURL}
+	}
+
+	if len(a.HTTPAddress) != 0 {
+		srv := &http.Server{
+			Addr:              a.HTTPAddress,
+			Handler:           manager.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			logger.WithField("address", a.HTTPAddress).Info("serving acme http challenges")
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.WithError(err).Error("acme http challenge server failed")
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			_ = srv.Close()
+		}()
+	}
+
+	return manager.TLSConfig(), nil
+}
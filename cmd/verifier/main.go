@@ -4,17 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/akrantz01/tailfed/internal/configloader"
+	"github.com/akrantz01/tailfed/internal/launcher"
+	"github.com/akrantz01/tailfed/internal/launcher/setup"
 	"github.com/akrantz01/tailfed/internal/logging"
 	"github.com/akrantz01/tailfed/internal/storage"
 	"github.com/akrantz01/tailfed/internal/verifier"
 	"github.com/aws/aws-lambda-go/lambda"
 	aws "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/sirupsen/logrus"
-	"tailscale.com/tsnet"
 )
 
 func main() {
@@ -35,7 +35,7 @@ func main() {
 		logrus.WithError(err).Fatal("failed to initialize logging")
 	}
 
-	ts, err := config.Tailscale.Connect()
+	ts, err := setup.Connect(config.Tailscale.AuthKey, config.Tailscale.Tailnet)
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to connect to tailscale")
 	}
@@ -45,21 +45,50 @@ func main() {
 		logrus.WithError(err).Fatal("failed to initialize store")
 	}
 
+	if len(config.Storage.EncryptionKey) != 0 {
+		wrapper := storage.NewKMSKeyWrapper(logrus.WithField("component", "storage.keys"), awsConfig, config.Storage.EncryptionKey)
+		store = storage.NewEncrypted(logrus.WithField("component", "storage"), store, wrapper, config.Storage.EncryptIdentity, config.Storage.AllowPlaintext)
+	}
+
 	client := ts.HTTPClient()
 	client.Timeout = 5 * time.Second
 
-	handler := verifier.New(client, store, config.Tailscale.Tailnet)
-	lambda.Start(handler.Serve)
+	handler := verifier.New(client, store, config.Tailscale.Tailnet, config.Policy)
+	if !config.Queue.Enabled() {
+		lambda.Start(handler.Serve)
+		return
+	}
+
+	queue, err := launcher.NewSQS(logrus.WithField("component", "queue"), awsConfig, config.Queue.URL)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to initialize queue")
+	}
+
+	queueHandler := verifier.NewQueue(handler, queue, store, config.Queue.Retry(), config.Queue.DeadLetterArn)
+	lambda.Start(queueHandler.Serve)
 }
 
 type Config struct {
-	LogLevel string `koanf:"log-level"`
+	LogLevel string          `koanf:"log-level"`
+	Policy   verifier.Policy `koanf:"policy"`
 
+	Queue     Queue     `koanf:"queue"`
 	Storage   Storage   `koanf:"storage"`
 	Tailscale Tailscale `koanf:"tailscale"`
 }
 
 func (c *Config) Validate() error {
+	if len(c.Policy) == 0 {
+		c.Policy = verifier.PolicyAny
+	}
+	if err := c.Policy.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Queue.Validate(); err != nil {
+		return fmt.Errorf("invalid queue config: %w", err)
+	}
+
 	if err := c.Storage.Validate(); err != nil {
 		return fmt.Errorf("invaild storage config: %w", err)
 	}
@@ -71,68 +100,78 @@ func (c *Config) Validate() error {
 	return nil
 }
 
-type Tailscale struct {
-	AuthKey string `koanf:"auth-key"`
-	Tailnet string `koanf:"tailnet"`
+// Queue configures the verifier to be triggered by SQS instead of a step function
+type Queue struct {
+	URL           string `koanf:"url"`
+	DeadLetterArn string `koanf:"dead-letter-arn"`
+
+	MaxAttempts int           `koanf:"max-attempts"`
+	Backoff     time.Duration `koanf:"backoff"`
+	MaxBackoff  time.Duration `koanf:"max-backoff"`
 }
 
-func (t *Tailscale) Validate() error {
-	if len(t.AuthKey) == 0 {
-		return errors.New("missing node auth key")
+func (q *Queue) Validate() error {
+	if !q.Enabled() {
+		return nil
 	}
 
-	if len(t.Tailnet) == 0 {
-		return errors.New("missing tailnet name")
+	if q.MaxAttempts == 0 {
+		q.MaxAttempts = 6
+	}
+	if q.Backoff == 0 {
+		q.Backoff = time.Second
+	}
+	if q.MaxBackoff == 0 {
+		q.MaxBackoff = time.Minute
 	}
 
-	return nil
-}
-
-func (t *Tailscale) Connect() (*tsnet.Server, error) {
-	logger := logrus.WithField("component", "tailscale")
-
-	hostname, err := os.Hostname()
-	if err != nil {
-		return nil, fmt.Errorf("could not get hostname: %w", err)
+	if q.MaxAttempts < 0 {
+		return errors.New("max attempts must be positive")
 	}
-	tsHostname := fmt.Sprintf("%s-%s-%s", os.Getenv("AWS_LAMBDA_FUNCTION_NAME"), os.Getenv("AWS_REGION"), hostname)
-	logger.WithField("hostname", hostname).Info("determined hostname")
 
-	ts := &tsnet.Server{
-		AuthKey:   t.AuthKey,
-		Ephemeral: true,
-		Hostname:  tsHostname,
-		Dir:       "/tmp",
-		UserLogf:  logger.Infof,
+	if q.Backoff < 0 || q.MaxBackoff < q.Backoff {
+		return errors.New("backoff must be positive and no more than the max backoff")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
+	return nil
+}
 
-	status, err := ts.Up(ctx)
-	if err != nil {
-		return nil, err
-	}
+// Enabled checks whether the verifier is triggered by SQS
+func (q *Queue) Enabled() bool {
+	return len(q.URL) != 0
+}
 
-	if status.CurrentTailnet.Name != t.Tailnet {
-		return nil, fmt.Errorf("mismatch tailnets: expected %q but got %q", t.Tailnet, status.CurrentTailnet.Name)
+// Retry converts the configuration into retry settings
+func (q *Queue) Retry() verifier.Retry {
+	return verifier.Retry{
+		MaxAttempts: q.MaxAttempts,
+		Backoff:     q.Backoff,
+		MaxBackoff:  q.MaxBackoff,
 	}
+}
 
-	fields := map[string]any{"status": status.BackendState}
-	if status.CurrentTailnet != nil {
-		fields["tailnet"] = status.CurrentTailnet.Name
+type Tailscale struct {
+	AuthKey string `koanf:"auth-key"`
+	Tailnet string `koanf:"tailnet"`
+}
+
+func (t *Tailscale) Validate() error {
+	if len(t.AuthKey) == 0 {
+		return errors.New("missing node auth key")
 	}
-	if status.Self != nil {
-		fields["id"] = status.Self.ID
-		fields["ips"] = status.Self.TailscaleIPs
+
+	if len(t.Tailnet) == 0 {
+		return errors.New("missing tailnet name")
 	}
 
-	logger.WithFields(fields).Info("successfully connected to tailscale")
-	return ts, nil
+	return nil
 }
 
 type Storage struct {
-	Table string `koanf:"table"`
+	Table           string `koanf:"table"`
+	EncryptionKey   string `koanf:"encryption-key"`
+	EncryptIdentity bool   `koanf:"encrypt-identity"`
+	AllowPlaintext  bool   `koanf:"allow-plaintext"`
 }
 
 func (s *Storage) Validate() error {
@@ -10,8 +10,11 @@ import (
 	"net/http"
 	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/akrantz01/tailfed/internal/proof"
 	"github.com/akrantz01/tailfed/internal/types"
 	"github.com/akrantz01/tailfed/internal/version"
 	"github.com/sirupsen/logrus"
@@ -51,8 +54,10 @@ func (c *Client) GetConfig(ctx context.Context) (*types.ConfigResponse, error) {
 	return info, err
 }
 
-// Start begins the ID token issuance process
-func (c *Client) Start(ctx context.Context, node string, addresses []string) (*types.StartResponse, error) {
+// Start begins the ID token issuance process. Retrying with the same idempotency key and addresses returns the flow
+// that was already started rather than starting another. If a secret is given, it is used to sign the challenge
+// instead of one generated by the server.
+func (c *Client) Start(ctx context.Context, node string, addresses []string, idempotencyKey string, secret []byte) (*types.StartResponse, error) {
 	ports := types.Ports{}
 	for _, address := range addresses {
 		addr := netip.MustParseAddrPort(address)
@@ -66,12 +71,34 @@ func (c *Client) Start(ctx context.Context, node string, addresses []string) (*t
 		}
 	}
 
-	return doApiRequest[types.StartResponse](c, ctx, "start", "POST", "/start", &types.StartRequest{Node: node, Ports: ports})
+	return doApiRequest[types.StartResponse](c, ctx, "start", "POST", "/start", &types.StartRequest{
+		Node:           node,
+		Ports:          ports,
+		IdempotencyKey: idempotencyKey,
+		SigningSecret:  secret,
+	})
+}
+
+// FlowStatus retrieves the state of a flow, waiting up to the given duration for its challenge to be verified
+func (c *Client) FlowStatus(ctx context.Context, id string, wait time.Duration) (*types.FlowStatusResponse, error) {
+	path := "/flows/" + url.PathEscape(id)
+	if seconds := int(wait.Seconds()); seconds > 0 {
+		path += "?wait=" + strconv.Itoa(seconds)
+	}
+
+	return doApiRequest[types.FlowStatusResponse](c, ctx, "flow-status", "GET", path, nil)
 }
 
-// Finalize attempts to finish the request flow and issue a token
-func (c *Client) Finalize(ctx context.Context, id string) (string, error) {
-	res, err := doApiRequest[types.FinalizeResponse](c, ctx, "finalize", "POST", "/finalize", &types.FinalizeRequest{ID: id})
+// Finalize attempts to finish the request flow and issue a token, proving ownership of the flow with its secret. The
+// server waits up to the given duration for the challenge to be verified if it supports doing so.
+func (c *Client) Finalize(ctx context.Context, id string, secret []byte, wait time.Duration) (string, error) {
+	now := time.Now()
+	res, err := doApiRequest[types.FinalizeResponse](c, ctx, "finalize", "POST", "/finalize", &types.FinalizeRequest{
+		ID:        id,
+		Timestamp: now.Unix(),
+		Proof:     proof.Finalize(secret, id, now),
+		Wait:      int(wait.Seconds()),
+	})
 	if err != nil {
 		return "", err
 	}
@@ -100,7 +127,7 @@ func parseBaseUrl(baseUrl string) (*url.URL, error) {
 // doApiRequest makes a request to the Tailfed server. It expects a response wrapped in a [types.Response] to determine
 // whether the action was a success or failure.
 func doApiRequest[R any](c *Client, ctx context.Context, name, method, path string, body any) (*R, error) {
-	res, status, err := doRequest[types.Response[R]](c, ctx, name, method, path, body)
+	res, meta, err := doRequest[types.Response[R]](c, ctx, name, method, path, body)
 	if err != nil {
 		return nil, err
 	}
@@ -109,15 +136,28 @@ func doApiRequest[R any](c *Client, ctx context.Context, name, method, path stri
 		return res.Data, nil
 	}
 
+	code := res.Code
+	if code == "" {
+		code = legacyErrorCode(meta.StatusCode)
+	}
+
 	return nil, &Error{
-		message: res.Error,
-		status:  status,
+		message:      res.Error,
+		code:         code,
+		status:       meta.StatusCode,
+		retryAfter:   parseRetryAfter(meta.Header.Get("Retry-After"), time.Now()),
+		verification: res.Verification,
 	}
 }
 
+// redactable is implemented by request bodies containing secrets which must not be logged
+type redactable interface {
+	Redacted() any
+}
+
 // doRequest makes a request to the Tailfed server. This should be a method, but Go does not support generics
 // in methods yet so we make do
-func doRequest[R any](c *Client, ctx context.Context, name, method, path string, body any) (*R, int, error) {
+func doRequest[R any](c *Client, ctx context.Context, name, method, path string, body any) (*R, *http.Response, error) {
 	logger := c.logger.WithFields(map[string]any{
 		"request": name,
 		"path":    path,
@@ -130,15 +170,23 @@ func doRequest[R any](c *Client, ctx context.Context, name, method, path string,
 		if err != nil {
 			logger.WithError(err).Panic("failed to encode body (this should never happen)")
 		}
-		logger.WithField("body", body).Trace("encoded body")
+		logged := body
+		if r, ok := body.(redactable); ok {
+			logged = r.Redacted()
+		}
+		logger.WithField("body", logged).Trace("encoded body")
 
 		reqBody = bytes.NewReader(encoded)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, c.base.JoinPath(path).String(), reqBody)
+	path, query, _ := strings.Cut(path, "?")
+	target := c.base.JoinPath(path)
+	target.RawQuery = query
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), reqBody)
 	if err != nil {
 		logger.WithError(err).Error("failed to build request")
-		return nil, 0, fmt.Errorf("failed to build request: %w", err)
+		return nil, nil, fmt.Errorf("failed to build request: %w", err)
 	}
 
 	if body != nil {
@@ -150,7 +198,7 @@ func doRequest[R any](c *Client, ctx context.Context, name, method, path string,
 	res, err := c.inner.Do(req)
 	if err != nil {
 		logger.WithError(err).Error("failed to send request")
-		return nil, 0, fmt.Errorf("failed to send request: %w", err)
+		return nil, nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer res.Body.Close()
 	logger.WithField("status", res.StatusCode).Debug("got response")
@@ -158,9 +206,27 @@ func doRequest[R any](c *Client, ctx context.Context, name, method, path string,
 	var data R
 	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
 		logger.WithError(err).Error("failed to deserialize response")
-		return nil, 0, fmt.Errorf("failed to deserialize response: %w", err)
+		return nil, nil, fmt.Errorf("failed to deserialize response: %w", err)
 	}
 	logger.WithField("body", data).Trace("decoded response")
 
-	return &data, res.StatusCode, nil
+	return &data, res, nil
+}
+
+// parseRetryAfter decodes the Retry-After header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+
+	return 0
 }
@@ -1,11 +1,20 @@
 package api
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/types"
+)
 
 // Error contains rich information about an API failure
 type Error struct {
-	message string
-	status  int
+	message      string
+	code         types.ErrorCode
+	status       int
+	retryAfter   time.Duration
+	verification *types.VerificationFailure
 }
 
 var _ error = (*Error)(nil)
@@ -15,6 +24,31 @@ func (e *Error) StatusCode() int {
 	return e.status
 }
 
+// Code returns the machine-readable reason for the failure
+func (e *Error) Code() types.ErrorCode {
+	return e.code
+}
+
+// Retryable determines whether the same request may succeed if it is tried again later
+func (e *Error) Retryable() bool {
+	switch e.code {
+	case types.ErrorInternal, types.ErrorRateLimited, types.ErrorUnavailable, types.ErrorChallengePending, types.ErrorIdempotencyInProgress:
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryAfter returns how long the server asked to wait before retrying, zero if it did not say
+func (e *Error) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// Verification returns why the challenge failed, nil if the failure was unrelated to verification
+func (e *Error) Verification() *types.VerificationFailure {
+	return e.verification
+}
+
 // Message returns the description of the error
 func (e *Error) Message() string {
 	return e.message
@@ -23,3 +57,17 @@ func (e *Error) Message() string {
 func (e *Error) Error() string {
 	return fmt.Sprintf("http error: %s (code: %d)", e.message, e.status)
 }
+
+// legacyErrorCode infers the error code from the status code for servers that predate error codes
+func legacyErrorCode(status int) types.ErrorCode {
+	switch {
+	case status == http.StatusConflict:
+		return types.ErrorChallengePending
+	case status == http.StatusTooManyRequests:
+		return types.ErrorRateLimited
+	case status >= 500:
+		return types.ErrorInternal
+	default:
+		return ""
+	}
+}
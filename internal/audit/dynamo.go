@@ -0,0 +1,182 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/sirupsen/logrus"
+)
+
+// NodeIndex is the name of the global secondary index keyed by node and issue time
+const NodeIndex = "NodeIssuedAt"
+
+// dynamo stores records in a AWS DynamoDB table
+type dynamo struct {
+	logger logrus.FieldLogger
+
+	table  string
+	client *dynamodb.Client
+}
+
+var _ Backend = (*dynamo)(nil)
+
+// NewDynamo creates a new AWS DynamoDB-backed audit log
+func NewDynamo(logger logrus.FieldLogger, config aws.Config, table string) (Backend, error) {
+	logger = logger.WithField("table", table)
+	logger.Info("created new DynamoDB audit log")
+	return &dynamo{logger, table, dynamodb.NewFromConfig(config)}, nil
+}
+
+func (d *dynamo) Record(ctx context.Context, record *Record) error {
+	if record == nil {
+		return errors.New("received nil record")
+	}
+
+	logger := d.logger.WithField("jti", record.ID)
+	logger.Debug("serializing record to attribute values")
+
+	av, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("writing item to table")
+	_, err = d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.table),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	return err
+}
+
+func (d *dynamo) Query(ctx context.Context, filter Filter) ([]Record, error) {
+	switch {
+	case len(filter.ID) != 0:
+		return d.get(ctx, filter)
+	case len(filter.Node) != 0:
+		return d.queryByNode(ctx, filter)
+	default:
+		return d.scan(ctx, filter)
+	}
+}
+
+// get retrieves the record for a single token
+func (d *dynamo) get(ctx context.Context, filter Filter) ([]Record, error) {
+	d.logger.WithField("jti", filter.ID).Debug("fetching item from table")
+
+	key, err := attributevalue.MarshalMap(struct{ ID string }{filter.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(d.table), Key: key})
+	if err != nil {
+		return nil, err
+	} else if output.Item == nil {
+		return nil, nil
+	}
+
+	var record Record
+	if err := attributevalue.UnmarshalMap(output.Item, &record); err != nil {
+		return nil, err
+	}
+
+	if !filter.Matches(&record) {
+		return nil, nil
+	}
+
+	return []Record{record}, nil
+}
+
+// queryByNode finds the records for a node using the secondary index
+func (d *dynamo) queryByNode(ctx context.Context, filter Filter) ([]Record, error) {
+	d.logger.WithField("node", filter.Node).Debug("querying index")
+
+	condition := "Node = :node"
+	values := map[string]types.AttributeValue{
+		":node": &types.AttributeValueMemberS{Value: filter.Node},
+	}
+	if timeCondition := issuedAtCondition(&filter, values); len(timeCondition) != 0 {
+		condition += " AND " + timeCondition
+	}
+
+	paginator := dynamodb.NewQueryPaginator(d.client, &dynamodb.QueryInput{
+		TableName:                 aws.String(d.table),
+		IndexName:                 aws.String(NodeIndex),
+		KeyConditionExpression:    aws.String(condition),
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          aws.Bool(false),
+	})
+
+	var records []Record
+	for paginator.HasMorePages() && !filter.full(records) {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var items []Record
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		records = append(records, items...)
+	}
+
+	return filter.apply(records), nil
+}
+
+// scan searches the entire table for records in the time range
+func (d *dynamo) scan(ctx context.Context, filter Filter) ([]Record, error) {
+	d.logger.Debug("scanning table")
+
+	input := &dynamodb.ScanInput{TableName: aws.String(d.table)}
+
+	values := make(map[string]types.AttributeValue)
+	if condition := issuedAtCondition(&filter, values); len(condition) != 0 {
+		input.FilterExpression = aws.String(condition)
+		input.ExpressionAttributeValues = values
+	}
+
+	var records []Record
+	paginator := dynamodb.NewScanPaginator(d.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var items []Record
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		records = append(records, items...)
+	}
+
+	return filter.apply(records), nil
+}
+
+// issuedAtCondition builds an expression restricting the issue time to the filter's range
+func issuedAtCondition(filter *Filter, values map[string]types.AttributeValue) string {
+	if !filter.Since.IsZero() {
+		values[":since"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(filter.Since.Unix(), 10)}
+	}
+	if !filter.Until.IsZero() {
+		values[":until"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(filter.Until.Unix(), 10)}
+	}
+
+	switch {
+	case !filter.Since.IsZero() && !filter.Until.IsZero():
+		return "IssuedAt BETWEEN :since AND :until"
+	case !filter.Since.IsZero():
+		return "IssuedAt >= :since"
+	case !filter.Until.IsZero():
+		return "IssuedAt <= :until"
+	default:
+		return ""
+	}
+}
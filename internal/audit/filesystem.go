@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// filesystem appends records to a JSON lines file
+type filesystem struct {
+	logger logrus.FieldLogger
+	path   string
+
+	mu sync.Mutex
+}
+
+var _ Backend = (*filesystem)(nil)
+
+// NewFilesystem creates a new audit log stored as JSON lines in the given file
+func NewFilesystem(logger logrus.FieldLogger, path string) (Backend, error) {
+	logger = logger.WithField("path", path)
+
+	logger.Debug("ensuring directories exist")
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm|os.ModeDir); err != nil {
+		return nil, err
+	}
+
+	logger.Info("created new filesystem audit log")
+	return &filesystem{logger: logger, path: path}, nil
+}
+
+func (fs *filesystem) Record(_ context.Context, record *Record) error {
+	if record == nil {
+		return errors.New("received nil record")
+	}
+
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.logger.WithField("jti", record.ID).Debug("appending record to file")
+	file, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+func (fs *filesystem) Query(_ context.Context, filter Filter) ([]Record, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	file, err := os.Open(fs.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fs.logger.Debug("audit log does not exist yet")
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []Record
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid record on line %d: %w", line, err)
+		}
+
+		if filter.Matches(&record) {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return filter.apply(records), nil
+}
@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// memory keeps records in-memory, they are lost when the process exits
+type memory struct {
+	logger logrus.FieldLogger
+
+	mu      sync.RWMutex
+	records []Record
+}
+
+var _ Backend = (*memory)(nil)
+
+// NewInMemory creates a new in-memory audit log
+func NewInMemory(logger logrus.FieldLogger) Backend {
+	logger.Info("created new in-memory audit log")
+	return &memory{logger: logger}
+}
+
+func (m *memory) Record(_ context.Context, record *Record) error {
+	if record == nil {
+		return errors.New("received nil record")
+	}
+
+	m.logger.WithField("jti", record.ID).Debug("recording issuance")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.records = append(m.records, *record)
+	return nil
+}
+
+func (m *memory) Query(_ context.Context, filter Filter) ([]Record, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var records []Record
+	for _, record := range m.records {
+		if filter.Matches(&record) {
+			record.Tags = slices.Clone(record.Tags)
+			records = append(records, record)
+		}
+	}
+
+	return filter.apply(records), nil
+}
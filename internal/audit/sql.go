@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/database"
+	"github.com/sirupsen/logrus"
+)
+
+// recordColumns lists every column of the audit_records table in the order they are scanned
+const recordColumns = "jti, node, dns_name, tailnet, tags, subject, audience, issued_at, expires_at, client_version, source_ip"
+
+// sqlLog stores records in a SQLite or PostgreSQL database
+type sqlLog struct {
+	logger logrus.FieldLogger
+	db     *database.DB
+}
+
+var _ Backend = (*sqlLog)(nil)
+
+// NewSQL creates a new database-backed audit log
+func NewSQL(logger logrus.FieldLogger, db *database.DB) (Backend, error) {
+	logger = logger.WithField("dialect", db.Dialect())
+	logger.Info("created new SQL audit log")
+	return &sqlLog{logger, db}, nil
+}
+
+func (s *sqlLog) Record(ctx context.Context, record *Record) error {
+	if record == nil {
+		return errors.New("received nil record")
+	}
+
+	s.logger.WithField("jti", record.ID).Debug("inserting record into database")
+
+	tags, err := json.Marshal(record.Tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(
+		ctx,
+		s.db.Rebind("INSERT INTO audit_records ("+recordColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		record.ID, record.Node, record.DNSName, record.Tailnet, string(tags), record.Subject, record.Audience,
+		record.IssuedAt.Unix(), record.ExpiresAt.Unix(), record.ClientVersion, record.SourceIP,
+	)
+	return err
+}
+
+func (s *sqlLog) Query(ctx context.Context, filter Filter) ([]Record, error) {
+	var (
+		conditions []string
+		args       []any
+	)
+	if len(filter.ID) != 0 {
+		conditions = append(conditions, "jti = ?")
+		args = append(args, filter.ID)
+	}
+	if len(filter.Node) != 0 {
+		conditions = append(conditions, "node = ?")
+		args = append(args, filter.Node)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "issued_at >= ?")
+		args = append(args, filter.Since.Unix())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "issued_at <= ?")
+		args = append(args, filter.Until.Unix())
+	}
+
+	query := "SELECT " + recordColumns + " FROM audit_records"
+	if len(conditions) != 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY issued_at DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	s.logger.WithField("filter", filter).Debug("querying records from database")
+	rows, err := s.db.QueryContext(ctx, s.db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var (
+			record              Record
+			tags                string
+			issuedAt, expiresAt int64
+		)
+
+		err := rows.Scan(
+			&record.ID, &record.Node, &record.DNSName, &record.Tailnet, &tags, &record.Subject, &record.Audience,
+			&issuedAt, &expiresAt, &record.ClientVersion, &record.SourceIP,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(tags), &record.Tags); err != nil {
+			return nil, err
+		}
+		record.IssuedAt = time.Unix(issuedAt, 0)
+		record.ExpiresAt = time.Unix(expiresAt, 0)
+
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
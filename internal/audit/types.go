@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Backend provides a persistent record of every token that was issued
+type Backend interface {
+	// Record writes an issuance record
+	Record(ctx context.Context, record *Record) error
+	// Query finds all the records matching the filter, ordered from newest to oldest
+	Query(ctx context.Context, filter Filter) ([]Record, error)
+}
+
+// Record contains the details of a single token issuance
+type Record struct {
+	// ID is the unique identifier of the token, matching its jti claim
+	ID string `json:"jti" dynamodbav:"ID"`
+
+	Node     string   `json:"node"`
+	DNSName  string   `json:"dns_name"`
+	Tailnet  string   `json:"tailnet"`
+	Tags     []string `json:"tags"`
+	Subject  string   `json:"subject"`
+	Audience string   `json:"audience"`
+
+	IssuedAt  time.Time `json:"issued_at" dynamodbav:",unixtime"`
+	ExpiresAt time.Time `json:"expires_at" dynamodbav:",unixtime"`
+
+	ClientVersion string `json:"client_version"`
+	SourceIP      string `json:"source_ip"`
+}
+
+// Filter restricts which records are returned by a query. Empty fields match everything.
+type Filter struct {
+	// ID finds the record for a single token
+	ID string
+	// Node finds all the tokens issued to a node
+	Node string
+
+	// Since and Until bound when the token was issued
+	Since time.Time
+	Until time.Time
+
+	// Limit caps the number of records returned
+	Limit int
+}
+
+// Matches checks whether the record satisfies the filter
+func (f *Filter) Matches(record *Record) bool {
+	if len(f.ID) != 0 && record.ID != f.ID {
+		return false
+	}
+	if len(f.Node) != 0 && record.Node != f.Node {
+		return false
+	}
+
+	if !f.Since.IsZero() && record.IssuedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && record.IssuedAt.After(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+// full checks whether enough records were found to satisfy the limit
+func (f *Filter) full(records []Record) bool {
+	return f.Limit > 0 && len(records) >= f.Limit
+}
+
+// apply sorts the records from newest to oldest and truncates them to the limit
+func (f *Filter) apply(records []Record) []Record {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].IssuedAt.After(records[j].IssuedAt)
+	})
+
+	if f.Limit > 0 && len(records) > f.Limit {
+		records = slices.Clip(records[:f.Limit])
+	}
+
+	return records
+}
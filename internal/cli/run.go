@@ -15,6 +15,7 @@ import (
 	"github.com/akrantz01/tailfed/internal/scheduler"
 	"github.com/akrantz01/tailfed/internal/systemd"
 	"github.com/akrantz01/tailfed/internal/tailscale"
+	appversion "github.com/akrantz01/tailfed/internal/version"
 	"github.com/hashicorp/go-version"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -55,13 +56,14 @@ func (r *run) Run(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
-	tsClient := tailscale.NewLocal(logrus.WithField("component", "tailscale"))
-	refresh := refresher.New(apiClient, tsClient, r.Path)
-
-	if err := r.verifyApiVersion(ctx, cmd, apiClient); err != nil {
+	remote, err := r.verifyApiVersion(ctx, cmd, apiClient)
+	if err != nil {
 		return err
 	}
 
+	tsClient := tailscale.NewLocal(logrus.WithField("component", "tailscale"))
+	refresh := refresher.New(apiClient, remote, tsClient, r.Path)
+
 	config, err := apiClient.GetConfig(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get daemon config from api: %w", err)
@@ -104,27 +106,28 @@ signals:
 	return nil
 }
 
-func (r *run) verifyApiVersion(ctx context.Context, cmd *cobra.Command, client *api.Client) error {
+func (r *run) verifyApiVersion(ctx context.Context, cmd *cobra.Command, client *api.Client) (*appversion.Info, error) {
 	remote, err := client.GetVersion(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get api version: %w", err)
+		return nil, fmt.Errorf("failed to get api version: %w", err)
 	}
 	logrus.
 		WithFields(map[string]any{
 			"version":  remote.Version,
 			"revision": remote.Commit,
+			"features": remote.Features,
 		}).
 		Info("got remote version info")
 
 	remoteVersion, err := version.NewSemver(remote.Version)
 	if err != nil {
-		return fmt.Errorf("failed to parse api version %q: %w", remote.Version, err)
+		return nil, fmt.Errorf("failed to parse api version %q: %w", remote.Version, err)
 	}
 
 	if remoteVersionCompat.Check(remoteVersion) {
-		return nil
+		return remote, nil
 	} else {
-		return fmt.Errorf("remote api version %q is incompatible with daemon version %q", remote.Version, cmd.Root().Version)
+		return nil, fmt.Errorf("remote api version %q is incompatible with daemon version %q", remote.Version, cmd.Root().Version)
 	}
 }
 
@@ -12,6 +12,7 @@ import (
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/providers/posflag"
 	"github.com/knadh/koanf/v2"
+	"github.com/spf13/pflag"
 )
 
 // RawConfig contains the raw configuration keys before structuring or validation.
@@ -58,7 +59,7 @@ func Load(opts ...Option) (*RawConfig, error) {
 	}
 
 	if options.flags != nil {
-		if err := k.Load(posflag.ProviderWithValue(options.flags, ".", k, secrets.Load), nil); err != nil {
+		if err := k.Load(posflag.ProviderWithFlag(options.flags, ".", k, newFlagCleaner(options.flags, secrets)), nil); err != nil {
 			return nil, fmt.Errorf("failed to load from flags: %w", err)
 		} else if err := secrets.Err(); err != nil {
 			return nil, fmt.Errorf("failed to load secret from flags: %w", err)
@@ -105,6 +106,17 @@ func (r *RawConfig) InContext(ctx context.Context) context.Context {
 	return context.WithValue(ctx, rawConfigKey{}, r)
 }
 
+// newFlagCleaner resolves secret references in string flags while preserving the types of all other flags
+func newFlagCleaner(flags *pflag.FlagSet, secrets *secretLoader) func(*pflag.Flag) (string, any) {
+	return func(flag *pflag.Flag) (string, any) {
+		if flag.Value.Type() == "string" {
+			return secrets.Load(flag.Name, flag.Value.String())
+		}
+
+		return flag.Name, posflag.FlagVal(flags, flag)
+	}
+}
+
 func newEnvVarCleaner(prefix string, secrets *secretLoader) func(string, string) (string, any) {
 	keyCleaner := func(s string) string {
 		formatted := strings.ToLower(strings.TrimPrefix(s, prefix))
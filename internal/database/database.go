@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Dialect identifies the flavour of SQL spoken by the database
+type Dialect string
+
+const (
+	// DialectSQLite uses an embedded SQLite database, requires the modernc.org/sqlite driver to be registered
+	DialectSQLite Dialect = "sqlite"
+	// DialectPostgres uses a PostgreSQL server, requires the github.com/jackc/pgx/v5/stdlib driver to be registered
+	DialectPostgres Dialect = "postgres"
+)
+
+var ErrUnknownDialect = errors.New("unknown database dialect")
+
+// driverName is the name the dialect's driver registers itself with
+func (d Dialect) driverName() (string, error) {
+	switch d {
+	case DialectSQLite:
+		return "sqlite", nil
+	case DialectPostgres:
+		return "pgx", nil
+	default:
+		return "", ErrUnknownDialect
+	}
+}
+
+// DB is a connection pool to a migrated database
+type DB struct {
+	*sql.DB
+	dialect Dialect
+}
+
+// Open connects to the database and applies any pending migrations. The driver for the dialect must already be
+// registered by the caller.
+func Open(ctx context.Context, logger logrus.FieldLogger, dialect Dialect, dsn string) (*DB, error) {
+	driver, err := dialect.driverName()
+	if err != nil {
+		return nil, err
+	}
+
+	logger = logger.WithField("dialect", dialect)
+	logger.Debug("opening database connection")
+
+	inner, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if dialect == DialectSQLite {
+		// SQLite only supports a single writer, serializing access avoids spurious busy errors
+		inner.SetMaxOpenConns(1)
+	}
+
+	if err := inner.PingContext(ctx); err != nil {
+		_ = inner.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	db := &DB{inner, dialect}
+	if err := db.migrate(ctx, logger); err != nil {
+		_ = inner.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	logger.Info("connected to database")
+	return db, nil
+}
+
+// Dialect returns the flavour of SQL the database uses
+func (db *DB) Dialect() Dialect {
+	return db.dialect
+}
+
+// Rebind converts the ? placeholders in a query into the dialect's native form
+func (db *DB) Rebind(query string) string {
+	if db.dialect != DialectPostgres {
+		return query
+	}
+
+	var (
+		sb strings.Builder
+		n  int
+	)
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteRune('$')
+			sb.WriteString(strconv.Itoa(n))
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+
+	return sb.String()
+}
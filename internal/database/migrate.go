@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed migrations
+var migrations embed.FS
+
+// migration is a single schema change
+type migration struct {
+	version int
+	name    string
+	script  string
+}
+
+// loadMigrations reads the migrations for the dialect in the order they must be applied. Migration files are named
+// <version>_<description>.sql.
+func loadMigrations(dialect Dialect) ([]migration, error) {
+	dir := path.Join("migrations", string(dialect))
+	entries, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]migration, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration name %q", name)
+		}
+
+		script, err := fs.ReadFile(migrations, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, migration{version, name, string(script)})
+	}
+
+	slices.SortFunc(result, func(a, b migration) int {
+		return a.version - b.version
+	})
+	return result, nil
+}
+
+// migrate applies each migration that has not yet been run in its own transaction
+func (db *DB) migrate(ctx context.Context, logger logrus.FieldLogger) error {
+	pending, err := loadMigrations(db.dialect)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		if m.version <= current {
+			continue
+		}
+
+		logger.WithField("migration", m.name).Info("applying migration")
+		if err := db.apply(ctx, m); err != nil {
+			return fmt.Errorf("migration %q failed: %w", m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) apply(ctx context.Context, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range strings.Split(m.script, ";") {
+		if len(strings.TrimSpace(statement)) == 0 {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, db.Rebind("INSERT INTO schema_migrations (version) VALUES (?)"), m.version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,45 @@
+CREATE TABLE flows (
+    id           TEXT PRIMARY KEY,
+    status       TEXT NOT NULL,
+    version      INTEGER NOT NULL DEFAULT 0,
+    expires_at   BIGINT NOT NULL,
+    secret       BYTEA NOT NULL,
+    node         TEXT NOT NULL,
+    public_key   TEXT NOT NULL,
+    dns_name     TEXT NOT NULL,
+    machine_name TEXT NOT NULL,
+    hostname     TEXT NOT NULL,
+    tailnet      TEXT NOT NULL,
+    os           TEXT NOT NULL,
+    tags         TEXT NOT NULL,
+    authorized   BOOLEAN NOT NULL,
+    external     BOOLEAN NOT NULL,
+    data_key     BYTEA
+);
+
+CREATE INDEX flows_expires_at ON flows (expires_at);
+
+CREATE TABLE counters (
+    name       TEXT PRIMARY KEY,
+    count      INTEGER NOT NULL,
+    expires_at BIGINT NOT NULL
+);
+
+CREATE INDEX counters_expires_at ON counters (expires_at);
+
+CREATE TABLE audit_records (
+    jti            TEXT PRIMARY KEY,
+    node           TEXT NOT NULL,
+    dns_name       TEXT NOT NULL,
+    tailnet        TEXT NOT NULL,
+    tags           TEXT NOT NULL,
+    subject        TEXT NOT NULL,
+    audience       TEXT NOT NULL,
+    issued_at      BIGINT NOT NULL,
+    expires_at     BIGINT NOT NULL,
+    client_version TEXT NOT NULL,
+    source_ip      TEXT NOT NULL
+);
+
+CREATE INDEX audit_records_node_issued_at ON audit_records (node, issued_at);
+CREATE INDEX audit_records_issued_at ON audit_records (issued_at);
@@ -0,0 +1 @@
+ALTER TABLE flows ADD COLUMN launch_addresses TEXT;
@@ -0,0 +1,8 @@
+CREATE TABLE idempotency_keys (
+    name        TEXT PRIMARY KEY,
+    flow_id     TEXT NOT NULL,
+    fingerprint BLOB NOT NULL,
+    expires_at  INTEGER NOT NULL
+);
+
+CREATE INDEX idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Prune deletes all the rate limit counters and idempotency keys that expired at or before now, returning how many
+// were removed. Flows are removed through the storage backend.
+func (db *DB) Prune(ctx context.Context, now time.Time) (int64, error) {
+	var total int64
+	for _, table := range []string{"counters", "idempotency_keys"} {
+		result, err := db.ExecContext(ctx, db.Rebind("DELETE FROM "+table+" WHERE expires_at <= ?"), now.Unix())
+		if err != nil {
+			return total, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += affected
+	}
+
+	return total, nil
+}
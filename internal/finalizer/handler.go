@@ -3,15 +3,17 @@ package finalizer
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
+	"github.com/akrantz01/tailfed/internal/audit"
 	"github.com/akrantz01/tailfed/internal/http/gateway"
 	"github.com/akrantz01/tailfed/internal/http/lambda"
 	"github.com/akrantz01/tailfed/internal/logging"
 	"github.com/akrantz01/tailfed/internal/oidc"
+	"github.com/akrantz01/tailfed/internal/proof"
 	"github.com/akrantz01/tailfed/internal/signing"
 	"github.com/akrantz01/tailfed/internal/storage"
 	"github.com/akrantz01/tailfed/internal/types"
@@ -20,28 +22,34 @@ import (
 
 // Handler responds to incoming flow finalization requests, issuing the token if the challenge was successful.
 type Handler struct {
+	issuer   *oidc.Issuer
 	audience string
 	validity time.Duration
 
 	signer signing.Backend
 	store  storage.Backend
+	audit  audit.Backend
 }
 
 var _ gateway.Handler = (*Handler)(nil)
 
 // New creates a new handler
-func New(audience string, validity time.Duration, signer signing.Backend, store storage.Backend) *Handler {
-	return &Handler{audience, validity, signer, store}
+func New(issuer *oidc.Issuer, audience string, validity time.Duration, signer signing.Backend, store storage.Backend, auditLog audit.Backend) *Handler {
+	return &Handler{issuer, audience, validity, signer, store, auditLog}
 }
 
 func (h *Handler) Serve(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
 	logger := logging.FromContext(ctx).WithField("component", "logger")
 
+	if !h.issuer.Allow(logger, gateway.Host(&req, h.issuer.TrustsProxy())) {
+		return lambda.Error(types.ErrorPolicyDenied, "unknown hostname", http.StatusMisdirectedRequest), nil
+	}
+
 	var body types.FinalizeRequest
 	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
-		return lambda.Error("invalid request body", http.StatusUnprocessableEntity), nil
+		return lambda.Error(types.ErrorInvalidRequest, "invalid request body", http.StatusUnprocessableEntity), nil
 	}
-	logger.WithField("body", body).Debug("")
+	logger.WithField("body", body.Redacted()).Debug("")
 
 	flow, err := h.store.Get(ctx, body.ID)
 	if err != nil {
@@ -49,39 +57,95 @@ func (h *Handler) Serve(ctx context.Context, req events.APIGatewayProxyRequest)
 		return lambda.InternalServerError(), nil
 	} else if flow == nil {
 		logger.Warn("flow not found")
-		return lambda.Error("flow not found", http.StatusNotFound), nil
+		return lambda.Error(types.ErrorFlowNotFound, "flow not found", http.StatusNotFound), nil
+	}
+
+	if err := proof.VerifyFinalize(flow.Secret, flow.ID, body.Timestamp, body.Proof, time.Now()); err != nil {
+		logger.WithError(err).Warn("invalid proof of flow ownership")
+		return lambda.Error(types.ErrorInvalidProof, "invalid proof of ownership", http.StatusForbidden), nil
+	}
+
+	flow, err = awaitVerification(ctx, h.store, flow, time.Duration(body.Wait)*time.Second)
+	if err != nil {
+		logger.WithError(err).Error("failed to wait for verification")
+		return lambda.InternalServerError(), nil
+	} else if flow == nil {
+		logger.Warn("flow disappeared while waiting for verification")
+		return lambda.Error(types.ErrorFlowNotFound, "flow not found", http.StatusNotFound), nil
 	}
 
 	if flow.Status == storage.StatusPending {
-		return lambda.Error("challenge not verified", http.StatusConflict), nil
+		return lambda.WithRetryAfter(lambda.Error(types.ErrorChallengePending, "challenge not verified", http.StatusConflict), retryAfter), nil
 	} else if flow.Status == storage.StatusFailed {
-		return lambda.Error("challenge failed", http.StatusForbidden), nil
-	} else if time.Now().After(time.Time(flow.ExpiresAt)) {
-		return lambda.Error("challenge expired", http.StatusForbidden), nil
+		failure := newVerificationFailure(flow)
+		logger.WithField("reason", failure.Reason).Info("challenge failed")
+		return lambda.VerificationFailed(failure), nil
+	} else if !flow.Consumable(time.Now()) {
+		return lambda.Error(types.ErrorFlowExpired, "challenge expired", http.StatusForbidden), nil
 	}
 
-	claims := oidc.NewClaimsFromFlow(generateIssuer(&req.RequestContext), h.audience, h.validity, flow)
+	// Consuming the flow before signing guarantees concurrent requests cannot both receive a token
+	flow, err = h.store.Consume(ctx, flow.ID)
+	if errors.Is(err, storage.ErrConflict) {
+		logger.Warn("flow was concurrently finalized")
+		return lambda.Error(types.ErrorFlowFinalized, "flow already finalized", http.StatusGone), nil
+	} else if err != nil {
+		logger.WithError(err).Error("failed to consume flow")
+		return lambda.InternalServerError(), nil
+	}
+
+	if err := storage.ReleasePending(ctx, h.store, flow); err != nil {
+		logger.WithError(err).Warn("failed to release pending flow")
+	}
+
+	claims := oidc.NewClaimsFromFlow(h.issuer.String(), h.audience, h.validity, flow)
 	token, err := h.signer.Sign(claims)
 	if err != nil {
 		logger.WithError(err).Error("failed to sign JWT")
 		return lambda.InternalServerError(), nil
 	}
 
-	if err := h.store.Delete(ctx, flow.ID); err != nil {
-		logger.WithError(err).Error("failed to delete flow")
+	logger = logger.WithField("jti", claims.ID)
+	if err := h.audit.Record(ctx, newAuditRecord(&req, &claims, flow)); err != nil {
+		logger.WithError(err).Error("failed to record issuance")
 		return lambda.InternalServerError(), nil
 	}
+	logger.Info("issued token")
 
 	return lambda.Success(&types.FinalizeResponse{IdentityToken: token}), nil
 }
 
-func generateIssuer(ctx *events.APIGatewayProxyRequestContext) string {
-	region := os.Getenv("AWS_REGION")
-	expected := fmt.Sprintf("%s.execute-api.%s.amazonaws.com", ctx.APIID, region)
+// newVerificationFailure summarizes the failed verification attempts for the client
+func newVerificationFailure(flow *storage.Flow) *types.VerificationFailure {
+	attempts := make([]types.VerificationAttempt, 0, len(flow.Attempts))
+	for _, attempt := range flow.Attempts {
+		attempts = append(attempts, types.VerificationAttempt{
+			Address:   attempt.Address,
+			Reason:    string(attempt.Reason),
+			Detail:    attempt.Detail,
+			Timestamp: attempt.At.Unix(),
+		})
+	}
+
+	return &types.VerificationFailure{
+		Reason:   string(flow.FailureReason()),
+		Attempts: attempts,
+	}
+}
 
-	if ctx.DomainName == expected {
-		return "https://" + expected + "/" + ctx.Stage
-	} else {
-		return "https://" + ctx.DomainName
+// newAuditRecord captures the details about a token issuance
+func newAuditRecord(req *events.APIGatewayProxyRequest, claims *oidc.Claims, flow *storage.Flow) *audit.Record {
+	return &audit.Record{
+		ID:            claims.ID,
+		Node:          flow.Node,
+		DNSName:       flow.DNSName,
+		Tailnet:       flow.Tailnet,
+		Tags:          flow.Tags,
+		Subject:       claims.Subject,
+		Audience:      strings.Join(claims.Audience, " "),
+		IssuedAt:      claims.IssuedAt.Time(),
+		ExpiresAt:     claims.Expiry.Time(),
+		ClientVersion: req.RequestContext.Identity.UserAgent,
+		SourceIP:      gateway.SourceIP(req),
 	}
 }
@@ -0,0 +1,79 @@
+package finalizer
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/http/gateway"
+	"github.com/akrantz01/tailfed/internal/http/lambda"
+	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/oidc"
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// StatusHandler reports the state of a flow, optionally waiting for its challenge to be verified
+type StatusHandler struct {
+	issuer *oidc.Issuer
+	store  storage.Backend
+}
+
+var _ gateway.Handler = (*StatusHandler)(nil)
+
+// NewStatus creates a new flow status handler
+func NewStatus(issuer *oidc.Issuer, store storage.Backend) *StatusHandler {
+	return &StatusHandler{issuer, store}
+}
+
+func (h *StatusHandler) Serve(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	logger := logging.FromContext(ctx).WithField("component", "logger")
+
+	if !h.issuer.Allow(logger, gateway.Host(&req, h.issuer.TrustsProxy())) {
+		return lambda.Error(types.ErrorPolicyDenied, "unknown hostname", http.StatusMisdirectedRequest), nil
+	}
+
+	id := req.PathParameters["id"]
+	if len(id) == 0 {
+		return lambda.Error(types.ErrorInvalidRequest, "missing flow id", http.StatusBadRequest), nil
+	}
+	logger = logger.WithField("id", id)
+
+	var wait int
+	if raw, ok := req.QueryStringParameters["wait"]; ok {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return lambda.Error(types.ErrorInvalidRequest, "wait must be a non-negative number of seconds", http.StatusBadRequest), nil
+		}
+		wait = parsed
+	}
+
+	flow, err := h.store.Get(ctx, id)
+	if err != nil {
+		logger.WithError(err).Error("failed to get flow")
+		return lambda.InternalServerError(), nil
+	} else if flow == nil {
+		return lambda.Error(types.ErrorFlowNotFound, "flow not found", http.StatusNotFound), nil
+	}
+
+	flow, err = awaitVerification(ctx, h.store, flow, time.Duration(wait)*time.Second)
+	if err != nil {
+		logger.WithError(err).Error("failed to wait for flow")
+		return lambda.InternalServerError(), nil
+	} else if flow == nil {
+		return lambda.Error(types.ErrorFlowNotFound, "flow not found", http.StatusNotFound), nil
+	}
+
+	res := lambda.Success(&types.FlowStatusResponse{
+		ID:        flow.ID,
+		Status:    string(flow.Status),
+		ExpiresAt: time.Time(flow.ExpiresAt).Unix(),
+	})
+	if flow.Status == storage.StatusPending {
+		res = lambda.WithRetryAfter(res, retryAfter)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,38 @@
+package finalizer
+
+import (
+	"context"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/storage"
+)
+
+const (
+	// MaxWait is the longest a request can block waiting for a challenge to be verified
+	MaxWait = 20 * time.Second
+
+	// pollInterval is how often the flow is checked while waiting
+	pollInterval = 500 * time.Millisecond
+	// responseMargin is reserved before the invocation deadline to send the response
+	responseMargin = 1 * time.Second
+	// retryAfter is the hint sent to clients when the challenge is not yet verified
+	retryAfter = 1 * time.Second
+)
+
+// awaitVerification waits for a pending flow to be verified, bounded by the requested duration, MaxWait, and the
+// context's deadline
+func awaitVerification(ctx context.Context, store storage.Backend, flow *storage.Flow, wait time.Duration) (*storage.Flow, error) {
+	wait = min(wait, MaxWait)
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = min(wait, time.Until(deadline)-responseMargin)
+	}
+
+	if flow.Status != storage.StatusPending || wait <= 0 {
+		return flow, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	return storage.Await(ctx, store, flow, pollInterval)
+}
@@ -0,0 +1,93 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/http/gateway"
+	"github.com/akrantz01/tailfed/internal/http/lambda"
+	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/metadata"
+	"github.com/akrantz01/tailfed/internal/oidc"
+	"github.com/akrantz01/tailfed/internal/signing"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sirupsen/logrus"
+)
+
+// Cache generates the metadata documents on demand directly from the signer, keeping them in memory until they are
+// older than the refresh interval
+type Cache struct {
+	generator *Handler
+	meta      metadata.Backend
+	refresh   time.Duration
+
+	mu        sync.Mutex
+	generated time.Time
+}
+
+// NewCache creates a new in-memory metadata cache
+func NewCache(logger logrus.FieldLogger, issuer *oidc.Issuer, validity, refresh time.Duration, signer signing.Backend, discovery ...oidc.DiscoveryOption) *Cache {
+	meta := metadata.NewMemory(logger)
+	return &Cache{
+		generator: New(issuer, validity, meta, signer, discovery...),
+		meta:      meta,
+		refresh:   refresh,
+	}
+}
+
+// Handler serves a single document from the cache
+func (c *Cache) Handler(key string) gateway.Handler {
+	return &cacheHandler{c, key}
+}
+
+// ensure regenerates the documents if they are missing or stale, returning how much longer they are fresh for. Stale
+// documents continue to be served if they cannot be regenerated.
+func (c *Cache) ensure(ctx context.Context) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	remaining := c.refresh - time.Since(c.generated)
+	if !c.generated.IsZero() && remaining > 0 {
+		return remaining, nil
+	}
+
+	if err := c.generator.Serve(ctx, types.GenerateRequest{}); err != nil {
+		if c.generated.IsZero() {
+			return 0, err
+		}
+
+		logging.FromContext(ctx).WithError(err).Warn("failed to regenerate metadata, serving stale documents")
+		return 0, nil
+	}
+
+	c.generated = time.Now()
+	return c.refresh, nil
+}
+
+type cacheHandler struct {
+	cache *Cache
+	key   string
+}
+
+var _ gateway.Handler = (*cacheHandler)(nil)
+
+func (h *cacheHandler) Serve(ctx context.Context, _ events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	logger := logging.FromContext(ctx).WithField("key", h.key)
+
+	remaining, err := h.cache.ensure(ctx)
+	if err != nil {
+		logger.WithError(err).Error("failed to generate metadata")
+		return lambda.InternalServerError(), nil
+	}
+
+	var document json.RawMessage
+	if err := h.cache.meta.Load(ctx, h.key, &document); err != nil {
+		logger.WithError(err).Error("failed to load metadata")
+		return lambda.InternalServerError(), nil
+	}
+
+	return lambda.Document(document, remaining), nil
+}
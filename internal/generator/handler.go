@@ -2,9 +2,12 @@ package generator
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/akrantz01/tailfed/internal/logging"
 	"github.com/akrantz01/tailfed/internal/metadata"
 	"github.com/akrantz01/tailfed/internal/oidc"
 	"github.com/akrantz01/tailfed/internal/signing"
@@ -15,25 +18,51 @@ import (
 
 // Handler is triggered by EventBridge once a day, generating the OIDC metadata
 type Handler struct {
-	validity time.Duration
+	issuer    *oidc.Issuer
+	validity  time.Duration
+	discovery []oidc.DiscoveryOption
 
 	meta   metadata.Backend
 	signer signing.Backend
 }
 
 // New creates a new handler
-func New(validity time.Duration, meta metadata.Backend, signer signing.Backend) *Handler {
-	return &Handler{validity, meta, signer}
+func New(issuer *oidc.Issuer, validity time.Duration, meta metadata.Backend, signer signing.Backend, discovery ...oidc.DiscoveryOption) *Handler {
+	return &Handler{issuer, validity, discovery, meta, signer}
 }
 
 func (h *Handler) Serve(ctx context.Context, req types.GenerateRequest) error {
+	if len(req.Issuer) != 0 && strings.TrimSuffix(req.Issuer, "/") != h.issuer.String() {
+		logging.FromContext(ctx).
+			WithFields(map[string]any{
+				"requested": req.Issuer,
+				"issuer":    h.issuer.String(),
+			}).
+			Warn("requested issuer does not match configured issuer, using configured issuer")
+	}
+
+	keys, err := h.signer.PublicKeys()
+	if err != nil {
+		return fmt.Errorf("failed to get public keys: %w", err)
+	}
+
+	jwks := jose.JSONWebKeySet{Keys: keys}
+	if err := oidc.ValidateKeySet(jwks); err != nil {
+		return fmt.Errorf("refusing to publish invalid key set: %w", err)
+	}
+
+	doc := oidc.NewDiscoveryDocument(h.issuer.String(), oidc.SigningAlgorithms(keys), h.discovery...)
+	if err := doc.Validate(); err != nil {
+		return fmt.Errorf("refusing to publish invalid discovery document: %w", err)
+	}
+
 	var wg sync.WaitGroup
 
 	configErrCh := wrapWriteJob(ctx, req, &wg, h.writeConfig)
 	versionErrCh := wrapWriteJob(ctx, req, &wg, h.writeVersion)
 
-	jwkErrCh := wrapWriteJob(ctx, req, &wg, h.writeJwkSet)
-	discoveryDocumentErrCh := wrapWriteJob(ctx, req, &wg, h.writeDiscoveryDocument)
+	jwkErrCh := wrapWriteJob(ctx, req, &wg, h.write("jwks.json", jwks))
+	discoveryDocumentErrCh := wrapWriteJob(ctx, req, &wg, h.write("openid-configuration", doc))
 
 	wg.Wait()
 	return combineErrors(configErrCh, versionErrCh, jwkErrCh, discoveryDocumentErrCh)
@@ -49,16 +78,9 @@ func (h *Handler) writeVersion(ctx context.Context, _ types.GenerateRequest) err
 	return h.meta.Save(ctx, "version.json", version.GetInfo())
 }
 
-func (h *Handler) writeJwkSet(ctx context.Context, _ types.GenerateRequest) error {
-	key, err := h.signer.PublicKey()
-	if err != nil {
-		return err
+// write saves a document that was generated ahead of time
+func (h *Handler) write(key string, document any) jobHandler {
+	return func(ctx context.Context, _ types.GenerateRequest) error {
+		return h.meta.Save(ctx, key, document)
 	}
-
-	return h.meta.Save(ctx, "jwks.json", jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key}})
-}
-
-func (h *Handler) writeDiscoveryDocument(ctx context.Context, req types.GenerateRequest) error {
-	doc := oidc.NewDiscoveryDocument(req.Issuer)
-	return h.meta.Save(ctx, "openid-configuration", doc)
 }
@@ -0,0 +1,40 @@
+package forwarded
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Middleware replaces the request's remote address with the client address appended to the X-Forwarded-For header by
+// the proxy in front of the server. Only the last entry is used as clients can set the header themselves, so this must
+// only be enabled when every request passes through a single proxy that appends to it.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if client, ok := clientAddress(r.Header.Values("X-Forwarded-For")); ok {
+			r = r.Clone(r.Context())
+			r.RemoteAddr = client.String()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientAddress finds the address appended by the closest proxy, ignoring it if it is malformed
+func clientAddress(values []string) (netip.Addr, bool) {
+	if len(values) == 0 {
+		return netip.Addr{}, false
+	}
+
+	forwarded := values[len(values)-1]
+	if index := strings.LastIndexByte(forwarded, ','); index != -1 {
+		forwarded = forwarded[index+1:]
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(forwarded))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
@@ -0,0 +1,42 @@
+package forwarded
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		headers []string
+		want    string
+	}{
+		"no header":          {nil, "10.0.0.1:1234"},
+		"single client":      {[]string{"203.0.113.7"}, "203.0.113.7"},
+		"spoofed by client":  {[]string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		"repeated headers":   {[]string{"198.51.100.1", "203.0.113.7"}, "203.0.113.7"},
+		"ipv6 client":        {[]string{"2001:db8::1"}, "2001:db8::1"},
+		"ipv4-mapped client": {[]string{"::ffff:203.0.113.7"}, "203.0.113.7"},
+		"malformed entry":    {[]string{"203.0.113.7, unknown"}, "10.0.0.1:1234"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got string
+			handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/start", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			for _, value := range tt.headers {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Fatalf("expected remote address %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ALB adapts a handler to application load balancer target groups. Multi-value headers are returned only when they
+// are enabled for the target group, which is detected from the request.
+func ALB(handler Handler) func(context.Context, events.ALBTargetGroupRequest) (*events.ALBTargetGroupResponse, error) {
+	return func(ctx context.Context, req events.ALBTargetGroupRequest) (*events.ALBTargetGroupResponse, error) {
+		converted, err := fromALBRequest(&req)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := handler.Serve(ctx, converted)
+		if err != nil {
+			return nil, err
+		}
+
+		response := &events.ALBTargetGroupResponse{
+			StatusCode:        res.StatusCode,
+			StatusDescription: fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode)),
+			Body:              res.Body,
+			IsBase64Encoded:   res.IsBase64Encoded,
+		}
+
+		if req.MultiValueHeaders != nil {
+			response.MultiValueHeaders = make(map[string][]string, len(res.Headers)+len(res.MultiValueHeaders))
+			for key, value := range res.Headers {
+				response.MultiValueHeaders[key] = []string{value}
+			}
+			for key, values := range res.MultiValueHeaders {
+				response.MultiValueHeaders[key] = values
+			}
+		} else {
+			response.Headers = flattenHeaders(res)
+		}
+
+		return response, nil
+	}
+}
+
+// fromALBRequest converts a target group request into the REST API payload the handlers expect
+func fromALBRequest(req *events.ALBTargetGroupRequest) (events.APIGatewayProxyRequest, error) {
+	body, err := decodeBody(req.Body, req.IsBase64Encoded)
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+
+	headers := req.Headers
+	if req.MultiValueHeaders != nil {
+		headers = fromMultiValueMap(req.MultiValueHeaders)
+	}
+
+	// Unlike API Gateway, load balancers pass query parameters through exactly as they were sent
+	query := make(map[string][]string, len(req.QueryStringParameters)+len(req.MultiValueQueryStringParameters))
+	for key, value := range req.QueryStringParameters {
+		query[unescape(key)] = []string{unescape(value)}
+	}
+	for key, values := range req.MultiValueQueryStringParameters {
+		decoded := make([]string, 0, len(values))
+		for _, value := range values {
+			decoded = append(decoded, unescape(value))
+		}
+		query[unescape(key)] = decoded
+	}
+
+	return events.APIGatewayProxyRequest{
+		Resource:   req.Path,
+		Path:       req.Path,
+		HTTPMethod: req.HTTPMethod,
+
+		Headers:           headers,
+		MultiValueHeaders: req.MultiValueHeaders,
+
+		QueryStringParameters:           fromMultiValueMap(query),
+		MultiValueQueryStringParameters: query,
+
+		Body: body,
+
+		RequestContext: events.APIGatewayProxyRequestContext{
+			ResourcePath: req.Path,
+			HTTPMethod:   req.HTTPMethod,
+
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  forwardedFor(headers),
+				UserAgent: headerValue(headers, "User-Agent"),
+			},
+		},
+	}, nil
+}
+
+// forwardedFor finds the client address appended by the load balancer to the X-Forwarded-For header
+func forwardedFor(headers map[string]string) string {
+	forwarded := headerValue(headers, "X-Forwarded-For")
+	if index := strings.LastIndexByte(forwarded, ','); index != -1 {
+		forwarded = forwarded[index+1:]
+	}
+
+	return strings.TrimSpace(forwarded)
+}
+
+// unescape decodes a query string component, leaving it untouched if it is malformed
+func unescape(s string) string {
+	if decoded, err := url.QueryUnescape(s); err == nil {
+		return decoded
+	}
+
+	return s
+}
@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Host determines the hostname the request was originally sent to. Forwarded hosts from proxies such as CloudFront
+// take precedence over the host the gateway was reached on, but only when the proxy is trusted as clients can set the
+// header themselves.
+func Host(req *events.APIGatewayProxyRequest, trustProxy bool) string {
+	if host := header(req, "X-Forwarded-Host"); trustProxy && len(host) != 0 {
+		// Only the first proxy's value matters
+		host, _, _ = strings.Cut(host, ",")
+		return strings.TrimSpace(host)
+	}
+
+	if host := header(req, "Host"); len(host) != 0 {
+		return host
+	}
+
+	return req.RequestContext.DomainName
+}
+
+// header performs a case-insensitive lookup of a header
+func header(req *events.APIGatewayProxyRequest, name string) string {
+	return headerValue(req.Headers, name)
+}
+
+// headerValue performs a case-insensitive lookup of a header in a set of headers
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// SourceIP extracts the IP address the request was made from, stripping the port included by the HTTP server
+func SourceIP(req *events.APIGatewayProxyRequest) string {
+	source := req.RequestContext.Identity.SourceIP
+	if addrPort, err := netip.ParseAddrPort(source); err == nil {
+		return addrPort.Addr().String()
+	}
+
+	return source
+}
@@ -0,0 +1,25 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/akrantz01/tailfed/internal/http/lambda"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Methods dispatches requests to a handler based on their HTTP method, allowing a single function to serve multiple
+// routes
+type Methods map[string]Handler
+
+var _ Handler = (Methods)(nil)
+
+func (m Methods) Serve(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	handler, ok := m[req.HTTPMethod]
+	if !ok {
+		return lambda.Error(types.ErrorMethodNotAllowed, "method not allowed", http.StatusMethodNotAllowed), nil
+	}
+
+	return handler.Serve(ctx, req)
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// WithPathParameters extracts path parameters from the request path using the pattern when the trigger has not
+// already done so. Function URLs and load balancers do not perform any routing, so parameters would otherwise be
+// missing. Patterns use the same syntax as API Gateway, such as "/flows/{id}".
+func WithPathParameters(pattern string, next Handler) Handler {
+	return &pathParametersHandler{
+		pattern: newPattern(pattern),
+		next:    next,
+	}
+}
+
+type pathParametersHandler struct {
+	pattern pattern
+	next    Handler
+}
+
+var _ Handler = (*pathParametersHandler)(nil)
+
+func (p *pathParametersHandler) Serve(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	if len(req.PathParameters) == 0 {
+		if params, ok := p.pattern.Match(req.Path); ok {
+			req.PathParameters = params
+		}
+	}
+
+	return p.next.Serve(ctx, req)
+}
+
+// pattern is a path split into segments, where segments wrapped in braces capture a parameter
+type pattern []string
+
+func newPattern(raw string) pattern {
+	return strings.Split(strings.Trim(raw, "/"), "/")
+}
+
+// Match checks whether the path matches the pattern, returning any captured parameters
+func (p pattern) Match(path string) (map[string]string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) != len(p) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, segment := range p {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || len(value) == 0 {
+				return nil, false
+			}
+
+			params[segment[1:len(segment)-1]] = value
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
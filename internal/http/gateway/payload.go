@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownPayloadFormat is returned when adapting a handler to an unsupported payload format
+var ErrUnknownPayloadFormat = errors.New("unknown payload format")
+
+// PayloadFormat is the shape of the events a function receives from its trigger
+type PayloadFormat string
+
+const (
+	// PayloadREST is the version 1.0 format used by API Gateway REST APIs
+	PayloadREST PayloadFormat = "rest"
+	// PayloadHTTP is the version 2.0 format used by API Gateway HTTP APIs
+	PayloadHTTP PayloadFormat = "http"
+	// PayloadFunctionURL is the format used by Lambda function URLs
+	PayloadFunctionURL PayloadFormat = "url"
+	// PayloadALB is the format used by application load balancer target groups
+	PayloadALB PayloadFormat = "alb"
+)
+
+func (p PayloadFormat) Validate() error {
+	switch p {
+	case PayloadREST, PayloadHTTP, PayloadFunctionURL, PayloadALB:
+		return nil
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownPayloadFormat, string(p))
+	}
+}
+
+// Adapt creates a Lambda handler that accepts events in the payload format and passes them to the handler
+func Adapt(format PayloadFormat, handler Handler) (any, error) {
+	switch format {
+	case PayloadREST:
+		return handler.Serve, nil
+	case PayloadHTTP:
+		return HTTPAPI(handler), nil
+	case PayloadFunctionURL:
+		return FunctionURL(handler), nil
+	case PayloadALB:
+		return ALB(handler), nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownPayloadFormat, string(format))
+	}
+}
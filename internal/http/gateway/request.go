@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/akrantz01/tailfed/internal/http/requestid"
 	"github.com/aws/aws-lambda-go/events"
@@ -26,6 +27,10 @@ func FromHttpRequest(r *http.Request) (events.APIGatewayProxyRequest, error) {
 
 	queryParams := fromMultiValueMap(r.URL.Query())
 	headers := fromMultiValueMap(r.Header)
+	if len(r.Host) != 0 {
+		// The standard library removes the host from the headers
+		headers["Host"] = r.Host
+	}
 
 	return events.APIGatewayProxyRequest{
 		Resource:   r.URL.Path,
@@ -38,7 +43,7 @@ func FromHttpRequest(r *http.Request) (events.APIGatewayProxyRequest, error) {
 		QueryStringParameters:           queryParams,
 		MultiValueQueryStringParameters: r.URL.Query(),
 
-		PathParameters: make(map[string]string),
+		PathParameters: pathParameters(r),
 		StageVariables: make(map[string]string),
 
 		Body:            string(body),
@@ -63,6 +68,23 @@ func FromHttpRequest(r *http.Request) (events.APIGatewayProxyRequest, error) {
 	}, nil
 }
 
+// pathParameters extracts the wildcards matched by the request's route pattern
+func pathParameters(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	for _, segment := range strings.Split(r.Pattern, "/") {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimSuffix(segment[1:len(segment)-1], "..."), "$")
+		if len(name) != 0 {
+			params[name] = r.PathValue(name)
+		}
+	}
+
+	return params
+}
+
 func fromMultiValueMap[K comparable, V any](m map[K][]V) map[K]V {
 	result := make(map[K]V, len(m))
 	for key, values := range m {
@@ -70,3 +92,72 @@ func fromMultiValueMap[K comparable, V any](m map[K][]V) map[K]V {
 	}
 	return result
 }
+
+// FromHttpRequestV2 creates a new API gateway HTTP API request from a standard library HTTP request. AWS-specific
+// values are filled with dummy values.
+func FromHttpRequestV2(r *http.Request) (events.APIGatewayV2HTTPRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return events.APIGatewayV2HTTPRequest{}, fmt.Errorf("failed to read request body: %w", err)
+	}
+	defer r.Body.Close()
+
+	// Version 2.0 payloads use lowercase header names, combine repeated values, and split out cookies
+	headers := make(map[string]string, len(r.Header)+1)
+	for key, values := range r.Header {
+		headers[strings.ToLower(key)] = strings.Join(values, ",")
+	}
+	delete(headers, "cookie")
+	if len(r.Host) != 0 {
+		headers["host"] = r.Host
+	}
+
+	var cookies []string
+	for _, cookie := range r.Cookies() {
+		cookies = append(cookies, cookie.String())
+	}
+
+	query := make(map[string]string, len(r.URL.Query()))
+	for key, values := range r.URL.Query() {
+		query[key] = strings.Join(values, ",")
+	}
+
+	routeKey := "$default"
+	if len(r.Pattern) != 0 {
+		routeKey = r.Pattern
+	}
+
+	return events.APIGatewayV2HTTPRequest{
+		Version:        "2.0",
+		RouteKey:       routeKey,
+		RawPath:        r.URL.EscapedPath(),
+		RawQueryString: r.URL.RawQuery,
+		Cookies:        cookies,
+
+		Headers:               headers,
+		QueryStringParameters: query,
+		PathParameters:        pathParameters(r),
+
+		Body:            string(body),
+		IsBase64Encoded: false,
+
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			RouteKey:  routeKey,
+			AccountID: "111122223333",
+			Stage:     "$default",
+			RequestID: requestid.Get(r),
+			APIID:     ID,
+
+			DomainName:   DomainName,
+			DomainPrefix: ID,
+
+			HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Protocol:  r.Proto,
+				SourceIP:  r.RemoteAddr,
+				UserAgent: r.UserAgent(),
+			},
+		},
+	}, nil
+}
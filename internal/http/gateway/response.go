@@ -29,3 +29,29 @@ func WriteHttpResponse(w http.ResponseWriter, resp *events.APIGatewayProxyRespon
 	_, err := w.Write([]byte(resp.Body))
 	return err
 }
+
+// WriteHttpResponseV2 writes an API gateway HTTP API response to a HTTP response
+func WriteHttpResponseV2(w http.ResponseWriter, resp *events.APIGatewayV2HTTPResponse) error {
+	headers := w.Header()
+	for key, value := range resp.Headers {
+		headers.Set(key, value)
+	}
+	for key, values := range resp.MultiValueHeaders {
+		headers.Del(key)
+		for _, value := range values {
+			headers.Add(key, value)
+		}
+	}
+	for _, cookie := range resp.Cookies {
+		headers.Add("Set-Cookie", cookie)
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	if resp.IsBase64Encoded {
+		return errors.New("base64-encoded bodies are unsupported")
+	}
+
+	_, err := w.Write([]byte(resp.Body))
+	return err
+}
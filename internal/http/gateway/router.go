@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/akrantz01/tailfed/internal/http/lambda"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Router dispatches requests to handlers based on their path and HTTP method, allowing a single function to serve
+// every route. It is intended for functions behind a catch-all route, function URL, or load balancer.
+type Router struct {
+	routes []route
+}
+
+type route struct {
+	pattern pattern
+	methods Methods
+}
+
+var _ Handler = (*Router)(nil)
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers a handler for a method and path pattern. Patterns use the same syntax as API Gateway, such as
+// "/flows/{id}", and any parameters are passed to the handler as path parameters.
+func (r *Router) Handle(method, path string, handler Handler) {
+	p := newPattern(path)
+	for _, existing := range r.routes {
+		if slices.Equal(existing.pattern, p) {
+			existing.methods[method] = handler
+			return
+		}
+	}
+
+	r.routes = append(r.routes, route{p, Methods{method: handler}})
+}
+
+func (r *Router) Serve(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	for _, route := range r.routes {
+		params, ok := route.pattern.Match(req.Path)
+		if !ok {
+			continue
+		}
+
+		// Any parameters from the trigger belong to the catch-all route rather than the matched route
+		req.PathParameters = params
+		req.Resource = "/" + strings.Join(route.pattern, "/")
+		return route.methods.Serve(ctx, req)
+	}
+
+	return lambda.Error(types.ErrorNotFound, "not found", http.StatusNotFound), nil
+}
@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// HTTPAPI adapts a handler to API Gateway HTTP APIs using the version 2.0 payload format
+func HTTPAPI(handler Handler) func(context.Context, events.APIGatewayV2HTTPRequest) (*events.APIGatewayV2HTTPResponse, error) {
+	return func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (*events.APIGatewayV2HTTPResponse, error) {
+		converted, err := fromV2Request(&req)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := handler.Serve(ctx, converted)
+		if err != nil {
+			return nil, err
+		}
+
+		return &events.APIGatewayV2HTTPResponse{
+			StatusCode: res.StatusCode,
+			Headers:    flattenHeaders(res),
+			Body:       res.Body,
+
+			IsBase64Encoded: res.IsBase64Encoded,
+		}, nil
+	}
+}
+
+// FunctionURL adapts a handler to Lambda function URLs
+func FunctionURL(handler Handler) func(context.Context, events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLResponse, error) {
+	httpApi := HTTPAPI(handler)
+
+	return func(ctx context.Context, req events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLResponse, error) {
+		// Function URLs use the same payload as HTTP APIs, just without any routing information
+		res, err := httpApi(ctx, events.APIGatewayV2HTTPRequest{
+			Version:               req.Version,
+			RouteKey:              "$default",
+			RawPath:               req.RawPath,
+			RawQueryString:        req.RawQueryString,
+			Cookies:               req.Cookies,
+			Headers:               req.Headers,
+			QueryStringParameters: req.QueryStringParameters,
+			RequestContext: events.APIGatewayV2HTTPRequestContext{
+				RouteKey:     "$default",
+				AccountID:    req.RequestContext.AccountID,
+				Stage:        "$default",
+				RequestID:    req.RequestContext.RequestID,
+				APIID:        req.RequestContext.APIID,
+				DomainName:   req.RequestContext.DomainName,
+				DomainPrefix: req.RequestContext.DomainPrefix,
+				Time:         req.RequestContext.Time,
+				TimeEpoch:    req.RequestContext.TimeEpoch,
+				HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+					Method:    req.RequestContext.HTTP.Method,
+					Path:      req.RequestContext.HTTP.Path,
+					Protocol:  req.RequestContext.HTTP.Protocol,
+					SourceIP:  req.RequestContext.HTTP.SourceIP,
+					UserAgent: req.RequestContext.HTTP.UserAgent,
+				},
+			},
+			Body:            req.Body,
+			IsBase64Encoded: req.IsBase64Encoded,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &events.LambdaFunctionURLResponse{
+			StatusCode:      res.StatusCode,
+			Headers:         res.Headers,
+			Body:            res.Body,
+			IsBase64Encoded: res.IsBase64Encoded,
+		}, nil
+	}
+}
+
+// fromV2Request converts a version 2.0 payload into the REST API payload the handlers expect
+func fromV2Request(req *events.APIGatewayV2HTTPRequest) (events.APIGatewayProxyRequest, error) {
+	body, err := decodeBody(req.Body, req.IsBase64Encoded)
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+
+	query, err := url.ParseQuery(req.RawQueryString)
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, fmt.Errorf("invalid query string: %w", err)
+	}
+
+	headers := make(map[string]string, len(req.Headers)+1)
+	for key, value := range req.Headers {
+		headers[key] = value
+	}
+	if len(req.Cookies) != 0 {
+		// Cookies are moved out of the headers in the version 2.0 payload
+		headers["cookie"] = strings.Join(req.Cookies, "; ")
+	}
+
+	// Routes are formatted as "<method> <path>", but the catch-all route has no path
+	resource := req.RawPath
+	if _, path, ok := strings.Cut(req.RouteKey, " "); ok {
+		resource = path
+	}
+
+	return events.APIGatewayProxyRequest{
+		Resource:   resource,
+		Path:       req.RawPath,
+		HTTPMethod: req.RequestContext.HTTP.Method,
+
+		Headers: headers,
+
+		QueryStringParameters:           fromMultiValueMap(query),
+		MultiValueQueryStringParameters: query,
+
+		PathParameters: req.PathParameters,
+		StageVariables: req.StageVariables,
+
+		Body: body,
+
+		RequestContext: events.APIGatewayProxyRequestContext{
+			AccountID: req.RequestContext.AccountID,
+			APIID:     req.RequestContext.APIID,
+			Stage:     req.RequestContext.Stage,
+			RequestID: req.RequestContext.RequestID,
+
+			DomainName:   req.RequestContext.DomainName,
+			DomainPrefix: req.RequestContext.DomainPrefix,
+			ResourcePath: resource,
+			HTTPMethod:   req.RequestContext.HTTP.Method,
+
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  req.RequestContext.HTTP.SourceIP,
+				UserAgent: req.RequestContext.HTTP.UserAgent,
+			},
+		},
+	}, nil
+}
+
+// decodeBody undoes any base64 encoding applied to the body by AWS
+func decodeBody(body string, encoded bool) (string, error) {
+	if !encoded {
+		return body, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64-encoded body: %w", err)
+	}
+
+	return string(decoded), nil
+}
+
+// flattenHeaders combines single and multi-value headers, joining repeated values with commas
+func flattenHeaders(res *events.APIGatewayProxyResponse) map[string]string {
+	headers := make(map[string]string, len(res.Headers)+len(res.MultiValueHeaders))
+	for key, value := range res.Headers {
+		headers[key] = value
+	}
+	for key, values := range res.MultiValueHeaders {
+		headers[key] = strings.Join(values, ",")
+	}
+
+	return headers
+}
@@ -2,7 +2,11 @@ package lambda
 
 import (
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/akrantz01/tailfed/internal/types"
 	"github.com/aws/aws-lambda-go/events"
@@ -16,19 +20,54 @@ func Success[T any](data *T) *events.APIGatewayProxyResponse {
 	}, http.StatusOK)
 }
 
+// Document creates a successful HTTP response containing a standalone JSON document, without the response envelope
+func Document(document any, maxAge time.Duration) *events.APIGatewayProxyResponse {
+	res := makeJsonResponse(document, http.StatusOK)
+	res.Headers["Cache-Control"] = fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+	return res
+}
+
 // InternalServerError creates an error HTTP response for unexpected server errors
 func InternalServerError() *events.APIGatewayProxyResponse {
-	return Error("internal server error", http.StatusInternalServerError)
+	return Error(types.ErrorInternal, "internal server error", http.StatusInternalServerError)
 }
 
-// Error creates an error HTTP response with a message and status code
-func Error(message string, statusCode int) *events.APIGatewayProxyResponse {
+// Error creates an error HTTP response with a machine-readable code, message, and status code
+func Error(code types.ErrorCode, message string, statusCode int) *events.APIGatewayProxyResponse {
 	return makeJsonResponse(&types.Response[struct{}]{
 		Success: false,
 		Error:   message,
+		Code:    code,
 	}, statusCode)
 }
 
+// VerificationFailed creates an error HTTP response explaining why the challenge could not be verified
+func VerificationFailed(failure *types.VerificationFailure) *events.APIGatewayProxyResponse {
+	return makeJsonResponse(&types.Response[struct{}]{
+		Success:      false,
+		Error:        "challenge failed",
+		Code:         types.ErrorChallengeFailed,
+		Verification: failure,
+	}, http.StatusForbidden)
+}
+
+// TooManyRequests creates an error HTTP response telling the client when it can try again
+func TooManyRequests(message string, retryAfter time.Duration) *events.APIGatewayProxyResponse {
+	return WithRetryAfter(Error(types.ErrorRateLimited, message, http.StatusTooManyRequests), retryAfter)
+}
+
+// ServiceUnavailable creates an error HTTP response telling the client the server is overloaded and when it can try
+// again
+func ServiceUnavailable(message string, retryAfter time.Duration) *events.APIGatewayProxyResponse {
+	return WithRetryAfter(Error(types.ErrorUnavailable, message, http.StatusServiceUnavailable), retryAfter)
+}
+
+// WithRetryAfter tells the client how long to wait before trying again, rounded up to the nearest second
+func WithRetryAfter(res *events.APIGatewayProxyResponse, retryAfter time.Duration) *events.APIGatewayProxyResponse {
+	res.Headers["Retry-After"] = strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+	return res
+}
+
 func makeJsonResponse[T any](body T, statusCode int) *events.APIGatewayProxyResponse {
 	encoded, _ := json.Marshal(body)
 	return &events.APIGatewayProxyResponse{
@@ -0,0 +1,72 @@
+package whois
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/types"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// Identity describes the tailnet node a request was made from
+type Identity struct {
+	// NodeID is the stable ID of the node
+	NodeID string
+	// Name is the node's fully-qualified MagicDNS name
+	Name string
+	// LoginName is the user owning the node, or tagged-devices for tagged nodes
+	LoginName string
+}
+
+// Resolver looks up which tailnet node owns an address
+type Resolver interface {
+	WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+}
+
+type contextKey struct{}
+
+// FromContext retrieves the identity of the caller, if the request was made over the tailnet
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(*Identity)
+	return identity, ok
+}
+
+// Middleware resolves the tailnet identity of the caller, rejecting requests from unknown peers
+func Middleware(resolver Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			res, err := resolver.WhoIs(ctx, r.RemoteAddr)
+			if err != nil || res.Node == nil {
+				logging.FromContext(ctx).WithError(err).WithField("address", r.RemoteAddr).Warn("failed to identify caller")
+				forbidden(w)
+				return
+			}
+
+			identity := &Identity{
+				NodeID: string(res.Node.StableID),
+				Name:   res.Node.Name,
+			}
+			if res.UserProfile != nil {
+				identity.LoginName = res.UserProfile.LoginName
+			}
+
+			ctx = context.WithValue(ctx, contextKey{}, identity)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func forbidden(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+
+	_ = json.NewEncoder(w).Encode(&types.Response[struct{}]{
+		Success: false,
+		Error:   "caller is not a member of the tailnet",
+		Code:    types.ErrorPolicyDenied,
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/netip"
 	"strings"
@@ -11,8 +13,10 @@ import (
 
 	"github.com/akrantz01/tailfed/internal/http/gateway"
 	"github.com/akrantz01/tailfed/internal/http/lambda"
+	"github.com/akrantz01/tailfed/internal/http/whois"
 	"github.com/akrantz01/tailfed/internal/launcher"
 	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/oidc"
 	"github.com/akrantz01/tailfed/internal/storage"
 	"github.com/akrantz01/tailfed/internal/tailscale"
 	"github.com/akrantz01/tailfed/internal/types"
@@ -23,6 +27,8 @@ import (
 // Handler responds to incoming flow start requests, performing the necessary validations before issuing a challenge and
 // kicking off the verification workflow.
 type Handler struct {
+	issuer *oidc.Issuer
+	limits Limits
 	launch launcher.Backend
 	store  storage.Backend
 	ts     tailscale.ControlPlane
@@ -31,25 +37,83 @@ type Handler struct {
 var _ gateway.Handler = (*Handler)(nil)
 
 // New creates a new handler
-func New(client tailscale.ControlPlane, launch launcher.Backend, store storage.Backend) *Handler {
+func New(issuer *oidc.Issuer, limits Limits, client tailscale.ControlPlane, launch launcher.Backend, store storage.Backend) *Handler {
 	return &Handler{
+		issuer: issuer,
+		limits: limits,
 		store:  store,
 		launch: launch,
 		ts:     client,
 	}
 }
 
-func (h *Handler) Serve(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+const (
+	// flowLifetime is how long a client has to complete a flow once started
+	flowLifetime = 5 * time.Minute
+	// launchRetryAfter is how long clients are told to wait when the verifier cannot accept more flows
+	launchRetryAfter = 5 * time.Second
+
+	// Client-supplied signing secrets must be as hard to guess as the ones generated by the server
+	minSigningSecretLength = 32
+	maxSigningSecretLength = 64
+)
+
+func (h *Handler) Serve(ctx context.Context, req events.APIGatewayProxyRequest) (res *events.APIGatewayProxyResponse, err error) {
 	logger := logging.FromContext(ctx).WithField("component", "logger")
 
+	if !h.issuer.Allow(logger, gateway.Host(&req, h.issuer.TrustsProxy())) {
+		return lambda.Error(types.ErrorPolicyDenied, "unknown hostname", http.StatusMisdirectedRequest), nil
+	}
+
 	var body types.StartRequest
 	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
-		return lambda.Error("invalid request body", http.StatusUnprocessableEntity), nil
+		return lambda.Error(types.ErrorInvalidRequest, "invalid request body", http.StatusUnprocessableEntity), nil
 	}
-	logger.WithField("body", body).Debug("")
+	logger.WithField("body", body.Redacted()).Debug("")
 
 	if body.Ports.IPv4 == 0 || body.Ports.IPv6 == 0 {
-		return lambda.Error("must have two port bindings", http.StatusUnprocessableEntity), nil
+		return lambda.Error(types.ErrorInvalidRequest, "must have two port bindings", http.StatusUnprocessableEntity), nil
+	}
+
+	// Requests made over the tailnet can only start flows for the node making them
+	if caller, ok := whois.FromContext(ctx); ok && caller.NodeID != body.Node {
+		logger.WithFields(map[string]any{
+			"caller":    caller.NodeID,
+			"name":      caller.Name,
+			"requested": body.Node,
+		}).Warn("caller attempted to start flow for another node")
+		return lambda.Error(types.ErrorPolicyDenied, "caller is not the requested node", http.StatusForbidden), nil
+	}
+
+	// Inline verification happens before the client learns the flow, so it must already be serving its challenge
+	if _, inline := h.launch.(launcher.Synchronous); inline && len(body.SigningSecret) == 0 {
+		return lambda.Error(types.ErrorInvalidRequest, "signing secret is required", http.StatusUnprocessableEntity), nil
+	} else if len(body.SigningSecret) != 0 && (len(body.SigningSecret) < minSigningSecretLength || len(body.SigningSecret) > maxSigningSecretLength) {
+		return lambda.Error(types.ErrorInvalidRequest, fmt.Sprintf("signing secret must be between %d and %d bytes", minSigningSecretLength, maxSigningSecretLength), http.StatusUnprocessableEntity), nil
+	}
+
+	var digest []byte
+	if len(body.IdempotencyKey) != 0 {
+		if err := validateIdempotencyKey(body.IdempotencyKey); err != nil {
+			return lambda.Error(types.ErrorInvalidRequest, err.Error(), http.StatusUnprocessableEntity), nil
+		}
+
+		digest = fingerprint(body)
+		if replayed, err := h.replay(ctx, logger, &body, digest); err != nil {
+			logger.WithError(err).Error("failed to check idempotency key")
+			return lambda.InternalServerError(), nil
+		} else if replayed != nil {
+			return replayed, nil
+		}
+	}
+
+	source := gateway.SourceIP(&req)
+	if exceeded, err := h.checkSourceRate(ctx, source); err != nil {
+		logger.WithError(err).Error("failed to check source rate limit")
+		return lambda.InternalServerError(), nil
+	} else if exceeded != nil {
+		logger.WithField("source", source).Warn(exceeded.message)
+		return lambda.TooManyRequests(exceeded.message, exceeded.retryAfter), nil
 	}
 
 	info, err := h.ts.NodeInfo(ctx, body.Node)
@@ -58,7 +122,7 @@ func (h *Handler) Serve(ctx context.Context, req events.APIGatewayProxyRequest)
 		return lambda.InternalServerError(), nil
 	} else if info == nil {
 		logger.Warn("attempt to start token issuance for non-existent node")
-		return lambda.Error("node not found", http.StatusUnprocessableEntity), nil
+		return lambda.Error(types.ErrorNodeNotFound, "node not found", http.StatusUnprocessableEntity), nil
 	}
 
 	if len(info.Addresses) != 2 {
@@ -66,20 +130,55 @@ func (h *Handler) Serve(ctx context.Context, req events.APIGatewayProxyRequest)
 		return lambda.InternalServerError(), nil
 	}
 
+	if exceeded, err := h.checkNodeRate(ctx, info.ID); err != nil {
+		logger.WithError(err).Error("failed to check node rate limit")
+		return lambda.InternalServerError(), nil
+	} else if exceeded != nil {
+		logger.Warn(exceeded.message)
+		return lambda.TooManyRequests(exceeded.message, exceeded.retryAfter), nil
+	}
+
+	if h.limits.Pending > 0 {
+		exceeded, err := h.reservePending(ctx, info.ID)
+		if err != nil {
+			logger.WithError(err).Error("failed to reserve pending flow")
+			return lambda.InternalServerError(), nil
+		} else if exceeded != nil {
+			logger.Warn(exceeded.message)
+			return lambda.TooManyRequests(exceeded.message, exceeded.retryAfter), nil
+		}
+
+		// Release the reservation if the flow never starts
+		defer func() {
+			if res.StatusCode == http.StatusOK {
+				return
+			}
+
+			// The reservation was made by this request, so its window resets within a flow lifetime from now
+			if err := h.store.Decrement(ctx, storage.PendingFlowsKey(info.ID), time.Now().Add(flowLifetime)); err != nil {
+				logger.WithError(err).Error("failed to release pending flow")
+			}
+		}()
+	}
+
 	id := uuid.Must(uuid.NewV7()).String()
 
-	secret := make([]byte, 64)
-	if _, err := rand.Read(secret); err != nil {
-		logger.WithError(err).Error("failed to generate signing secret")
-		return lambda.InternalServerError(), nil
+	secret := body.SigningSecret
+	if len(secret) == 0 {
+		secret = make([]byte, maxSigningSecretLength)
+		if _, err := rand.Read(secret); err != nil {
+			logger.WithError(err).Error("failed to generate signing secret")
+			return lambda.InternalServerError(), nil
+		}
 	}
 
+	expiresAt := time.Now().UTC().Add(flowLifetime)
 	dnsNameParts := strings.Split(info.DNSName, ".")
 
 	err = h.store.Put(ctx, &storage.Flow{
 		ID:          id,
 		Status:      storage.StatusPending,
-		ExpiresAt:   storage.UnixTime(time.Now().UTC().Add(5 * time.Minute)),
+		ExpiresAt:   storage.UnixTime(expiresAt),
 		Secret:      secret,
 		Node:        info.ID,
 		PublicKey:   info.Key,
@@ -97,6 +196,45 @@ func (h *Handler) Serve(ctx context.Context, req events.APIGatewayProxyRequest)
 		return lambda.InternalServerError(), nil
 	}
 
+	// Remove the flow if it never starts, as it can never be verified
+	defer func() {
+		if res.StatusCode == http.StatusOK {
+			return
+		}
+
+		if err := h.store.Delete(ctx, id); err != nil {
+			logger.WithError(err).Error("failed to remove unstarted flow")
+		}
+	}()
+
+	// The key is only claimed once its flow is saved, so replays always find the flow unless it already completed
+	if len(body.IdempotencyKey) != 0 {
+		err := h.store.PutIdempotencyKey(ctx, &storage.IdempotencyKey{
+			Key:         body.IdempotencyKey,
+			FlowID:      id,
+			Fingerprint: digest,
+			ExpiresAt:   expiresAt,
+		})
+		if errors.Is(err, storage.ErrConflict) {
+			logger.Warn("concurrent request with the same idempotency key")
+			return lambda.Error(types.ErrorIdempotencyInProgress, "request with the same idempotency key is in progress", http.StatusConflict), nil
+		} else if err != nil {
+			logger.WithError(err).Error("failed to claim idempotency key")
+			return lambda.InternalServerError(), nil
+		}
+
+		// Release the key if the flow never starts so the client can retry
+		defer func() {
+			if res.StatusCode == http.StatusOK {
+				return
+			}
+
+			if err := h.store.DeleteIdempotencyKey(ctx, body.IdempotencyKey); err != nil {
+				logger.WithError(err).Error("failed to release idempotency key")
+			}
+		}()
+	}
+
 	addresses := make([]netip.AddrPort, 0, 2)
 	for _, address := range info.Addresses {
 		var port uint16
@@ -109,10 +247,34 @@ func (h *Handler) Serve(ctx context.Context, req events.APIGatewayProxyRequest)
 		addresses = append(addresses, netip.AddrPortFrom(address, port))
 	}
 
-	if err := h.launch.Launch(id, addresses); err != nil {
+	status, err := h.start(ctx, id, addresses)
+	if err != nil {
+		if errors.Is(err, launcher.ErrUnavailable) {
+			logger.WithError(err).Warn("verifier is unavailable")
+			return lambda.ServiceUnavailable("verifier is busy, try again later", launchRetryAfter), nil
+		}
+
 		logger.WithError(err).Error("failed to launch verifier")
 		return lambda.InternalServerError(), nil
 	}
 
-	return lambda.Success(&types.StartResponse{ID: id, SigningSecret: secret}), nil
+	return lambda.Success(&types.StartResponse{ID: id, SigningSecret: secret, Status: status}), nil
+}
+
+// start launches the verification of the flow's addresses. If the launcher verifies the flow before returning, the
+// resulting status is returned.
+func (h *Handler) start(ctx context.Context, id string, addresses []netip.AddrPort) (string, error) {
+	sync, ok := h.launch.(launcher.Synchronous)
+	if !ok {
+		return "", h.launch.Launch(ctx, id, addresses)
+	}
+
+	verified, err := sync.Verify(ctx, id, addresses)
+	if err != nil {
+		return "", err
+	} else if verified {
+		return string(storage.StatusSuccess), nil
+	}
+
+	return string(storage.StatusFailed), nil
 }
@@ -0,0 +1,71 @@
+package initializer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/akrantz01/tailfed/internal/http/lambda"
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sirupsen/logrus"
+)
+
+// Replaying an idempotency key returns the flow's secret, so keys must be long enough to be unguessable
+const (
+	minIdempotencyKeyLength = 16
+	maxIdempotencyKeyLength = 255
+)
+
+// validateIdempotencyKey ensures a client-supplied key is within the allowed bounds
+func validateIdempotencyKey(key string) error {
+	if len(key) < minIdempotencyKeyLength || len(key) > maxIdempotencyKeyLength {
+		return fmt.Errorf("idempotency key must be between %d and %d characters", minIdempotencyKeyLength, maxIdempotencyKeyLength)
+	}
+
+	return nil
+}
+
+// fingerprint computes a digest of the request, ignoring the idempotency key
+func fingerprint(body types.StartRequest) []byte {
+	body.IdempotencyKey = ""
+
+	encoded, _ := json.Marshal(&body)
+	sum := sha256.Sum256(encoded)
+	return sum[:]
+}
+
+// replay responds with the flow previously started for the request's idempotency key. If the key has not been
+// claimed, nil is returned and a new flow should be started.
+func (h *Handler) replay(ctx context.Context, logger logrus.FieldLogger, body *types.StartRequest, digest []byte) (*events.APIGatewayProxyResponse, error) {
+	key, err := h.store.GetIdempotencyKey(ctx, body.IdempotencyKey)
+	if err != nil || key == nil {
+		return nil, err
+	}
+
+	logger = logger.WithField("flow", key.FlowID)
+	if !bytes.Equal(key.Fingerprint, digest) {
+		logger.Warn("idempotency key reused for a different request")
+		return lambda.Error(types.ErrorIdempotencyMismatch, "idempotency key was used for a different request", http.StatusUnprocessableEntity), nil
+	}
+
+	flow, err := h.store.Get(ctx, key.FlowID)
+	if err != nil {
+		return nil, err
+	} else if flow == nil {
+		// Keys are claimed after their flow is saved, so the flow was already finalized or failed to start
+		logger.Warn("flow for idempotency key is no longer available")
+		return lambda.Error(types.ErrorFlowExpired, "flow for idempotency key is no longer available", http.StatusConflict), nil
+	}
+
+	logger.Info("replaying existing flow for idempotency key")
+	res := &types.StartResponse{ID: flow.ID, SigningSecret: flow.Secret}
+	if flow.Status != storage.StatusPending {
+		res.Status = string(flow.Status)
+	}
+	return lambda.Success(res), nil
+}
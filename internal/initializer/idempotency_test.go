@@ -0,0 +1,266 @@
+package initializer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/oidc"
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/tailscale"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testNode           = "node"
+	testIdempotencyKey = "0123456789abcdef"
+)
+
+// fakeControlPlane knows about a single node
+type fakeControlPlane struct{}
+
+func (fakeControlPlane) Tailnet() string { return "example.ts.net" }
+
+func (fakeControlPlane) NodeInfo(_ context.Context, id string) (*tailscale.NodeInfo, error) {
+	if id != testNode {
+		return nil, nil
+	}
+
+	return &tailscale.NodeInfo{
+		ID:        testNode,
+		Addresses: []netip.Addr{netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("fd7a:115c:a1e0::1")},
+		Key:       "nodekey:abc",
+		DNSName:   "node.example.ts.net.",
+		Hostname:  "node",
+		Tailnet:   "example.ts.net",
+	}, nil
+}
+
+// fakeLauncher counts launches, failing them while err is set
+type fakeLauncher struct {
+	launched int
+	err      error
+}
+
+func (l *fakeLauncher) Launch(context.Context, string, []netip.AddrPort) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	l.launched++
+	return nil
+}
+
+// racingStore claims every idempotency key for another flow the first time it is looked up, as if a concurrent
+// request claimed it between the replay check and this request claiming it
+type racingStore struct {
+	storage.Backend
+}
+
+func (s racingStore) GetIdempotencyKey(ctx context.Context, key string) (*storage.IdempotencyKey, error) {
+	err := s.PutIdempotencyKey(ctx, &storage.IdempotencyKey{Key: key, FlowID: "concurrent", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil && !errors.Is(err, storage.ErrConflict) {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func newTestHandler(t *testing.T) (*Handler, storage.Backend, *fakeLauncher) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	store, err := storage.NewFilesystem(logger, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer, err := oidc.NewIssuer("https://id.example.com", nil, oidc.HostPolicyReject, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	launch := &fakeLauncher{}
+	return New(issuer, Limits{}, fakeControlPlane{}, launch, store), store, launch
+}
+
+// start sends a start request for the test node, decoding the response
+func start(t *testing.T, h *Handler, body types.StartRequest) (int, types.Response[types.StartResponse]) {
+	t.Helper()
+
+	encoded, err := json.Marshal(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := h.Serve(context.Background(), events.APIGatewayProxyRequest{
+		Headers: map[string]string{"Host": "id.example.com"},
+		Body:    string(encoded),
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Identity: events.APIGatewayRequestIdentity{SourceIP: "203.0.113.7"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded types.Response[types.StartResponse]
+	if err := json.Unmarshal([]byte(res.Body), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode, decoded
+}
+
+func startRequest(ipv4 uint16) types.StartRequest {
+	return types.StartRequest{
+		Node:           testNode,
+		Ports:          types.Ports{IPv4: ipv4, IPv6: 5678},
+		IdempotencyKey: testIdempotencyKey,
+	}
+}
+
+func TestIdempotentStart(t *testing.T) {
+	tests := map[string]struct {
+		// setup runs before the repeated request, given the response to the first one
+		setup func(t *testing.T, store storage.Backend, first *types.StartResponse)
+		// retry is the repeated request
+		retry  types.StartRequest
+		status int
+		code   types.ErrorCode
+		replay bool
+	}{
+		"replays the same flow": {
+			retry:  startRequest(1234),
+			status: http.StatusOK,
+			replay: true,
+		},
+		"replays a verified flow": {
+			setup: func(t *testing.T, store storage.Backend, first *types.StartResponse) {
+				if err := store.Transition(context.Background(), first.ID, storage.StatusPending, storage.StatusSuccess); err != nil {
+					t.Fatal(err)
+				}
+			},
+			retry:  startRequest(1234),
+			status: http.StatusOK,
+			replay: true,
+		},
+		"rejects a different request": {
+			retry:  startRequest(4321),
+			status: http.StatusUnprocessableEntity,
+			code:   types.ErrorIdempotencyMismatch,
+		},
+		"reports a finished flow": {
+			setup: func(t *testing.T, store storage.Backend, first *types.StartResponse) {
+				if err := store.Delete(context.Background(), first.ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			retry:  startRequest(1234),
+			status: http.StatusConflict,
+			code:   types.ErrorFlowExpired,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h, store, launch := newTestHandler(t)
+
+			status, first := start(t, h, startRequest(1234))
+			if status != http.StatusOK || !first.Success {
+				t.Fatalf("expected first request to succeed, got %d: %+v", status, first)
+			}
+
+			if tt.setup != nil {
+				tt.setup(t, store, first.Data)
+			}
+
+			status, retried := start(t, h, tt.retry)
+			if status != tt.status || retried.Code != tt.code {
+				t.Fatalf("expected %d (%q), got %d: %+v", tt.status, tt.code, status, retried)
+			}
+
+			if tt.replay {
+				if retried.Data.ID != first.Data.ID || string(retried.Data.SigningSecret) != string(first.Data.SigningSecret) {
+					t.Fatalf("expected flow %q to be replayed, got %+v", first.Data.ID, retried.Data)
+				}
+			}
+			if launch.launched != 1 {
+				t.Fatalf("expected a single flow to be launched, got %d", launch.launched)
+			}
+		})
+	}
+}
+
+func TestIdempotentStartInProgress(t *testing.T) {
+	h, store, launch := newTestHandler(t)
+	h.store = racingStore{store}
+
+	status, res := start(t, h, startRequest(1234))
+	if status != http.StatusConflict || res.Code != types.ErrorIdempotencyInProgress {
+		t.Fatalf("expected concurrent request to conflict, got %d: %+v", status, res)
+	}
+	if launch.launched != 0 {
+		t.Fatalf("expected no flow to be launched, got %d", launch.launched)
+	}
+
+	// The key still belongs to the concurrent request
+	key, err := store.GetIdempotencyKey(context.Background(), testIdempotencyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == nil || key.FlowID != "concurrent" {
+		t.Fatalf("expected the concurrent request to keep its key, got %+v", key)
+	}
+
+	flows, err := store.List(context.Background(), storage.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 0 {
+		t.Fatalf("expected the conflicting flow to be removed, got %+v", flows)
+	}
+}
+
+func TestIdempotentStartReleasesKeyOnFailure(t *testing.T) {
+	h, store, launch := newTestHandler(t)
+
+	launch.err = errors.New("launch failed")
+	if status, res := start(t, h, startRequest(1234)); status != http.StatusInternalServerError {
+		t.Fatalf("expected failed launch to error, got %d: %+v", status, res)
+	}
+
+	key, err := store.GetIdempotencyKey(context.Background(), testIdempotencyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		t.Fatalf("expected the key to be released, got %+v", key)
+	}
+
+	flows, err := store.List(context.Background(), storage.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 0 {
+		t.Fatalf("expected the unstarted flow to be removed, got %+v", flows)
+	}
+
+	// Retrying with the same key starts a new flow
+	launch.err = nil
+	if status, res := start(t, h, startRequest(1234)); status != http.StatusOK || !res.Success {
+		t.Fatalf("expected retry to succeed, got %d: %+v", status, res)
+	}
+	if launch.launched != 1 {
+		t.Fatalf("expected the retry to launch the flow, got %d", launch.launched)
+	}
+}
@@ -0,0 +1,86 @@
+package initializer
+
+import (
+	"context"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/storage"
+)
+
+// Limits restricts how frequently flows can be started
+type Limits struct {
+	// Node limits how many flows can be started for a single node
+	Node Rate
+	// Source limits how many flows can be started from a single IP address
+	Source Rate
+	// Pending caps how many flows can be in-progress for a single node, zero means unlimited. Slots are released when a
+	// flow is finalized or fails, and flows that are abandoned stop counting once they expire.
+	Pending int
+}
+
+// Rate allows a number of requests within a fixed window, zero requests means unlimited
+type Rate struct {
+	Requests int
+	Window   time.Duration
+}
+
+// limitExceeded describes which limit was hit and when it can be retried
+type limitExceeded struct {
+	message    string
+	retryAfter time.Duration
+}
+
+// checkSourceRate counts the request against the per-source rate
+func (h *Handler) checkSourceRate(ctx context.Context, source string) (*limitExceeded, error) {
+	return checkRate(ctx, h.store, sourceRateKey(source), h.limits.Source, "too many requests from address")
+}
+
+// checkNodeRate counts the request against the per-node rate. It must only be called with the ID of a node that
+// exists, otherwise anyone could use up a node's quota or create counters for made-up nodes.
+func (h *Handler) checkNodeRate(ctx context.Context, node string) (*limitExceeded, error) {
+	return checkRate(ctx, h.store, nodeRateKey(node), h.limits.Node, "too many requests for node")
+}
+
+// reservePending claims a pending flow slot for the node, reporting whether the cap was exceeded
+func (h *Handler) reservePending(ctx context.Context, node string) (*limitExceeded, error) {
+	count, resetAt, err := h.store.Increment(ctx, storage.PendingFlowsKey(node), flowLifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > h.limits.Pending {
+		if err := h.store.Decrement(ctx, storage.PendingFlowsKey(node), resetAt); err != nil {
+			return nil, err
+		}
+
+		return &limitExceeded{"too many pending flows for node", time.Until(resetAt)}, nil
+	}
+
+	return nil, nil
+}
+
+// checkRate counts the request against the rate, reporting whether it was exceeded
+func checkRate(ctx context.Context, store storage.Backend, key string, rate Rate, message string) (*limitExceeded, error) {
+	if rate.Requests <= 0 {
+		return nil, nil
+	}
+
+	count, resetAt, err := store.Increment(ctx, key, rate.Window)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > rate.Requests {
+		return &limitExceeded{message, time.Until(resetAt)}, nil
+	}
+
+	return nil, nil
+}
+
+func nodeRateKey(node string) string {
+	return "rate:node:" + node
+}
+
+func sourceRateKey(source string) string {
+	return "rate:source:" + source
+}
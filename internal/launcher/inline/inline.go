@@ -0,0 +1,62 @@
+package inline
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+
+	"github.com/akrantz01/tailfed/internal/launcher"
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Verifier performs a single verification attempt against an address
+type Verifier interface {
+	Serve(ctx context.Context, req types.VerifyRequest) (*types.VerifyResponse, error)
+}
+
+// Launcher verifies challenges while the start request is being handled, making a single attempt bounded by the
+// verifier's HTTP client timeout
+type Launcher struct {
+	logger   logrus.FieldLogger
+	verifier Verifier
+	store    storage.Backend
+}
+
+var _ launcher.Synchronous = (*Launcher)(nil)
+
+// New creates a launcher that verifies flows before the start request responds
+func New(logger logrus.FieldLogger, verifier Verifier, store storage.Backend) *Launcher {
+	logger.Info("created new inline launcher")
+	return &Launcher{logger, verifier, store}
+}
+
+func (l *Launcher) Launch(ctx context.Context, id string, addresses []netip.AddrPort) error {
+	_, err := l.Verify(ctx, id, addresses)
+	return err
+}
+
+func (l *Launcher) Verify(ctx context.Context, id string, addresses []netip.AddrPort) (bool, error) {
+	logger := l.logger.WithField("flow", id)
+	logger.Debug("verifying addresses inline")
+
+	resp, err := l.verifier.Serve(ctx, types.VerifyRequest{ID: id, Addresses: addresses})
+	if err != nil {
+		logger.WithError(err).Error("verifier execution failed")
+		return false, err
+	} else if resp.Success {
+		logger.Info("verification succeeded")
+		return true, nil
+	}
+
+	logger.Warn("verification failed")
+
+	err = storage.MarkFailed(ctx, l.store, id)
+	if err != nil && !errors.Is(err, storage.ErrConflict) {
+		logger.WithError(err).Error("failed to mark flow as failed")
+		return false, err
+	}
+
+	return false, nil
+}
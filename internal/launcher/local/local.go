@@ -0,0 +1,294 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/launcher"
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Verifier performs a single verification attempt against an address
+type Verifier interface {
+	Serve(ctx context.Context, req types.VerifyRequest) (*types.VerifyResponse, error)
+}
+
+// Config controls how many verifications run at once and how failed attempts are retried
+type Config struct {
+	// Workers is how many flows are verified concurrently
+	Workers int
+	// QueueSize is how many flows can wait for a worker before launches are rejected, it must be positive as flows are
+	// always queued
+	QueueSize int
+	// MaxAttempts is how many times a flow is attempted before it is marked as failed
+	MaxAttempts int
+	// Backoff is how long to wait after the first failed attempt, doubling after each subsequent attempt
+	Backoff time.Duration
+	// MaxBackoff caps how long to wait between attempts
+	MaxBackoff time.Duration
+}
+
+// Launcher verifies challenges in-process using a bounded pool of workers
+type Launcher struct {
+	logger   logrus.FieldLogger
+	verifier Verifier
+	store    storage.Backend
+	config   Config
+
+	queue  chan launcher.Request
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var _ launcher.Backend = (*Launcher)(nil)
+
+// New creates a launcher that verifies flows in-process. Workers are not started until Start is called.
+func New(logger logrus.FieldLogger, verifier Verifier, store storage.Backend, config Config) *Launcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	logger.WithFields(map[string]any{
+		"workers":    config.Workers,
+		"queue-size": config.QueueSize,
+	}).Info("created new local launcher")
+	return &Launcher{
+		logger:   logger,
+		verifier: verifier,
+		store:    store,
+		config:   config,
+		queue:    make(chan launcher.Request, config.QueueSize),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start launches the workers and resumes any verifications that were in progress when the process last exited. It
+// must be called before any flows are launched.
+func (l *Launcher) Start() {
+	for range l.config.Workers {
+		l.wg.Add(1)
+		go l.work()
+	}
+
+	// Unfinished flows are found up front so they cannot be confused with ones launched after starting
+	unfinished, err := l.unfinished()
+	if err != nil {
+		l.logger.WithError(err).Error("failed to find flows to resume")
+	} else if len(unfinished) > 0 {
+		l.wg.Add(1)
+		go l.resume(unfinished)
+	}
+
+	l.logger.Debug("started local launcher")
+}
+
+// Stop cancels any in-progress verifications and waits for the workers to exit. Queued flows are abandoned and
+// expire on their own.
+func (l *Launcher) Stop() {
+	l.cancel()
+	l.wg.Wait()
+
+	l.logger.Debug("shut down local launcher")
+}
+
+func (l *Launcher) Launch(ctx context.Context, id string, addresses []netip.AddrPort) error {
+	if l.ctx.Err() != nil {
+		return fmt.Errorf("%w: shutting down", launcher.ErrUnavailable)
+	}
+
+	if len(l.queue) == cap(l.queue) {
+		return fmt.Errorf("%w: queue is full", launcher.ErrUnavailable)
+	}
+
+	// Persist the request first so it can be resumed if the process exits before it completes
+	encoded := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		encoded = append(encoded, address.String())
+	}
+	if err := l.store.RecordLaunch(ctx, id, encoded); err != nil {
+		return fmt.Errorf("failed to record launch: %w", err)
+	}
+
+	select {
+	case l.queue <- launcher.Request{ID: id, Addresses: addresses}:
+		l.logger.WithField("id", id).Debug("queued launch request")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return fmt.Errorf("%w: queue is full", launcher.ErrUnavailable)
+	}
+}
+
+// unfinished finds the pending flows that were launched locally but never finished verifying
+func (l *Launcher) unfinished() ([]launcher.Request, error) {
+	flows, err := l.store.List(l.ctx, storage.Filter{Status: storage.StatusPending})
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []launcher.Request
+	for _, flow := range flows {
+		if len(flow.LaunchAddresses) == 0 || !time.Now().Before(time.Time(flow.ExpiresAt)) {
+			continue
+		}
+
+		addresses := make([]netip.AddrPort, 0, len(flow.LaunchAddresses))
+		for _, raw := range flow.LaunchAddresses {
+			address, err := netip.ParseAddrPort(raw)
+			if err != nil {
+				l.logger.WithField("flow", flow.ID).WithError(err).Warn("flow has invalid launch address")
+				continue
+			}
+
+			addresses = append(addresses, address)
+		}
+
+		if len(addresses) != 0 {
+			requests = append(requests, launcher.Request{ID: flow.ID, Addresses: addresses})
+		}
+	}
+
+	return requests, nil
+}
+
+// resume queues the unfinished flows. Unlike new launches, resumed flows wait for space in the queue.
+func (l *Launcher) resume(requests []launcher.Request) {
+	defer l.wg.Done()
+
+	l.logger.WithField("count", len(requests)).Info("resuming unfinished verifications")
+
+	for _, req := range requests {
+		select {
+		case l.queue <- req:
+			l.logger.WithField("flow", req.ID).Debug("resumed verification")
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+func (l *Launcher) work() {
+	defer l.wg.Done()
+
+	for {
+		select {
+		case req := <-l.queue:
+			l.verify(l.logger.WithField("flow", req.ID), req)
+
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+// verify attempts the flow's addresses until they satisfy the verification policy, the attempts are exhausted, or the
+// flow is no longer pending
+func (l *Launcher) verify(logger logrus.FieldLogger, req launcher.Request) {
+	logger.Info("received launch")
+
+	flow, err := l.store.Get(l.ctx, req.ID)
+	if err != nil {
+		logger.WithError(err).Error("failed to find flow")
+		return
+	} else if flow == nil {
+		logger.Warn("flow no longer exists")
+		return
+	}
+
+	// In-flight attempts are abandoned once the flow can no longer be finalized
+	ctx, cancel := context.WithDeadline(l.ctx, time.Time(flow.ExpiresAt))
+	defer cancel()
+
+	// Resumed flows continue with the attempts and backoff remaining from before the restart. Each attempt records at
+	// most one failure per address, so this never overestimates how many were made.
+	completed := (len(flow.Attempts) + len(req.Addresses) - 1) / len(req.Addresses)
+	if completed >= l.config.MaxAttempts {
+		logger.Error("verification failed")
+		l.markFailed(logger, req.ID)
+		return
+	}
+
+	wait := l.config.Backoff
+	for range completed {
+		wait = min(wait*2, l.config.MaxBackoff)
+	}
+
+	for attempt := completed + 1; ; attempt++ {
+		if pending, err := l.pending(ctx, req.ID); err != nil {
+			logger.WithError(err).Error("failed to check flow status")
+			return
+		} else if !pending {
+			logger.Debug("flow is no longer pending, stopping verification")
+			return
+		}
+
+		logger.WithField("attempt", attempt).Debug("attempting verification")
+
+		resp, err := l.verifier.Serve(ctx, types.VerifyRequest{
+			ID:        req.ID,
+			Addresses: req.Addresses,
+		})
+		if ctx.Err() != nil {
+			logger.Debug("verification cancelled")
+			return
+		} else if err != nil {
+			logger.WithError(err).Error("verifier execution failed")
+			l.markFailed(logger, req.ID)
+			return
+		}
+
+		if resp.Success {
+			logger.Info("verification succeeded")
+			return
+		}
+
+		if attempt >= l.config.MaxAttempts {
+			logger.Error("verification failed")
+			l.markFailed(logger, req.ID)
+			return
+		}
+
+		logger.WithField("wait", wait).Warn("attempt failed, retrying soon")
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			logger.Debug("verification cancelled")
+			return
+		}
+
+		wait = min(wait*2, l.config.MaxBackoff)
+	}
+}
+
+// pending checks whether the flow is still waiting to be verified
+func (l *Launcher) pending(ctx context.Context, id string) (bool, error) {
+	flow, err := l.store.Get(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	return flow != nil && flow.Status == storage.StatusPending && time.Now().Before(time.Time(flow.ExpiresAt)), nil
+}
+
+func (l *Launcher) markFailed(logger logrus.FieldLogger, id string) {
+	err := storage.MarkFailed(l.ctx, l.store, id)
+	if errors.Is(err, storage.ErrConflict) {
+		logger.Debug("flow is no longer pending")
+		return
+	} else if err != nil {
+		logger.WithError(err).Error("failed to mark flow as failed")
+		return
+	}
+
+	logger.Debug("flow marked as failed")
+}
@@ -0,0 +1,129 @@
+// Package setup configures the launcher and tailnet connection shared by the Lambda functions
+package setup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/launcher"
+	"github.com/akrantz01/tailfed/internal/launcher/inline"
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/verifier"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/sirupsen/logrus"
+	"tailscale.com/tsnet"
+)
+
+// Launcher selects how the verification of new flows is started
+type Launcher struct {
+	Backend      string          `koanf:"backend"`
+	StateMachine string          `koanf:"state-machine"`
+	QueueURL     string          `koanf:"queue-url"`
+	AuthKey      string          `koanf:"auth-key"`
+	Timeout      time.Duration   `koanf:"timeout"`
+	Policy       verifier.Policy `koanf:"policy"`
+}
+
+func (l *Launcher) Validate() error {
+	if len(l.Backend) == 0 {
+		l.Backend = "step-function"
+	}
+
+	switch l.Backend {
+	case "step-function":
+		if len(l.StateMachine) == 0 {
+			return errors.New("missing state machine identifier")
+		}
+	case "sqs":
+		if len(l.QueueURL) == 0 {
+			return errors.New("missing queue url")
+		}
+	case "inline":
+		if len(l.AuthKey) == 0 {
+			return errors.New("missing node auth key")
+		}
+
+		if l.Timeout == 0 {
+			l.Timeout = 5 * time.Second
+		} else if l.Timeout < 0 {
+			return errors.New("timeout must be positive")
+		}
+
+		if len(l.Policy) == 0 {
+			l.Policy = verifier.PolicyAny
+		}
+		if err := l.Policy.Validate(); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unknown launcher backend")
+	}
+
+	return nil
+}
+
+// Build creates the configured launcher backend
+func (l *Launcher) Build(config aws.Config, store storage.Backend, tailnet string) (launcher.Backend, error) {
+	logger := logrus.WithField("component", "launcher")
+	switch l.Backend {
+	case "sqs":
+		return launcher.NewSQS(logger, config, l.QueueURL)
+	case "inline":
+		ts, err := Connect(l.AuthKey, tailnet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to tailscale: %w", err)
+		}
+
+		client := ts.HTTPClient()
+		client.Timeout = l.Timeout
+
+		return inline.New(logger, verifier.New(client, store, tailnet, l.Policy), store), nil
+	default:
+		return launcher.NewStepFunction(logger, config, l.StateMachine)
+	}
+}
+
+// Connect joins the tailnet as an ephemeral node named after the Lambda function, so the clients' challenge servers
+// can be reached
+func Connect(authKey, tailnet string) (*tsnet.Server, error) {
+	logger := logrus.WithField("component", "tailscale")
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("could not get hostname: %w", err)
+	}
+	tsHostname := fmt.Sprintf("%s-%s-%s", os.Getenv("AWS_LAMBDA_FUNCTION_NAME"), os.Getenv("AWS_REGION"), hostname)
+	logger.WithField("hostname", hostname).Info("determined hostname")
+
+	ts := &tsnet.Server{
+		AuthKey:   authKey,
+		Ephemeral: true,
+		Hostname:  tsHostname,
+		Dir:       "/tmp",
+		UserLogf:  logger.Infof,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	status, err := ts.Up(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if status.CurrentTailnet == nil || status.CurrentTailnet.Name != tailnet {
+		return nil, fmt.Errorf("node is not connected to tailnet %q", tailnet)
+	}
+
+	fields := map[string]any{"status": status.BackendState, "tailnet": status.CurrentTailnet.Name}
+	if status.Self != nil {
+		fields["id"] = status.Self.ID
+		fields["ips"] = status.Self.TailscaleIPs
+	}
+
+	logger.WithFields(fields).Info("successfully connected to tailscale")
+	return ts, nil
+}
@@ -0,0 +1,84 @@
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/netip"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/sirupsen/logrus"
+)
+
+// maxQueueDelay is the longest SQS allows a message to be delayed for
+const maxQueueDelay = 15 * time.Minute
+
+// QueueMessage is a single verification attempt for a flow sent through SQS
+type QueueMessage struct {
+	Request
+	// Attempt is how many attempts were made before this one
+	Attempt int `json:"attempt"`
+}
+
+// SQS launches verifiers by sending messages to a queue, retrying with a delay between attempts
+type SQS struct {
+	logger logrus.FieldLogger
+
+	queue  *string
+	client *sqs.Client
+}
+
+var _ Backend = (*SQS)(nil)
+
+// NewSQS creates a launcher backed by an AWS SQS queue
+func NewSQS(logger logrus.FieldLogger, config aws.Config, queueUrl string) (*SQS, error) {
+	client := sqs.NewFromConfig(config)
+
+	logger.WithField("queue", queueUrl).Debug("attempting to find queue...")
+	_, err := client.GetQueueAttributes(context.Background(), &sqs.GetQueueAttributesInput{
+		QueueUrl:       &queueUrl,
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.WithField("queue", queueUrl).Info("created new sqs-backed launcher")
+	return &SQS{logger, &queueUrl, client}, nil
+}
+
+func (q *SQS) Launch(ctx context.Context, id string, addresses []netip.AddrPort) error {
+	return q.Send(ctx, &QueueMessage{Request: Request{id, addresses}}, 0)
+}
+
+// Send queues a verification attempt, delaying its delivery. Delays are rounded up to the nearest second and capped
+// at the longest delay SQS supports.
+func (q *SQS) Send(ctx context.Context, message *QueueMessage, delay time.Duration) error {
+	logger := q.logger.WithFields(map[string]any{
+		"id":      message.ID,
+		"attempt": message.Attempt,
+		"delay":   delay,
+	})
+	logger.Debug("sending message...")
+
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	resp, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:     q.queue,
+		MessageBody:  aws.String(string(encoded)),
+		DelaySeconds: int32(math.Ceil(min(delay, maxQueueDelay).Seconds())),
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.WithField("message", aws.ToString(resp.MessageId)).Debug("message sent")
+	return nil
+}
@@ -39,7 +39,7 @@ func NewStepFunction(logger logrus.FieldLogger, config aws.Config, machine strin
 	return &stepFunction{logger, output.StateMachineArn, client}, nil
 }
 
-func (sf *stepFunction) Launch(id string, addresses []netip.AddrPort) error {
+func (sf *stepFunction) Launch(ctx context.Context, id string, addresses []netip.AddrPort) error {
 	sf.logger.WithField("id", id).Debug("launching state machine...")
 
 	encoded, err := json.Marshal(&Request{id, addresses})
@@ -47,7 +47,7 @@ func (sf *stepFunction) Launch(id string, addresses []netip.AddrPort) error {
 		return fmt.Errorf("failed to encode request: %w", err)
 	}
 
-	resp, err := sf.client.StartExecution(context.Background(), &sfn.StartExecutionInput{
+	resp, err := sf.client.StartExecution(ctx, &sfn.StartExecutionInput{
 		StateMachineArn: sf.machine,
 		Name:            &id,
 		Input:           aws.String(string(encoded)),
@@ -1,17 +1,34 @@
 package launcher
 
-import "net/netip"
+import (
+	"context"
+	"errors"
+	"net/netip"
+)
+
+// ErrUnavailable indicates the launcher cannot accept more flows right now, but may be able to later
+var ErrUnavailable = errors.New("launcher is unavailable")
 
 // Backend provides a way of launching one or more challenge verifiers
 type Backend interface {
-	// Launch spawns a new challenge verifier for the specified flow targeting the given addresses
-	Launch(id string, addresses []netip.AddrPort) error
+	// Launch spawns a new challenge verifier for the specified flow targeting the given addresses. Errors wrapping
+	// ErrUnavailable are temporary and the flow may be retried later.
+	Launch(ctx context.Context, id string, addresses []netip.AddrPort) error
 }
 
-// Request contains the information to start a new verification process for the local backend
+// Request contains the information to start a new verification process
 type Request struct {
 	// ID contains the flow identifier the request corresponds to
 	ID string `json:"id"`
 	// Addresses contains the IP address-port pairs to check
 	Addresses []netip.AddrPort `json:"addresses"`
 }
+
+// Synchronous is implemented by backends that finish verifying the flow before returning. The client must already be
+// serving its challenge when it starts the flow.
+type Synchronous interface {
+	Backend
+	// Verify attempts the flow's addresses, reporting whether they satisfy the verification policy. The flow is marked
+	// as failed if they do not.
+	Verify(ctx context.Context, id string, addresses []netip.AddrPort) (bool, error)
+}
@@ -0,0 +1,53 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// memory stores serialized data in memory, it is lost when the process exits
+type memory struct {
+	logger logrus.FieldLogger
+
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+var _ Backend = (*memory)(nil)
+
+// NewMemory creates a new in-memory storage
+func NewMemory(logger logrus.FieldLogger) Backend {
+	logger.Info("created new in-memory metadata storage")
+	return &memory{logger: logger, data: make(map[string][]byte)}
+}
+
+func (m *memory) Load(_ context.Context, key string, out any) error {
+	m.mu.RLock()
+	data, ok := m.data[key]
+	m.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("metadata %q does not exist", key)
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+func (m *memory) Save(_ context.Context, key string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	m.logger.WithField("key", key).Debug("saving metadata")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data[key] = encoded
+	return nil
+}
@@ -1,13 +1,15 @@
 package oidc
 
 import (
+	"encoding/json"
 	"fmt"
-	"reflect"
-	"strings"
+	"maps"
+	"slices"
 	"time"
 
 	"github.com/akrantz01/tailfed/internal/storage"
 	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/google/uuid"
 )
 
 // Claims contains the data that will be signed in the token
@@ -43,6 +45,7 @@ func NewClaimsFromFlow(issuer, audience string, validity time.Duration, flow *st
 	return Claims{
 		AuthenticatedMethodsReference: amr,
 		Claims: jwt.Claims{
+			ID:        uuid.Must(uuid.NewV7()).String(),
 			Issuer:    issuer,
 			Audience:  jwt.Audience{audience},
 			Subject:   fmt.Sprintf("tailnet:%s:dns:%s:host:%s:id:%s", flow.Tailnet, flow.DNSName, flow.MachineName, flow.Node),
@@ -61,22 +64,30 @@ func NewClaimsFromFlow(issuer, audience string, validity time.Duration, flow *st
 	}
 }
 
-func claimsKeys() []string {
-	t := reflect.TypeFor[Claims]()
-	return structKeys(t)
-}
+// supportedClaims determines the claims that are present in every issued token by building a claim set from a fully
+// populated flow
+func supportedClaims() []string {
+	claims := NewClaimsFromFlow("issuer", "audience", time.Hour, &storage.Flow{
+		Node:        "node",
+		Tailnet:     "tailnet",
+		DNSName:     "dns",
+		MachineName: "machine",
+		Hostname:    "host",
+		OS:          "os",
+		Tags:        []string{"tag:tag"},
+		Authorized:  true,
+		External:    true,
+	})
+
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		panic(fmt.Sprintf("claims must always be serializable: %v", err))
+	}
 
-func structKeys(t reflect.Type) []string {
-	var keys []string
-	for i := range t.NumField() {
-		f := t.Field(i)
-		if f.Anonymous {
-			keys = append(keys, structKeys(f.Type)...)
-		} else if value, ok := f.Tag.Lookup("json"); ok {
-			parts := strings.Split(value, ",")
-			keys = append(keys, parts[0])
-		}
+	var fields map[string]any
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		panic(fmt.Sprintf("claims must always be a JSON object: %v", err))
 	}
 
-	return keys
+	return slices.Sorted(maps.Keys(fields))
 }
@@ -0,0 +1,31 @@
+package oidc
+
+import "errors"
+
+// Config determines the issuer URL and which hostnames requests are accepted on, shared by every binary that serves
+// the API or its metadata
+type Config struct {
+	URL        string   `koanf:"url"`
+	Alternates []string `koanf:"alternates"`
+	Policy     string   `koanf:"policy"`
+	TrustProxy bool     `koanf:"trust-proxy"`
+}
+
+// Validate checks the issuer can be created, rejecting requests on unknown hostnames unless a policy is set
+func (c *Config) Validate() error {
+	if len(c.URL) == 0 {
+		return errors.New("missing issuer url")
+	}
+
+	if len(c.Policy) == 0 {
+		c.Policy = string(HostPolicyReject)
+	}
+
+	_, err := c.NewIssuer()
+	return err
+}
+
+// NewIssuer creates the canonical issuer
+func (c *Config) NewIssuer() (*Issuer, error) {
+	return NewIssuer(c.URL, c.Alternates, HostPolicy(c.Policy), c.TrustProxy)
+}
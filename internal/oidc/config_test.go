@@ -0,0 +1,32 @@
+package oidc
+
+import "testing"
+
+func TestConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		config Config
+		valid  bool
+		policy string
+	}{
+		"defaults to rejecting":  {Config{URL: "https://id.example.com"}, true, string(HostPolicyReject)},
+		"keeps the given policy": {Config{URL: "https://id.example.com", Policy: "log"}, true, string(HostPolicyLog)},
+		"missing url":            {Config{}, false, ""},
+		"insecure url":           {Config{URL: "http://id.example.com"}, false, string(HostPolicyReject)},
+		"unknown policy":         {Config{URL: "https://id.example.com", Policy: "allow"}, false, "allow"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.valid && err != nil {
+				t.Fatalf("expected config to be valid, got %v", err)
+			} else if !tt.valid && err == nil {
+				t.Fatal("expected config to be invalid")
+			}
+
+			if tt.config.Policy != tt.policy {
+				t.Fatalf("expected policy %q, got %q", tt.policy, tt.config.Policy)
+			}
+		})
+	}
+}
@@ -1,34 +1,183 @@
 package oidc
 
-import "github.com/go-jose/go-jose/v4"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+)
 
 var (
-	responseTypes     = []string{"id_token"}
-	subjectTypes      = []string{"public"}
-	signingAlgorithms = []jose.SignatureAlgorithm{
-		jose.RS256, jose.RS384, jose.RS512,
-		jose.ES256, jose.ES384, jose.ES256,
-	}
+	responseTypes = []string{"id_token"}
+	subjectTypes  = []string{"public"}
+
+	// requiredClaims must always be advertised as they are required by AWS to validate tokens
+	requiredClaims = []string{"iss", "sub", "aud", "exp", "iat"}
 )
 
 // DiscoveryDocument is an OpenID Connect discovery document for an AWS IAM identity to read
 type DiscoveryDocument struct {
-	Issuer            string                    `json:"issuer"`
-	JwksUri           string                    `json:"jwks_uri"`
-	Claims            []string                  `json:"claims_supported"`
-	ResponseTypes     []string                  `json:"response_types_supported"`
-	SigningAlgorithms []jose.SignatureAlgorithm `json:"id_token_signing_alg_values_supported"`
-	SubjectTypes      []string                  `json:"subject_types_supported"`
+	Issuer               string                    `json:"issuer"`
+	JwksUri              string                    `json:"jwks_uri"`
+	Claims               []string                  `json:"claims_supported"`
+	ResponseTypes        []string                  `json:"response_types_supported"`
+	SigningAlgorithms    []jose.SignatureAlgorithm `json:"id_token_signing_alg_values_supported"`
+	SubjectTypes         []string                  `json:"subject_types_supported"`
+	ServiceDocumentation string                    `json:"service_documentation,omitempty"`
+
+	// Extensions contains additional non-standard fields to include in the document
+	Extensions map[string]any `json:"-"`
+}
+
+// DiscoveryOption sets an optional field on the discovery document
+type DiscoveryOption func(doc *DiscoveryDocument)
+
+// WithServiceDocumentation links to human-readable documentation about the provider
+func WithServiceDocumentation(documentation string) DiscoveryOption {
+	return func(doc *DiscoveryDocument) {
+		doc.ServiceDocumentation = documentation
+	}
 }
 
-// NewDiscoveryDocument creates a new OpenID Connect discovery document from an issuer URL
-func NewDiscoveryDocument(issuer string) DiscoveryDocument {
-	return DiscoveryDocument{
+// WithExtensions includes additional non-standard fields in the document
+func WithExtensions(fields map[string]any) DiscoveryOption {
+	return func(doc *DiscoveryDocument) {
+		if doc.Extensions == nil {
+			doc.Extensions = make(map[string]any, len(fields))
+		}
+
+		maps.Copy(doc.Extensions, fields)
+	}
+}
+
+// NewDiscoveryDocument creates a new OpenID Connect discovery document from an issuer URL and the algorithms of the
+// currently active signing keys
+func NewDiscoveryDocument(issuer string, algorithms []jose.SignatureAlgorithm, opts ...DiscoveryOption) DiscoveryDocument {
+	doc := DiscoveryDocument{
 		Issuer:            issuer,
-		JwksUri:           issuer + "/.well-known/jwks.json",
-		Claims:            claimsKeys(),
+		JwksUri:           strings.TrimSuffix(issuer, "/") + "/.well-known/jwks.json",
+		Claims:            supportedClaims(),
 		ResponseTypes:     responseTypes,
-		SigningAlgorithms: signingAlgorithms,
+		SigningAlgorithms: algorithms,
 		SubjectTypes:      subjectTypes,
 	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&doc)
+		}
+	}
+
+	return doc
+}
+
+func (d DiscoveryDocument) MarshalJSON() ([]byte, error) {
+	type plain DiscoveryDocument
+	encoded, err := json.Marshal(plain(d))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(d.Extensions) == 0 {
+		return encoded, nil
+	}
+
+	fields := make(map[string]any, len(d.Extensions))
+	maps.Copy(fields, d.Extensions)
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(fields)
+}
+
+// Validate ensures the document conforms to the OpenID Connect Discovery 1.0 specification and is internally
+// consistent. The authorization endpoint is not checked as tokens are never issued through one.
+func (d DiscoveryDocument) Validate() error {
+	issuer, err := url.Parse(d.Issuer)
+	if err != nil {
+		return fmt.Errorf("issuer is not a valid url: %w", err)
+	}
+	if issuer.Scheme != "https" || len(issuer.Host) == 0 {
+		return errors.New("issuer must be an absolute https url")
+	}
+	if len(issuer.RawQuery) != 0 || len(issuer.Fragment) != 0 {
+		return errors.New("issuer must not contain a query or fragment")
+	}
+
+	jwksUri, err := url.Parse(d.JwksUri)
+	if err != nil {
+		return fmt.Errorf("jwks_uri is not a valid url: %w", err)
+	}
+	if jwksUri.Scheme != issuer.Scheme || jwksUri.Host != issuer.Host {
+		return fmt.Errorf("jwks_uri %q is not served by issuer %q", d.JwksUri, d.Issuer)
+	}
+	if !strings.HasPrefix(jwksUri.Path, strings.TrimSuffix(issuer.Path, "/")+"/") {
+		return fmt.Errorf("jwks_uri %q is outside of the issuer path %q", d.JwksUri, d.Issuer)
+	}
+
+	if len(d.ResponseTypes) == 0 {
+		return errors.New("response_types_supported must not be empty")
+	}
+
+	if len(d.SubjectTypes) == 0 {
+		return errors.New("subject_types_supported must not be empty")
+	}
+	for _, subjectType := range d.SubjectTypes {
+		if subjectType != "public" && subjectType != "pairwise" {
+			return fmt.Errorf("unknown subject type %q", subjectType)
+		}
+	}
+
+	if len(d.SigningAlgorithms) == 0 {
+		return errors.New("id_token_signing_alg_values_supported must not be empty")
+	}
+	if slices.Contains(d.SigningAlgorithms, "none") {
+		return errors.New("id_token_signing_alg_values_supported must not contain none")
+	}
+
+	for _, claim := range requiredClaims {
+		if !slices.Contains(d.Claims, claim) {
+			return fmt.Errorf("claims_supported is missing required claim %q", claim)
+		}
+	}
+
+	if len(d.ServiceDocumentation) != 0 {
+		if documentation, err := url.Parse(d.ServiceDocumentation); err != nil || !documentation.IsAbs() {
+			return errors.New("service_documentation must be an absolute url")
+		}
+	}
+
+	standard, err := d.standardFields()
+	if err != nil {
+		return err
+	}
+	for key := range d.Extensions {
+		if slices.Contains(standard, key) {
+			return fmt.Errorf("extension field %q conflicts with a standard field", key)
+		}
+	}
+
+	return nil
+}
+
+// standardFields lists the names of all the fields that are part of the specification
+func (d DiscoveryDocument) standardFields() ([]string, error) {
+	type plain DiscoveryDocument
+	encoded, err := json.Marshal(plain{})
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+
+	return append(slices.Collect(maps.Keys(fields)), "service_documentation"), nil
 }
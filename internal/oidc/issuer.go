@@ -0,0 +1,121 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HostPolicy determines what happens to requests that arrive on a hostname the issuer does not accept
+type HostPolicy string
+
+const (
+	// HostPolicyReject refuses to process requests on unknown hostnames
+	HostPolicyReject HostPolicy = "reject"
+	// HostPolicyLog processes requests on unknown hostnames, but logs a warning
+	HostPolicyLog HostPolicy = "log"
+)
+
+var ErrUnknownHostPolicy = errors.New("unknown host policy")
+
+// Issuer is the canonical identity of the OIDC provider along with the hostnames it can be reached through
+type Issuer struct {
+	url        string
+	hosts      []string
+	policy     HostPolicy
+	trustProxy bool
+}
+
+// NewIssuer creates a new canonical issuer. Requests are always accepted on the issuer's own hostname, in addition to
+// any alternates. The hostname forwarded by a proxy is only considered when trustProxy is set.
+func NewIssuer(canonical string, alternates []string, policy HostPolicy, trustProxy bool) (*Issuer, error) {
+	if policy != HostPolicyReject && policy != HostPolicyLog {
+		return nil, ErrUnknownHostPolicy
+	}
+
+	parsed, err := url.Parse(canonical)
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer url: %w", err)
+	}
+	if parsed.Scheme != "https" || len(parsed.Host) == 0 {
+		return nil, errors.New("issuer must be an absolute https url")
+	}
+	if len(parsed.RawQuery) != 0 || len(parsed.Fragment) != 0 {
+		return nil, errors.New("issuer must not contain a query or fragment")
+	}
+
+	hosts := make([]string, 0, len(alternates)+1)
+	hosts = append(hosts, normalizeHost(parsed.Host))
+	for _, alternate := range alternates {
+		if host := normalizeHost(alternate); len(host) != 0 {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return &Issuer{
+		url:        strings.TrimSuffix(parsed.String(), "/"),
+		hosts:      hosts,
+		policy:     policy,
+		trustProxy: trustProxy,
+	}, nil
+}
+
+// String returns the canonical issuer URL
+func (i *Issuer) String() string {
+	return i.url
+}
+
+// Hostname returns the canonical issuer's hostname without any port
+func (i *Issuer) Hostname() string {
+	if hostname, _, err := net.SplitHostPort(i.hosts[0]); err == nil {
+		return hostname
+	}
+
+	return i.hosts[0]
+}
+
+// TrustsProxy checks whether the hostname forwarded by a proxy should be used instead of the one the request arrived on
+func (i *Issuer) TrustsProxy() bool {
+	return i.trustProxy
+}
+
+// Accepts checks whether requests may arrive on the given hostname
+func (i *Issuer) Accepts(host string) bool {
+	host = normalizeHost(host)
+	if slices.Contains(i.hosts, host) {
+		return true
+	}
+
+	// Alternates without a port match any port
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return slices.Contains(i.hosts, hostname)
+	}
+
+	return false
+}
+
+// Allow determines whether a request on the given hostname should be processed according to the policy, logging any
+// unknown hostnames
+func (i *Issuer) Allow(logger logrus.FieldLogger, host string) bool {
+	if i.Accepts(host) {
+		return true
+	}
+
+	logger.
+		WithFields(map[string]any{
+			"host":   host,
+			"issuer": i.url,
+			"policy": i.policy,
+		}).
+		Warn("request received on unknown hostname")
+	return i.policy != HostPolicyReject
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
@@ -0,0 +1,56 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// SigningAlgorithms collects the distinct algorithms used by the keys in the set
+func SigningAlgorithms(keys []jose.JSONWebKey) []jose.SignatureAlgorithm {
+	var algorithms []jose.SignatureAlgorithm
+	for _, key := range keys {
+		algorithm := jose.SignatureAlgorithm(key.Algorithm)
+		if len(algorithm) != 0 && !slices.Contains(algorithms, algorithm) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+
+	return algorithms
+}
+
+// ValidateKeySet ensures every key in the set can be used to verify tokens
+func ValidateKeySet(set jose.JSONWebKeySet) error {
+	if len(set.Keys) == 0 {
+		return errors.New("key set must contain at least one key")
+	}
+
+	ids := make(map[string]struct{}, len(set.Keys))
+	for i, key := range set.Keys {
+		if !key.Valid() {
+			return fmt.Errorf("key %d is invalid", i)
+		}
+		if !key.IsPublic() {
+			return fmt.Errorf("key %q is not a public key", key.KeyID)
+		}
+
+		if len(key.KeyID) == 0 {
+			return fmt.Errorf("key %d is missing an id", i)
+		}
+		if _, ok := ids[key.KeyID]; ok {
+			return fmt.Errorf("duplicate key id %q", key.KeyID)
+		}
+		ids[key.KeyID] = struct{}{}
+
+		if len(key.Algorithm) == 0 {
+			return fmt.Errorf("key %q is missing an algorithm", key.KeyID)
+		}
+		if key.Use != "sig" {
+			return fmt.Errorf("key %q must be used for signatures", key.KeyID)
+		}
+	}
+
+	return nil
+}
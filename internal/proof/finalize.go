@@ -0,0 +1,43 @@
+package proof
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// MaxSkew is how far the proof's timestamp may drift from the server's clock
+const MaxSkew = 5 * time.Minute
+
+var (
+	ErrInvalid = errors.New("invalid proof")
+	ErrExpired = errors.New("proof timestamp outside of allowed skew")
+)
+
+// Finalize proves the caller knows the secret issued when the flow was started
+func Finalize(secret []byte, id string, timestamp time.Time) []byte {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(finalizeMessage(id, timestamp.Unix()))
+	return mac.Sum(nil)
+}
+
+// VerifyFinalize checks the proof was generated from the flow's secret within the allowed skew
+func VerifyFinalize(secret []byte, id string, timestamp int64, proof []byte, now time.Time) error {
+	if skew := now.Sub(time.Unix(timestamp, 0)).Abs(); skew > MaxSkew {
+		return ErrExpired
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(finalizeMessage(id, timestamp))
+	if !hmac.Equal(proof, mac.Sum(nil)) {
+		return ErrInvalid
+	}
+
+	return nil
+}
+
+func finalizeMessage(id string, timestamp int64) []byte {
+	return []byte("finalize|" + id + "|" + strconv.FormatInt(timestamp, 10))
+}
@@ -0,0 +1,85 @@
+package proof
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFinalizeDeterministic(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+
+	if !bytes.Equal(Finalize(secret, "flow", now), Finalize(secret, "flow", now)) {
+		t.Fatal("proofs for the same inputs differ")
+	}
+	if bytes.Equal(Finalize(secret, "flow", now), Finalize(secret, "other", now)) {
+		t.Fatal("proofs for different flows are equal")
+	}
+	if bytes.Equal(Finalize(secret, "flow", now), Finalize(secret, "flow", now.Add(time.Second))) {
+		t.Fatal("proofs for different timestamps are equal")
+	}
+}
+
+func TestVerifyFinalize(t *testing.T) {
+	secret := []byte("secret")
+	signedAt := time.Unix(1700000000, 0)
+	proof := Finalize(secret, "flow", signedAt)
+
+	tests := map[string]struct {
+		secret    []byte
+		id        string
+		timestamp int64
+		proof     []byte
+		now       time.Time
+		err       error
+	}{
+		"valid": {
+			secret: secret, id: "flow", timestamp: signedAt.Unix(), proof: proof, now: signedAt,
+		},
+		"valid at max future skew": {
+			secret: secret, id: "flow", timestamp: signedAt.Unix(), proof: proof, now: signedAt.Add(-MaxSkew),
+		},
+		"valid at max past skew": {
+			secret: secret, id: "flow", timestamp: signedAt.Unix(), proof: proof, now: signedAt.Add(MaxSkew),
+		},
+		"expired": {
+			secret: secret, id: "flow", timestamp: signedAt.Unix(), proof: proof, now: signedAt.Add(MaxSkew + time.Second),
+			err: ErrExpired,
+		},
+		"from the future": {
+			secret: secret, id: "flow", timestamp: signedAt.Unix(), proof: proof, now: signedAt.Add(-MaxSkew - time.Second),
+			err: ErrExpired,
+		},
+		"wrong secret": {
+			secret: []byte("other"), id: "flow", timestamp: signedAt.Unix(), proof: proof, now: signedAt,
+			err: ErrInvalid,
+		},
+		"wrong flow": {
+			secret: secret, id: "other", timestamp: signedAt.Unix(), proof: proof, now: signedAt,
+			err: ErrInvalid,
+		},
+		"wrong timestamp": {
+			secret: secret, id: "flow", timestamp: signedAt.Unix() + 1, proof: proof, now: signedAt,
+			err: ErrInvalid,
+		},
+		"truncated proof": {
+			secret: secret, id: "flow", timestamp: signedAt.Unix(), proof: proof[:16], now: signedAt,
+			err: ErrInvalid,
+		},
+		"empty proof": {
+			secret: secret, id: "flow", timestamp: signedAt.Unix(), now: signedAt,
+			err: ErrInvalid,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := VerifyFinalize(tt.secret, tt.id, tt.timestamp, tt.proof, tt.now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
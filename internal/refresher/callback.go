@@ -18,6 +18,7 @@ type challengeHandler struct {
 	logger    logrus.FieldLogger
 	refresher *Refresher
 
+	// id is empty when the challenge is served before the flow is started, in which case any path is accepted
 	id     string
 	path   string
 	secret []byte
@@ -55,18 +56,18 @@ func (r *Refresher) launchServer(id string, secret []byte, l net.Listener) *http
 }
 
 func (ch *challengeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != ch.path {
-		apiError(w, "not found", http.StatusNotFound)
+	if len(ch.id) != 0 && r.URL.Path != ch.path {
+		apiError(w, types.ErrorNotFound, "not found", http.StatusNotFound)
 		return
 	} else if r.Method != http.MethodGet {
-		apiError(w, "method not allowed", http.StatusMethodNotAllowed)
+		apiError(w, types.ErrorMethodNotAllowed, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	status, err := ch.refresher.ts.Status(r.Context())
 	if err != nil {
 		ch.logger.WithError(err).Error("failed to get node status")
-		apiError(w, "internal server error", http.StatusInternalServerError)
+		apiError(w, types.ErrorInternal, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
@@ -91,10 +92,11 @@ func (ch *challengeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}, 200)
 }
 
-func apiError(w http.ResponseWriter, message string, status int) {
+func apiError(w http.ResponseWriter, code types.ErrorCode, message string, status int) {
 	response(w, &types.Response[struct{}]{
 		Success: false,
 		Error:   message,
+		Code:    code,
 	}, status)
 }
 
@@ -0,0 +1,47 @@
+package refresher
+
+import (
+	"fmt"
+
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/sirupsen/logrus"
+)
+
+// logVerificationFailure explains why the server could not verify the challenge and what can be done about it
+func logVerificationFailure(logger logrus.FieldLogger, failure *types.VerificationFailure) {
+	var address string
+	for _, attempt := range failure.Attempts {
+		logger.WithFields(map[string]any{
+			"address": attempt.Address,
+			"reason":  attempt.Reason,
+			"detail":  attempt.Detail,
+		}).Debug("verification attempt failed")
+
+		if attempt.Reason == failure.Reason {
+			address = attempt.Address
+		}
+	}
+
+	logger.
+		WithField("reason", failure.Reason).
+		WithField("attempts", len(failure.Attempts)).
+		Error(describeFailure(failure.Reason, address))
+}
+
+// describeFailure produces an actionable description of a verification failure reason
+func describeFailure(reason, address string) string {
+	switch reason {
+	case "unreachable":
+		return fmt.Sprintf("verifier could not reach %s: check firewall on tailscale0", address)
+	case "timeout":
+		return fmt.Sprintf("verifier timed out connecting to %s: check firewall on tailscale0 and the tailnet ACLs", address)
+	case "invalid-response":
+		return fmt.Sprintf("verifier got an unexpected response from %s: check no other service is listening on the port", address)
+	case "rejected":
+		return fmt.Sprintf("challenge server at %s rejected the verifier: check the daemon logs for the cause", address)
+	case "signature-mismatch":
+		return fmt.Sprintf("challenge signature from %s was incorrect: check the node is in the tailnet the server is configured for", address)
+	default:
+		return "verifier could not verify the challenge: no attempts were recorded"
+	}
+}
@@ -5,34 +5,63 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/akrantz01/tailfed/internal/api"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/akrantz01/tailfed/internal/version"
 	"github.com/cenkalti/backoff/v5"
 )
 
-func (r *Refresher) complete(ctx context.Context, id string) {
+// finalizeWait is how long the server is asked to wait for the challenge to be verified per finalize attempt
+const finalizeWait = 15 * time.Second
+
+func (r *Refresher) complete(ctx context.Context, id string, secret []byte) {
 	logger := r.logger.WithField("flow", id)
 	defer r.stopServersFor(id)
 
+	// Finalizing is only attempted once the outcome is known, rather than proving ownership on every poll
+	if r.server.Supports(version.FeatureFlowStatus) {
+		if err := r.awaitVerification(ctx, id); err != nil {
+			logger.WithError(err).Warn("failed to wait for verification, finalizing anyway")
+		}
+	}
+
+	var wait time.Duration
+	if r.server.Supports(version.FeatureFinalizeWait) {
+		wait = finalizeWait
+	}
+
 	operation := func() (string, error) {
-		token, err := r.api.Finalize(ctx, id)
+		token, err := r.api.Finalize(ctx, id, secret, wait)
 		if err == nil {
 			return token, nil
 		}
 
 		var httpErr *api.Error
-		if errors.As(err, &httpErr) && httpErr.StatusCode() != http.StatusConflict {
-			err = backoff.Permanent(err)
+		if errors.As(err, &httpErr) {
+			switch {
+			case !httpErr.Retryable():
+				err = backoff.Permanent(err)
+			case httpErr.RetryAfter() > 0:
+				return "", &backoff.RetryAfterError{Duration: httpErr.RetryAfter()}
+			}
 		}
 		return "", err
 	}
 	notify := func(err error, next time.Duration) {
-		logger.WithField("next", next).WithError(err).Warn("finalization not yet complete")
+		entry := logger.WithField("next", next).WithError(err)
+
+		// Unverified challenges are expected while waiting on the verifier
+		var httpErr *api.Error
+		if errors.As(err, &httpErr) && httpErr.Code() == types.ErrorChallengePending {
+			entry.Debug("challenge not yet verified")
+		} else {
+			entry.Warn("finalization not yet complete")
+		}
 	}
 
 	expBackoff := backoff.NewExponentialBackOff()
@@ -44,6 +73,12 @@ func (r *Refresher) complete(ctx context.Context, id string) {
 		backoff.WithNotify(notify),
 	)
 	if err != nil {
+		var httpErr *api.Error
+		if errors.As(err, &httpErr) && httpErr.Verification() != nil {
+			logVerificationFailure(logger, httpErr.Verification())
+			return
+		}
+
 		logger.WithError(err).Error("failed to get authorization token")
 		return
 	}
@@ -60,6 +95,39 @@ func (r *Refresher) complete(ctx context.Context, id string) {
 	logger.Info("new token issued")
 }
 
+// errStillPending is returned while the flow's challenge has yet to be verified
+var errStillPending = errors.New("challenge not yet verified")
+
+// awaitVerification polls the flow's status until its challenge is no longer pending
+func (r *Refresher) awaitVerification(ctx context.Context, id string) error {
+	operation := func() (struct{}, error) {
+		status, err := r.api.FlowStatus(ctx, id, finalizeWait)
+		if err != nil {
+			var httpErr *api.Error
+			if errors.As(err, &httpErr) && !httpErr.Retryable() {
+				err = backoff.Permanent(err)
+			}
+			return struct{}{}, err
+		}
+
+		if status.Status == "pending" {
+			return struct{}{}, errStillPending
+		}
+
+		r.logger.WithFields(map[string]any{"flow": id, "status": status.Status}).Debug("challenge verification complete")
+		return struct{}{}, nil
+	}
+
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.InitialInterval = 1 * time.Second
+
+	_, err := backoff.Retry(ctx, operation,
+		backoff.WithBackOff(expBackoff),
+		backoff.WithMaxElapsedTime(3*time.Minute),
+	)
+	return err
+}
+
 func (r *Refresher) writeToken(token string) error {
 	baseDir := filepath.Dir(r.path)
 	if err := os.MkdirAll(baseDir, 0o755|os.ModeDir); err != nil {
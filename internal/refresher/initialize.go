@@ -2,6 +2,7 @@ package refresher
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"net"
@@ -9,8 +10,11 @@ import (
 	"net/netip"
 	"time"
 
+	"github.com/akrantz01/tailfed/internal/api"
 	"github.com/akrantz01/tailfed/internal/scheduler"
 	"github.com/akrantz01/tailfed/internal/tailscale"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/akrantz01/tailfed/internal/version"
 )
 
 // Job performs a single run of the refresh flow
@@ -33,33 +37,107 @@ func (r *Refresher) Job(ctx context.Context) error {
 		return fmt.Errorf("expected 2 tailnet ips, got %d", len(status.IPs))
 	}
 
-	listeners, addresses, err := r.bindListeners(status.IPs)
+	start, err := r.prepareStart(status.ID, status.IPs)
 	if err != nil {
 		return fmt.Errorf("failed to bind listeners: %w", err)
 	}
+	listeners := start.listeners
 
-	res, err := r.api.Start(ctx, status.ID, addresses)
+	res, err := r.api.Start(ctx, status.ID, start.addresses, start.idempotencyKey, start.secret)
 	if err != nil {
-		r.releaseListeners(listeners)
-		return scheduler.Retry(5*time.Second, err)
+		var httpErr *api.Error
+		isHttpErr := errors.As(err, &httpErr)
+
+		// The flow may have been started if the server never responded or was still starting it, so keep the
+		// listeners and key to pick it back up on retry
+		if !isHttpErr || httpErr.Code() == types.ErrorIdempotencyInProgress || httpErr.Code() == types.ErrorInternal {
+			r.starting = start
+		} else {
+			r.abandonStart(start)
+		}
+
+		// Requests the server will never accept, like for a missing node or a denied policy, wait for the next
+		// scheduled refresh rather than hammering the server
+		if isHttpErr && !httpErr.Retryable() {
+			return fmt.Errorf("server rejected start (code: %s): %w", httpErr.Code(), err)
+		}
+
+		after := 5 * time.Second
+		if isHttpErr && httpErr.RetryAfter() > 0 {
+			after = httpErr.RetryAfter()
+		}
+		return scheduler.Retry(after, err)
 	}
 
-	servers := make([]*http.Server, 0, len(listeners))
-	for _, lis := range listeners {
-		servers = append(servers, r.launchServer(res.ID, res.SigningSecret, lis))
+	servers := start.servers
+	if servers == nil {
+		servers = make([]*http.Server, 0, len(listeners))
+		for _, lis := range listeners {
+			servers = append(servers, r.launchServer(res.ID, res.SigningSecret, lis))
+		}
 	}
 
-	r.logger.WithField("flow", res.ID).Debug("new flow successfully started")
+	r.logger.WithFields(map[string]any{"flow": res.ID, "status": res.Status}).Debug("new flow successfully started")
 	r.inFlight[res.ID] = inFlight{
 		listeners: listeners,
 		servers:   servers,
 	}
 
-	go r.complete(ctx, res.ID)
+	go r.complete(ctx, res.ID, res.SigningSecret)
 
 	return nil
 }
 
+// prepareStart resumes the previous start attempt if it may have reached the server, otherwise it binds new listeners
+// and generates a new idempotency key
+func (r *Refresher) prepareStart(node string, ips []netip.Addr) (*starting, error) {
+	if previous := r.starting; previous != nil {
+		r.starting = nil
+
+		if previous.node == node {
+			r.logger.Debug("retrying previous start attempt")
+			return previous, nil
+		}
+
+		r.logger.Debug("node changed since previous start attempt, discarding")
+		r.abandonStart(previous)
+	}
+
+	listeners, addresses, err := r.bindListeners(ips)
+	if err != nil {
+		return nil, err
+	}
+
+	start := &starting{
+		node:           node,
+		idempotencyKey: rand.Text(),
+		listeners:      listeners,
+		addresses:      addresses,
+	}
+
+	// The server may verify the challenge before responding, so it must be served before the flow is started
+	if r.server.Supports(version.FeatureClientSecret) {
+		start.secret = make([]byte, 64)
+		_, _ = rand.Read(start.secret)
+
+		for _, lis := range listeners {
+			start.servers = append(start.servers, r.launchServer("", start.secret, lis))
+		}
+	}
+
+	return start, nil
+}
+
+// abandonStart stops serving the challenge for a start attempt that will not be retried
+func (r *Refresher) abandonStart(start *starting) {
+	if len(start.servers) != 0 {
+		// Shutting down the servers also closes their listeners
+		r.stopServers(start.servers)
+	} else {
+		r.releaseListeners(start.listeners)
+	}
+}
+
 func (r *Refresher) bindListeners(ips []netip.Addr) ([]net.Listener, []string, error) {
 	listeners := make([]net.Listener, 0, len(ips))
 	addresses := make([]string, 0, len(ips))
@@ -8,18 +8,34 @@ import (
 
 	"github.com/akrantz01/tailfed/internal/api"
 	"github.com/akrantz01/tailfed/internal/tailscale"
+	"github.com/akrantz01/tailfed/internal/version"
 	"github.com/sirupsen/logrus"
 )
 
 // Refresher holds the state for periodically refreshing the identity token
 type Refresher struct {
 	api    *api.Client
+	server *version.Info
 	ts     *tailscale.Local
 	logger logrus.FieldLogger
 
 	path string
 
 	inFlight map[string]inFlight
+	// starting holds the state of a start request that may have reached the server, so it can be safely retried
+	starting *starting
+}
+
+type starting struct {
+	node           string
+	idempotencyKey string
+	listeners      []net.Listener
+	addresses      []string
+
+	// secret and servers are only set when the server accepts client-supplied secrets, in which case the challenge
+	// is served before the flow is started
+	secret  []byte
+	servers []*http.Server
 }
 
 type inFlight struct {
@@ -27,10 +43,11 @@ type inFlight struct {
 	servers   []*http.Server
 }
 
-// New creates a new Refresher
-func New(api *api.Client, ts *tailscale.Local, path string) *Refresher {
+// New creates a new Refresher for a server with the given version information
+func New(api *api.Client, server *version.Info, ts *tailscale.Local, path string) *Refresher {
 	return &Refresher{
 		api:    api,
+		server: server,
 		ts:     ts,
 		logger: logrus.WithField("component", "refresher"),
 
@@ -59,5 +76,10 @@ func (r *Refresher) ShutdownInFlight() {
 		r.stopServers(flow.servers)
 	}
 
+	if r.starting != nil {
+		r.abandonStart(r.starting)
+		r.starting = nil
+	}
+
 	r.logger.Debug("successfully shutdown in-flight requests")
 }
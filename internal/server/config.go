@@ -0,0 +1,554 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/audit"
+	"github.com/akrantz01/tailfed/internal/database"
+	"github.com/akrantz01/tailfed/internal/initializer"
+	"github.com/akrantz01/tailfed/internal/launcher/inline"
+	"github.com/akrantz01/tailfed/internal/launcher/local"
+	"github.com/akrantz01/tailfed/internal/metadata"
+	"github.com/akrantz01/tailfed/internal/oidc"
+	"github.com/akrantz01/tailfed/internal/signing"
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/sweeper"
+	"github.com/akrantz01/tailfed/internal/tailscale"
+	"github.com/akrantz01/tailfed/internal/verifier"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/sirupsen/logrus"
+)
+
+// AuditConfig selects where issued tokens are recorded
+type AuditConfig struct {
+	Backend string `koanf:"backend"`
+	Path    string `koanf:"path"`
+	Table   string `koanf:"table"`
+}
+
+func (a *AuditConfig) Validate() error {
+	if a.Backend == "filesystem" && len(a.Path) == 0 {
+		return errors.New("missing path for filesystem backend")
+	}
+
+	if a.Backend == "dynamo" && len(a.Table) == 0 {
+		return errors.New("missing table for dynamo backend")
+	}
+
+	return nil
+}
+
+func (a *AuditConfig) NewBackend(config aws.Config, db *database.DB) (audit.Backend, error) {
+	logger := logrus.WithFields(map[string]any{
+		"component": "audit",
+		"backend":   a.Backend,
+	})
+	switch a.Backend {
+	case "memory":
+		return audit.NewInMemory(logger), nil
+	case "filesystem":
+		return audit.NewFilesystem(logger, a.Path)
+	case "dynamo":
+		return audit.NewDynamo(logger, config, a.Table)
+	case "sql":
+		return audit.NewSQL(logger, db)
+	default:
+		return nil, errors.New("unknown audit backend")
+	}
+}
+
+// DatabaseConfig connects to the SQL database shared by the sql backends
+type DatabaseConfig struct {
+	Driver string `koanf:"driver"`
+	DSN    string `koanf:"dsn"`
+}
+
+func (d *DatabaseConfig) Validate() error {
+	if d.Driver != string(database.DialectSQLite) && d.Driver != string(database.DialectPostgres) {
+		return errors.New("unknown database driver")
+	}
+
+	if len(d.DSN) == 0 {
+		return errors.New("missing data source name")
+	}
+
+	return nil
+}
+
+func (d *DatabaseConfig) Open(ctx context.Context) (*database.DB, error) {
+	logger := logrus.WithField("component", "database")
+	return database.Open(ctx, logger, database.Dialect(d.Driver), d.DSN)
+}
+
+// IssuerConfig determines the issuer URL and which hostnames requests are accepted on
+type IssuerConfig = oidc.Config
+
+// LimitsConfig bounds how many flows can be started
+type LimitsConfig struct {
+	Node    RateConfig `koanf:"node"`
+	Source  RateConfig `koanf:"source"`
+	Pending int        `koanf:"pending"`
+}
+
+func (l *LimitsConfig) Validate() error {
+	if err := l.Node.Validate(); err != nil {
+		return fmt.Errorf("invalid node rate: %w", err)
+	}
+
+	if err := l.Source.Validate(); err != nil {
+		return fmt.Errorf("invalid source rate: %w", err)
+	}
+
+	if l.Pending < 0 {
+		return errors.New("pending flow cap cannot be negative")
+	}
+
+	return nil
+}
+
+// Limits converts the configuration into initializer limits
+func (l *LimitsConfig) Limits() initializer.Limits {
+	return initializer.Limits{
+		Node:    initializer.Rate{Requests: l.Node.Requests, Window: l.Node.Window},
+		Source:  initializer.Rate{Requests: l.Source.Requests, Window: l.Source.Window},
+		Pending: l.Pending,
+	}
+}
+
+// RateConfig limits requests within a sliding window
+type RateConfig struct {
+	Requests int           `koanf:"requests"`
+	Window   time.Duration `koanf:"window"`
+}
+
+func (r *RateConfig) Validate() error {
+	if r.Requests < 0 {
+		return errors.New("requests cannot be negative")
+	}
+
+	if r.Requests > 0 && r.Window <= 0 {
+		return errors.New("window must be positive")
+	}
+
+	return nil
+}
+
+// MetadataConfig selects where OpenID Connect metadata is stored
+type MetadataConfig struct {
+	Backend string `koanf:"backend"`
+	Bucket  string `koanf:"bucket"`
+	Path    string `koanf:"path"`
+
+	ServiceDocumentation string         `koanf:"service-documentation"`
+	Extensions           map[string]any `koanf:"extensions"`
+}
+
+func (m *MetadataConfig) Validate() error {
+	if m.Backend == "filesystem" && len(m.Path) == 0 {
+		return errors.New("missing path for filesystem backend")
+	}
+
+	if m.Backend == "s3" && len(m.Bucket) == 0 {
+		return errors.New("missing bucket for s3 backend")
+	}
+
+	return nil
+}
+
+func (m *MetadataConfig) NewBackend(config aws.Config) (metadata.Backend, error) {
+	logger := logrus.WithFields(map[string]any{
+		"component": "metadata",
+		"backend":   m.Backend,
+	})
+	switch m.Backend {
+	case "filesystem":
+		return metadata.NewFilesystem(logger, m.Path)
+	case "s3":
+		return metadata.NewS3(logger, config, m.Bucket)
+	default:
+		return nil, errors.New("unknown metadata backend")
+	}
+}
+
+// DiscoveryOptions builds the optional fields to include in the discovery document
+func (m *MetadataConfig) DiscoveryOptions() []oidc.DiscoveryOption {
+	return []oidc.DiscoveryOption{
+		oidc.WithServiceDocumentation(m.ServiceDocumentation),
+		oidc.WithExtensions(m.Extensions),
+	}
+}
+
+// SigningConfig selects how tokens are signed
+type SigningConfig struct {
+	Backend    string        `koanf:"backend"`
+	Validity   time.Duration `koanf:"validity"`
+	Key        string        `koanf:"key"`
+	Path       string        `koanf:"path"`
+	PrivateKey string        `koanf:"private-key"`
+	Audience   string        `koanf:"audience"`
+
+	Vault SigningVaultConfig `koanf:"vault"`
+}
+
+func (s *SigningConfig) Validate() error {
+	if len(s.Audience) == 0 {
+		return errors.New("token audience cannot be empty")
+	}
+
+	if s.Validity <= 0 {
+		return errors.New("token validity must be positive")
+	}
+
+	if s.Backend == "kms" && len(s.Key) == 0 {
+		return errors.New("missing key for kms backend")
+	}
+
+	if s.Backend == "static" && (len(s.Path) == 0) == (len(s.PrivateKey) == 0) {
+		return errors.New("exactly one of path or private key must be set for static backend")
+	}
+
+	if s.Backend == "vault" {
+		if err := s.Vault.Validate(); err != nil {
+			return fmt.Errorf("invalid vault config: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SigningConfig) NewBackend(config aws.Config) (signing.Backend, error) {
+	logger := logrus.WithFields(map[string]any{
+		"component": "signer",
+		"backend":   s.Backend,
+	})
+	switch s.Backend {
+	case "memory":
+		return signing.NewInMemory(logger)
+	case "kms":
+		return signing.NewKMS(logger, config, s.Key)
+	case "static":
+		if len(s.Path) != 0 {
+			return signing.NewStaticFromFile(logger, s.Path)
+		}
+
+		return signing.NewStatic(logger, []byte(s.PrivateKey))
+	case "vault":
+		return signing.NewVault(logger, signing.VaultConfig{
+			Address: s.Vault.Address,
+			Mount:   s.Vault.Mount,
+			Key:     s.Vault.Key,
+			Auth:    s.Vault.Authentication(),
+		})
+	default:
+		return nil, errors.New("unknown signing backend")
+	}
+}
+
+// SigningVaultConfig connects to a Vault transit secrets engine
+type SigningVaultConfig struct {
+	Address string `koanf:"address"`
+	Mount   string `koanf:"mount"`
+	Key     string `koanf:"key"`
+
+	Token   string                    `koanf:"token"`
+	AppRole SigningVaultAppRoleConfig `koanf:"approle"`
+	JWT     SigningVaultJWTConfig     `koanf:"jwt"`
+}
+
+func (v *SigningVaultConfig) Validate() error {
+	if len(v.Key) == 0 {
+		return errors.New("missing transit key name")
+	}
+
+	enabled := 0
+	for _, method := range []bool{len(v.Token) != 0, v.AppRole.Enabled(), v.JWT.Enabled()} {
+		if method {
+			enabled++
+		}
+	}
+	if enabled != 1 {
+		return errors.New("exactly one vault authentication method must be enabled")
+	}
+
+	return nil
+}
+
+func (v *SigningVaultConfig) Authentication() signing.VaultAuthentication {
+	switch {
+	case v.AppRole.Enabled():
+		return signing.VaultAppRole(v.AppRole.Mount, v.AppRole.RoleId, v.AppRole.SecretId)
+	case v.JWT.Enabled():
+		return signing.VaultJWT(v.JWT.Mount, v.JWT.Role, v.JWT.Token)
+	default:
+		return signing.VaultToken(v.Token)
+	}
+}
+
+// SigningVaultAppRoleConfig authenticates to Vault using AppRole
+type SigningVaultAppRoleConfig struct {
+	Mount    string `koanf:"mount"`
+	RoleId   string `koanf:"role-id"`
+	SecretId string `koanf:"secret-id"`
+}
+
+func (a *SigningVaultAppRoleConfig) Enabled() bool {
+	return len(a.RoleId) != 0 && len(a.SecretId) != 0
+}
+
+// SigningVaultJWTConfig authenticates to Vault using a JWT
+type SigningVaultJWTConfig struct {
+	Mount string `koanf:"mount"`
+	Role  string `koanf:"role"`
+	Token string `koanf:"token"`
+}
+
+func (j *SigningVaultJWTConfig) Enabled() bool {
+	return len(j.Role) != 0 && len(j.Token) != 0
+}
+
+// StorageConfig selects where in-flight flows are stored
+type StorageConfig struct {
+	Backend string `koanf:"backend"`
+	Path    string `koanf:"path"`
+	Table   string `koanf:"table"`
+
+	Encryption StorageEncryptionConfig `koanf:"encryption"`
+}
+
+func (s *StorageConfig) Validate() error {
+	if err := s.Encryption.Validate(); err != nil {
+		return fmt.Errorf("invalid encryption: %w", err)
+	}
+
+	if s.Backend == "filesystem" && len(s.Path) == 0 {
+		return errors.New("missing path for filesystem backend")
+	}
+
+	if s.Backend == "dynamo" && len(s.Table) == 0 {
+		return errors.New("missing table for dynamo backend")
+	}
+
+	return nil
+}
+
+func (s *StorageConfig) NewBackend(config aws.Config, db *database.DB) (storage.Backend, error) {
+	logger := logrus.WithFields(map[string]any{
+		"component": "storage",
+		"backend":   s.Backend,
+	})
+
+	var (
+		store storage.Backend
+		err   error
+	)
+	switch s.Backend {
+	case "filesystem":
+		store, err = storage.NewFilesystem(logger, s.Path)
+	case "dynamo":
+		store, err = storage.NewDynamo(logger, config, s.Table)
+	case "sql":
+		store, err = storage.NewSQL(logger, db)
+	default:
+		return nil, errors.New("unknown storage backend")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Encryption.Wrap(config, store)
+}
+
+// StorageEncryptionConfig optionally encrypts flows at rest
+type StorageEncryptionConfig struct {
+	KMSKey         string `koanf:"kms-key"`
+	KeyFile        string `koanf:"key-file"`
+	Identity       bool   `koanf:"identity"`
+	AllowPlaintext bool   `koanf:"allow-plaintext"`
+}
+
+func (s *StorageEncryptionConfig) Validate() error {
+	if len(s.KMSKey) != 0 && len(s.KeyFile) != 0 {
+		return errors.New("only one of kms-key or key-file may be set")
+	}
+
+	return nil
+}
+
+// Wrap encrypts flows in the store if a key is configured
+func (s *StorageEncryptionConfig) Wrap(config aws.Config, store storage.Backend) (storage.Backend, error) {
+	logger := logrus.WithField("component", "storage.keys")
+
+	var wrapper storage.KeyWrapper
+	switch {
+	case len(s.KMSKey) != 0:
+		wrapper = storage.NewKMSKeyWrapper(logger, config, s.KMSKey)
+	case len(s.KeyFile) != 0:
+		var err error
+		if wrapper, err = storage.NewLocalKeyWrapperFromFile(logger, s.KeyFile); err != nil {
+			return nil, err
+		}
+	default:
+		return store, nil
+	}
+
+	return storage.NewEncrypted(logrus.WithField("component", "storage"), store, wrapper, s.Identity, s.AllowPlaintext), nil
+}
+
+// SweeperConfig controls how expired flows are removed from storage
+type SweeperConfig struct {
+	Interval  time.Duration `koanf:"interval"`
+	BatchSize int           `koanf:"batch-size"`
+}
+
+func (s *SweeperConfig) Validate() error {
+	if s.Interval < 0 {
+		return errors.New("interval cannot be negative")
+	}
+
+	if s.BatchSize <= 0 {
+		return errors.New("batch size must be positive")
+	}
+
+	return nil
+}
+
+// Enabled checks whether expired flows should be periodically removed
+func (s *SweeperConfig) Enabled() bool {
+	return s.Interval > 0
+}
+
+func (s *SweeperConfig) NewSweeper(store storage.Backend, db *database.DB) *sweeper.Sweeper {
+	opts := []sweeper.Option{sweeper.WithBatchSize(s.BatchSize)}
+	if db != nil {
+		opts = append(opts, sweeper.WithPruner(db))
+	}
+	if pruner, ok := store.(sweeper.Pruner); ok {
+		opts = append(opts, sweeper.WithPruner(pruner))
+	}
+
+	return sweeper.New(logrus.WithField("component", "sweeper"), store, opts...)
+}
+
+// TailscaleConfig connects to the control plane API
+type TailscaleConfig struct {
+	Backend string `koanf:"backend"`
+	BaseUrl string `koanf:"base-url"`
+
+	Tailnet string `koanf:"tailnet"`
+
+	ApiKey string               `koanf:"api-key"`
+	OAuth  TailscaleOAuthConfig `koanf:"oauth"`
+
+	TLSMode tailscale.TLSMode `koanf:"tls-mode"`
+}
+
+func (t *TailscaleConfig) Validate() error {
+	if len(t.BaseUrl) == 0 {
+		return errors.New("a base url must be configured")
+	}
+
+	if len(t.Tailnet) == 0 {
+		return errors.New("a tailnet must be configured")
+	}
+
+	if (len(t.ApiKey) > 0) == t.OAuth.Enabled() {
+		return errors.New("exactly one tailscale authentication method must be enabled")
+	}
+
+	if t.Backend == "headscale" && t.OAuth.Enabled() {
+		return errors.New("oauth-based authentication not supported by headscale")
+	}
+
+	return nil
+}
+
+func (t *TailscaleConfig) NewClient() (tailscale.ControlPlane, error) {
+	logger := logrus.WithField("component", "tailscale")
+	return tailscale.NewControlPlane(logger, t.Backend, t.BaseUrl, t.Tailnet, t.Authentication(), t.TLSMode)
+}
+
+func (t *TailscaleConfig) Authentication() tailscale.Authentication {
+	if len(t.ApiKey) > 0 {
+		return tailscale.ApiKey(t.ApiKey)
+	}
+
+	return tailscale.OAuth(t.OAuth.ClientId, t.OAuth.ClientSecret)
+}
+
+// TailscaleOAuthConfig authenticates to the control plane API using an OAuth client
+type TailscaleOAuthConfig struct {
+	ClientId     string `koanf:"client-id"`
+	ClientSecret string `koanf:"client-secret"`
+}
+
+func (o *TailscaleOAuthConfig) Enabled() bool {
+	return len(o.ClientId) != 0 && len(o.ClientSecret) != 0
+}
+
+// VerifierConfig controls how challenges are verified in-process
+type VerifierConfig struct {
+	Policy      verifier.Policy `koanf:"policy"`
+	Timeout     time.Duration   `koanf:"timeout"`
+	Workers     int             `koanf:"workers"`
+	QueueSize   int             `koanf:"queue-size"`
+	MaxAttempts int             `koanf:"max-attempts"`
+	Backoff     time.Duration   `koanf:"backoff"`
+	MaxBackoff  time.Duration   `koanf:"max-backoff"`
+}
+
+func (v *VerifierConfig) Validate() error {
+	if err := v.Policy.Validate(); err != nil {
+		return err
+	}
+
+	if v.Timeout <= 0 {
+		return errors.New("timeout must be positive")
+	}
+
+	if v.Workers <= 0 {
+		return errors.New("workers must be positive")
+	}
+
+	if v.QueueSize <= 0 {
+		return errors.New("queue size must be positive")
+	}
+
+	if v.MaxAttempts <= 0 {
+		return errors.New("max attempts must be positive")
+	}
+
+	if v.Backoff <= 0 {
+		return errors.New("backoff must be positive")
+	}
+
+	if v.MaxBackoff < v.Backoff {
+		return errors.New("max backoff cannot be less than backoff")
+	}
+
+	return nil
+}
+
+// NewInlineLauncher creates a launcher verifying challenges before the start request responds. The client's timeout
+// is overridden with the configured timeout.
+func (v *VerifierConfig) NewInlineLauncher(client *http.Client, store storage.Backend, tailnet string) *inline.Launcher {
+	client.Timeout = v.Timeout
+	return inline.New(logrus.WithField("component", "launcher"), verifier.New(client, store, tailnet, v.Policy), store)
+}
+
+// NewLauncher creates a local launcher verifying challenges with the HTTP client. The client's timeout is
+// overridden with the configured timeout.
+func (v *VerifierConfig) NewLauncher(client *http.Client, store storage.Backend, tailnet string) *local.Launcher {
+	client.Timeout = v.Timeout
+
+	return local.New(logrus.WithField("component", "launcher"), verifier.New(client, store, tailnet, v.Policy), store, local.Config{
+		Workers:     v.Workers,
+		QueueSize:   v.QueueSize,
+		MaxAttempts: v.MaxAttempts,
+		Backoff:     v.Backoff,
+		MaxBackoff:  v.MaxBackoff,
+	})
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/audit"
+	"github.com/akrantz01/tailfed/internal/finalizer"
+	"github.com/akrantz01/tailfed/internal/http/gateway"
+	"github.com/akrantz01/tailfed/internal/initializer"
+	"github.com/akrantz01/tailfed/internal/launcher"
+	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/metadata"
+	"github.com/akrantz01/tailfed/internal/oidc"
+	"github.com/akrantz01/tailfed/internal/signing"
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/tailscale"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/akrantz01/tailfed/internal/version"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Backends are the dependencies shared by the API handlers
+type Backends struct {
+	Issuer    *oidc.Issuer
+	Limits    initializer.Limits
+	Tailscale tailscale.ControlPlane
+	Launcher  launcher.Backend
+	Signer    signing.Backend
+	Store     storage.Backend
+	Audit     audit.Backend
+
+	// Audience is who the issued tokens are intended for
+	Audience string
+	// Validity is how long the issued tokens are valid for
+	Validity time.Duration
+
+	// Payload is the event format the handlers receive, allowing each trigger type to be exercised locally
+	Payload gateway.PayloadFormat
+}
+
+// RegisterAPI adds the endpoints used by clients to authenticate
+func RegisterAPI(mux *http.ServeMux, b *Backends) {
+	status := gateway.WithPathParameters("/flows/{id}", finalizer.NewStatus(b.Issuer, b.Store))
+
+	mux.Handle("POST /start", PayloadHandler(b.Payload, initializer.New(b.Issuer, b.Limits, b.Tailscale, b.Launcher, b.Store)))
+	mux.Handle("POST /finalize", PayloadHandler(b.Payload, finalizer.New(b.Issuer, b.Audience, b.Validity, b.Signer, b.Store, b.Audit)))
+	mux.Handle("GET /flows/{id}", PayloadHandler(b.Payload, status))
+}
+
+// RegisterMetadata adds the endpoints serving the documents produced by the generator
+func RegisterMetadata(mux *http.ServeMux, meta metadata.Backend) {
+	mux.Handle("GET /version.json", MetadataHandler[version.Info]("version.json", meta))
+	mux.Handle("GET /config.json", MetadataHandler[types.ConfigResponse]("config.json", meta))
+
+	mux.Handle("GET /.well-known/openid-configuration", MetadataHandler[any]("openid-configuration", meta))
+	mux.Handle("GET /.well-known/jwks.json", MetadataHandler[jose.JSONWebKeySet]("jwks.json", meta))
+}
+
+// New creates a new HTTP server with a base handler and a sequence of middleware. Middleware are applied such
+// that the first passed is the first to execute and last to return.
+func New(address string, handler http.Handler, middleware ...func(http.Handler) http.Handler) *http.Server {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// LambdaHandler acts as an adapter between the AWS lambda handler functions and HTTP handler functions
+func LambdaHandler(next gateway.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
+
+		req, err := gateway.FromHttpRequest(r)
+		if err != nil {
+			logger.WithError(err).Error("failed to convert to gateway request")
+			internalServerError(w)
+			return
+		}
+
+		// Leave enough time for handlers to wait on the verifier
+		ctx, cancel := context.WithTimeout(r.Context(), finalizer.MaxWait+5*time.Second)
+		defer cancel()
+
+		response, err := next.Serve(ctx, req)
+		if err != nil {
+			logger.WithError(err).Error("lambda handler failed")
+			internalServerError(w)
+			return
+		}
+
+		if err := gateway.WriteHttpResponse(w, response); err != nil {
+			logger.WithError(err).Error("failed to write lambda response")
+		}
+	})
+}
+
+// PayloadHandler adapts a lambda handler function to HTTP handler functions, converting requests through the payload
+// format. Function URL requests have their routing information removed, as it would be in AWS. Load balancer
+// payloads are not supported, falling back to the REST API payload.
+func PayloadHandler(format gateway.PayloadFormat, next gateway.Handler) http.Handler {
+	if format != gateway.PayloadHTTP && format != gateway.PayloadFunctionURL {
+		return LambdaHandler(next)
+	}
+
+	adapted := gateway.HTTPAPI(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
+
+		req, err := gateway.FromHttpRequestV2(r)
+		if err != nil {
+			logger.WithError(err).Error("failed to convert to gateway request")
+			internalServerError(w)
+			return
+		}
+
+		if format == gateway.PayloadFunctionURL {
+			req.RouteKey = "$default"
+			req.RequestContext.RouteKey = "$default"
+			req.PathParameters = nil
+		}
+
+		// Leave enough time for handlers to wait on the verifier
+		ctx, cancel := context.WithTimeout(r.Context(), finalizer.MaxWait+5*time.Second)
+		defer cancel()
+
+		response, err := adapted(ctx, req)
+		if err != nil {
+			logger.WithError(err).Error("lambda handler failed")
+			internalServerError(w)
+			return
+		}
+
+		if err := gateway.WriteHttpResponseV2(w, response); err != nil {
+			logger.WithError(err).Error("failed to write lambda response")
+		}
+	})
+}
+
+// MetadataHandler serves static keys from the metadata backend
+func MetadataHandler[T any](key string, meta metadata.Backend) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.FromContext(ctx)
+
+		var data T
+		if err := meta.Load(ctx, key, &data); err != nil {
+			logger.WithError(err).Error("could not load from backend")
+			internalServerError(w)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			logger.WithError(err).Error("failed to write metadata response")
+		}
+	})
+}
+
+// Health reports that the server is alive
+func Health(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func internalServerError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+
+	resp := types.Response[struct{}]{
+		Success: false,
+		Error:   "internal server error",
+		Code:    types.ErrorInternal,
+	}
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		logrus.WithError(err).Error("failed to write internal server error response")
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/logging"
+)
+
+// readinessTimeout bounds how long all readiness checks can take
+const readinessTimeout = 5 * time.Second
+
+// Check determines whether a dependency is able to serve requests
+type Check func(ctx context.Context) error
+
+// Readiness reports whether the server and its dependencies are able to serve requests
+type Readiness struct {
+	ready atomic.Bool
+
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+var _ http.Handler = (*Readiness)(nil)
+
+// NewReadiness creates a readiness handler that is not ready until SetReady is called
+func NewReadiness() *Readiness {
+	return &Readiness{checks: make(map[string]Check)}
+}
+
+// Add registers a named dependency check
+func (r *Readiness) Add(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checks[name] = check
+}
+
+// SetReady marks whether the server is accepting requests, regardless of its dependencies
+func (r *Readiness) SetReady(ready bool) {
+	r.ready.Store(ready)
+}
+
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), readinessTimeout)
+	defer cancel()
+
+	status := http.StatusOK
+	results := make(map[string]string)
+
+	if !r.ready.Load() {
+		status = http.StatusServiceUnavailable
+		results["server"] = "not accepting requests"
+	}
+
+	r.mu.RLock()
+	for name, check := range r.checks {
+		if err := check(ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			results[name] = err.Error()
+		} else {
+			results[name] = "ok"
+		}
+	}
+	r.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		logging.FromContext(ctx).WithError(err).Error("failed to write readiness response")
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoCertificate is returned when a certificate has not been loaded
+var ErrNoCertificate = errors.New("no certificate loaded")
+
+// StaticCertificate serves a certificate and key from disk, reloading them whenever either file changes
+type StaticCertificate struct {
+	logger   logrus.FieldLogger
+	certFile string
+	keyFile  string
+	hostname string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// modified is when the files had last changed as of the most recent load attempt
+	modified time.Time
+}
+
+// NewStaticCertificate loads a certificate and key, ensuring the certificate is valid for the hostname
+func NewStaticCertificate(logger logrus.FieldLogger, certFile, keyFile, hostname string) (*StaticCertificate, error) {
+	s := &StaticCertificate{
+		logger:   logger,
+		certFile: certFile,
+		keyFile:  keyFile,
+		hostname: hostname,
+	}
+
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// GetCertificate returns the most recently loaded certificate, for use in tls.Config
+func (s *StaticCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.cert == nil {
+		return nil, ErrNoCertificate
+	}
+
+	return s.cert, nil
+}
+
+// Watch periodically checks the files for changes until the context is cancelled. Invalid replacements are logged and
+// the previous certificate continues to be served.
+func (s *StaticCertificate) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := s.reload()
+			if err != nil {
+				s.logger.WithError(err).Error("failed to reload certificate, continuing to use previous")
+			} else if reloaded {
+				s.logger.Info("reloaded certificate")
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload loads the certificate and key if either has changed since the last attempt
+func (s *StaticCertificate) reload() (bool, error) {
+	modified, err := latestModification(s.certFile, s.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	unchanged := s.cert != nil && !modified.After(s.modified)
+	s.modified = modified
+	s.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load key pair: %w", err)
+	}
+
+	if err := cert.Leaf.VerifyHostname(s.hostname); err != nil {
+		return false, fmt.Errorf("certificate does not match issuer: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cert = &cert
+
+	s.logger.WithFields(map[string]any{
+		"subject": cert.Leaf.Subject.String(),
+		"expires": cert.Leaf.NotAfter,
+	}).Debug("loaded certificate")
+	return true, nil
+}
+
+// latestModification finds the most recent modification time across the files
+func latestModification(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
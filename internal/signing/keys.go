@@ -0,0 +1,205 @@
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// KeyFormat determines how a private key is encoded
+type KeyFormat string
+
+const (
+	// KeyFormatPEM encodes the key as a PKCS #8 PEM block
+	KeyFormatPEM KeyFormat = "pem"
+	// KeyFormatJWK encodes the key as a JSON web key
+	KeyFormatJWK KeyFormat = "jwk"
+)
+
+var ErrUnknownKeyFormat = errors.New("unknown key format")
+
+// GenerateKey creates a new private key suitable for the given signing algorithm
+func GenerateKey(algorithm jose.SignatureAlgorithm) (crypto.Signer, error) {
+	switch algorithm {
+	case jose.RS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case jose.RS384:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case jose.RS512:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case jose.ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jose.ES384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case jose.ES512:
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case jose.EdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
+
+// EncodePrivateKey serializes a private key into the requested format
+func EncodePrivateKey(key crypto.Signer, format KeyFormat) ([]byte, error) {
+	switch format {
+	case KeyFormatPEM:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode private key: %w", err)
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+
+	case KeyFormatJWK:
+		id, err := thumbprint(key.Public())
+		if err != nil {
+			return nil, err
+		}
+
+		algorithm, err := algorithmForKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.MarshalIndent(jose.JSONWebKey{
+			Key:       key,
+			KeyID:     id,
+			Algorithm: string(algorithm),
+			Use:       "sig",
+		}, "", "  ")
+
+	default:
+		return nil, ErrUnknownKeyFormat
+	}
+}
+
+// ParsePrivateKey decodes a private key from either a PEM block or a JSON web key. PEM blocks can be in PKCS #8,
+// PKCS #1 (RSA), or SEC 1 (ECDSA) form.
+func ParsePrivateKey(encoded []byte) (crypto.Signer, error) {
+	encoded = bytes.TrimSpace(encoded)
+	if len(encoded) == 0 {
+		return nil, errors.New("private key is empty")
+	}
+
+	if encoded[0] == '{' {
+		return parseJwkPrivateKey(encoded)
+	}
+
+	return parsePemPrivateKey(encoded)
+}
+
+func parseJwkPrivateKey(encoded []byte) (crypto.Signer, error) {
+	var jwk jose.JSONWebKey
+	if err := jwk.UnmarshalJSON(encoded); err != nil {
+		return nil, fmt.Errorf("invalid JSON web key: %w", err)
+	}
+
+	if jwk.IsPublic() {
+		return nil, errors.New("JSON web key does not contain a private key")
+	}
+
+	return asSigner(jwk.Key)
+}
+
+func parsePemPrivateKey(encoded []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(encoded)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var (
+		key any
+		err error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", block.Type, err)
+	}
+
+	return asSigner(key)
+}
+
+func asSigner(key any) (crypto.Signer, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	case *ed25519.PrivateKey:
+		return *k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// algorithmForKey determines the JWS algorithm to use for a private key. RSA keys are mapped by their size, mirroring
+// the mapping used for KMS keys.
+func algorithmForKey(key crypto.Signer) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		switch bits := k.N.BitLen(); {
+		case bits < 2048:
+			return "", fmt.Errorf("RSA key must be at least 2048 bits, got %d", bits)
+		case bits >= 4096:
+			return jose.RS512, nil
+		case bits >= 3072:
+			return jose.RS384, nil
+		default:
+			return jose.RS256, nil
+		}
+
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		default:
+			return "", fmt.Errorf("unsupported elliptic curve %q", k.Curve.Params().Name)
+		}
+
+	case ed25519.PrivateKey:
+		return jose.EdDSA, nil
+
+	default:
+		return "", fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of a public key for use as a key ID
+func thumbprint(public crypto.PublicKey) (string, error) {
+	jwk := jose.JSONWebKey{Key: public}
+	digest, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute key thumbprint: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(digest), nil
+}
@@ -70,31 +70,31 @@ func (k *kmsBackend) Sign(claims oidc.Claims) (string, error) {
 	return jwt.Signed(k.signer).Claims(claims).Serialize()
 }
 
-func (k *kmsBackend) PublicKey() (jose.JSONWebKey, error) {
+func (k *kmsBackend) PublicKeys() ([]jose.JSONWebKey, error) {
 	k.logger.Debug("getting public key")
 	output, err := k.client.GetPublicKey(context.Background(), &kms.GetPublicKeyInput{KeyId: k.arn})
 	if err != nil {
-		return jose.JSONWebKey{}, err
+		return nil, err
 	}
 
 	k.logger.Debug("parsing encoded public key")
 	parsed, err := x509.ParsePKIXPublicKey(output.PublicKey)
 	if err != nil {
-		return jose.JSONWebKey{}, fmt.Errorf("failed to parse DER encoded public key: %w", err)
+		return nil, fmt.Errorf("failed to parse DER encoded public key: %w", err)
 	}
 
 	public, ok := parsed.(crypto.PublicKey)
 	if !ok {
 		// this should never happen, but just in case
-		return jose.JSONWebKey{}, errors.New("unknown public key type")
+		return nil, errors.New("unknown public key type")
 	}
 
-	return jose.JSONWebKey{
+	return []jose.JSONWebKey{{
 		Use:       "sig",
 		KeyID:     k.id,
 		Key:       public,
 		Algorithm: string(k.algorithm),
-	}, nil
+	}}, nil
 }
 
 type kmsKey struct {
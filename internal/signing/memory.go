@@ -44,11 +44,11 @@ func (m *inMemory) Sign(claims oidc.Claims) (string, error) {
 	return jwt.Signed(m.signer).Claims(claims).Serialize()
 }
 
-func (m *inMemory) PublicKey() (jose.JSONWebKey, error) {
-	return jose.JSONWebKey{
+func (m *inMemory) PublicKeys() ([]jose.JSONWebKey, error) {
+	return []jose.JSONWebKey{{
 		Use:       "sig",
 		KeyID:     m.id,
 		Key:       &m.private.PublicKey,
 		Algorithm: string(jose.RS256),
-	}, nil
+	}}, nil
 }
@@ -0,0 +1,77 @@
+package signing
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+
+	"github.com/akrantz01/tailfed/internal/oidc"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/sirupsen/logrus"
+)
+
+// static signs tokens using a persistent private key loaded at startup
+type static struct {
+	id        string
+	algorithm jose.SignatureAlgorithm
+	private   crypto.Signer
+	signer    jose.Signer
+}
+
+var _ Backend = (*static)(nil)
+
+// NewStaticFromFile creates a new signer from a PEM or JWK encoded private key stored at the given path
+func NewStaticFromFile(logger logrus.FieldLogger, path string) (Backend, error) {
+	logger.WithField("path", path).Debug("reading private key from file")
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	return NewStatic(logger, contents)
+}
+
+// NewStatic creates a new signer from a PEM or JWK encoded private key
+func NewStatic(logger logrus.FieldLogger, encoded []byte) (Backend, error) {
+	private, err := ParsePrivateKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	algorithm, err := algorithmForKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := thumbprint(private.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := newKey(id, private, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.
+		WithFields(map[string]any{
+			"id":        id,
+			"algorithm": algorithm,
+		}).
+		Info("created new static signer")
+	return &static{id, algorithm, private, signer}, nil
+}
+
+func (s *static) Sign(claims oidc.Claims) (string, error) {
+	return jwt.Signed(s.signer).Claims(claims).Serialize()
+}
+
+func (s *static) PublicKeys() ([]jose.JSONWebKey, error) {
+	return []jose.JSONWebKey{{
+		Use:       "sig",
+		KeyID:     s.id,
+		Key:       s.private.Public(),
+		Algorithm: string(s.algorithm),
+	}}, nil
+}
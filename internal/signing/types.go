@@ -7,8 +7,8 @@ import (
 
 // Backend provides a mechanism for generating signed JWTs
 type Backend interface {
-	// PublicKey returns details about the public key
-	PublicKey() (jose.JSONWebKey, error)
+	// PublicKeys returns details about all the public keys that tokens may currently be signed with
+	PublicKeys() ([]jose.JSONWebKey, error)
 	// Sign generates a signed JWT with the provided claims
 	Sign(claims oidc.Claims) (string, error)
 }
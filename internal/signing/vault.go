@@ -0,0 +1,427 @@
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/oidc"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+)
+
+// vaultKeyRefreshInterval is how long the latest key version is cached before checking for rotations
+const vaultKeyRefreshInterval = 1 * time.Minute
+
+// VaultConfig contains the details required to connect to a key in the Vault transit secrets engine
+type VaultConfig struct {
+	// Address is the URL of the Vault server, falling back to the VAULT_ADDR environment variable when empty
+	Address string
+	// Mount is the path the transit secrets engine is mounted at
+	Mount string
+	// Key is the name of the transit key to sign with
+	Key string
+	// Auth determines how to authenticate with Vault
+	Auth VaultAuthentication
+}
+
+// vaultBackend signs tokens using a key stored in the HashiCorp Vault transit secrets engine
+type vaultBackend struct {
+	logger logrus.FieldLogger
+	client *vaultapi.Client
+	auth   VaultAuthentication
+
+	mount string
+	name  string
+	spec  vaultKeySpec
+
+	mu             sync.Mutex
+	tokenExpiresAt time.Time
+	latest         int
+	checkedAt      time.Time
+	signers        map[int]jose.Signer
+}
+
+var _ Backend = (*vaultBackend)(nil)
+
+// NewVault creates a new signer backed by the HashiCorp Vault transit secrets engine
+func NewVault(logger logrus.FieldLogger, config VaultConfig) (Backend, error) {
+	vaultConfig := vaultapi.DefaultConfig()
+	if vaultConfig.Error != nil {
+		return nil, fmt.Errorf("failed to load vault config from environment: %w", vaultConfig.Error)
+	}
+	if len(config.Address) != 0 {
+		vaultConfig.Address = config.Address
+	}
+
+	client, err := vaultapi.NewClient(vaultConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault client: %w", err)
+	}
+
+	mount := strings.Trim(config.Mount, "/")
+	if len(mount) == 0 {
+		mount = "transit"
+	}
+
+	v := &vaultBackend{
+		logger: logger.WithFields(map[string]any{
+			"address": vaultConfig.Address,
+			"mount":   mount,
+			"key":     config.Key,
+		}),
+		client:  client,
+		auth:    config.Auth,
+		mount:   mount,
+		name:    config.Key,
+		signers: make(map[int]jose.Signer),
+	}
+	v.logger.WithField("method", config.Auth.Kind()).Debug("applied authentication method")
+
+	v.logger.Debug("resolving transit key...")
+	details, err := v.readKey(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transit key: %w", err)
+	}
+
+	if !details.SupportsSigning {
+		return nil, errors.New("key is not configured for signing")
+	}
+
+	spec, ok := vaultKeySpecs[details.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %q", details.Type)
+	}
+	v.spec = spec
+	v.latest = details.LatestVersion
+	v.checkedAt = time.Now()
+
+	v.logger.
+		WithFields(map[string]any{
+			"algorithm": spec.algorithm,
+			"version":   details.LatestVersion,
+		}).
+		Info("created new vault transit signer")
+	return v, nil
+}
+
+func (v *vaultBackend) Sign(claims oidc.Claims) (string, error) {
+	signer, err := v.latestSigner(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.Signed(signer).Claims(claims).Serialize()
+}
+
+func (v *vaultBackend) PublicKeys() ([]jose.JSONWebKey, error) {
+	v.logger.Debug("getting public keys")
+	details, err := v.readKey(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(details.Keys))
+	for version := range details.Keys {
+		// Archived versions can no longer be used, so there is no point in publishing them
+		if version >= details.MinDecryptionVersion {
+			versions = append(versions, version)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+	keys := make([]jose.JSONWebKey, 0, len(versions))
+	for _, version := range versions {
+		key, err := v.publicKey(details.Keys[version])
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key for version %d: %w", version, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// latestSigner retrieves the signer for the most recent key version, periodically checking for key rotations
+func (v *vaultBackend) latestSigner(ctx context.Context) (jose.Signer, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if time.Since(v.checkedAt) > vaultKeyRefreshInterval {
+		v.logger.Debug("checking for new key versions")
+		details, err := v.readKeyLocked(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if details.LatestVersion != v.latest {
+			v.logger.WithField("version", details.LatestVersion).Info("detected transit key rotation")
+		}
+
+		v.latest = details.LatestVersion
+		v.checkedAt = time.Now()
+	}
+
+	if signer, ok := v.signers[v.latest]; ok {
+		return signer, nil
+	}
+
+	details, err := v.readKeyLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := v.publicKey(details.Keys[v.latest])
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key for version %d: %w", v.latest, err)
+	}
+
+	signer, err := newKey(key.KeyID, &vaultKey{v, v.latest}, v.spec.algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	v.signers[v.latest] = signer
+	return signer, nil
+}
+
+// publicKey converts a version's public key to a JWK. Ed25519 keys are encoded as raw base64 while all other types
+// are PEM-encoded.
+func (v *vaultBackend) publicKey(version vaultKeyVersion) (jose.JSONWebKey, error) {
+	var public crypto.PublicKey
+	if v.spec.algorithm == jose.EdDSA {
+		raw, err := base64.StdEncoding.DecodeString(version.PublicKey)
+		if err != nil {
+			return jose.JSONWebKey{}, fmt.Errorf("failed to decode public key: %w", err)
+		} else if len(raw) != ed25519.PublicKeySize {
+			return jose.JSONWebKey{}, errors.New("invalid ed25519 public key length")
+		}
+
+		public = ed25519.PublicKey(raw)
+	} else {
+		block, _ := pem.Decode([]byte(version.PublicKey))
+		if block == nil {
+			return jose.JSONWebKey{}, errors.New("no PEM block found")
+		}
+
+		var err error
+		if public, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return jose.JSONWebKey{}, fmt.Errorf("failed to parse DER encoded public key: %w", err)
+		}
+	}
+
+	id, err := thumbprint(public)
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+
+	return jose.JSONWebKey{
+		Use:       "sig",
+		KeyID:     id,
+		Key:       public,
+		Algorithm: string(v.spec.algorithm),
+	}, nil
+}
+
+func (v *vaultBackend) readKey(ctx context.Context) (*vaultKeyDetails, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.readKeyLocked(ctx)
+}
+
+func (v *vaultBackend) readKeyLocked(ctx context.Context) (*vaultKeyDetails, error) {
+	if err := v.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	secret, err := v.client.Logical().ReadWithContext(ctx, fmt.Sprintf("%s/keys/%s", v.mount, v.name))
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.New("key does not exist")
+	}
+
+	encoded, err := json.Marshal(secret.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var details vaultKeyDetails
+	if err := json.Unmarshal(encoded, &details); err != nil {
+		return nil, fmt.Errorf("failed to decode key details: %w", err)
+	}
+
+	return &details, nil
+}
+
+// authenticate logs in to Vault if there is no token or the current one is about to expire. Must be called with the
+// mutex held.
+func (v *vaultBackend) authenticate(ctx context.Context) error {
+	if len(v.client.Token()) != 0 && (v.tokenExpiresAt.IsZero() || time.Until(v.tokenExpiresAt) > 30*time.Second) {
+		return nil
+	}
+
+	v.logger.WithField("method", v.auth.Kind()).Debug("authenticating with vault")
+	token, ttl, err := v.auth.login(ctx, v.client)
+	if err != nil {
+		return fmt.Errorf("failed to authenticate with vault: %w", err)
+	}
+
+	v.client.SetToken(token)
+	if ttl > 0 {
+		v.tokenExpiresAt = time.Now().Add(ttl)
+	} else {
+		v.tokenExpiresAt = time.Time{}
+	}
+
+	return nil
+}
+
+// vaultKey signs payloads using a specific version of a transit key
+type vaultKey struct {
+	backend *vaultBackend
+	version int
+}
+
+var _ jose.OpaqueSigner = (*vaultKey)(nil)
+
+func (k *vaultKey) Public() *jose.JSONWebKey {
+	// Intentionally left unimplemented
+	k.backend.logger.Warn("unexpected call to vaultKey.Public, method is unimplemented")
+	return nil
+}
+
+func (k *vaultKey) Algs() []jose.SignatureAlgorithm {
+	return []jose.SignatureAlgorithm{k.backend.spec.algorithm}
+}
+
+func (k *vaultKey) SignPayload(payload []byte, _ jose.SignatureAlgorithm) ([]byte, error) {
+	k.backend.logger.WithField("version", k.version).Debug("requesting payload signature")
+	spec := k.backend.spec
+
+	path := fmt.Sprintf("%s/sign/%s", k.backend.mount, k.backend.name)
+	if len(spec.hash) != 0 {
+		path += "/" + spec.hash
+	}
+
+	data := map[string]any{
+		"input":       base64.StdEncoding.EncodeToString(payload),
+		"key_version": k.version,
+	}
+	if len(spec.signatureAlgorithm) != 0 {
+		data["signature_algorithm"] = spec.signatureAlgorithm
+	}
+	if spec.transformer != nil {
+		data["marshaling_algorithm"] = "asn1"
+	}
+
+	k.backend.mu.Lock()
+	err := k.backend.authenticate(context.Background())
+	k.backend.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := k.backend.client.Logical().WriteWithContext(context.Background(), path, data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.New("empty response from vault")
+	}
+
+	raw, ok := secret.Data["signature"].(string)
+	if !ok {
+		return nil, errors.New("response did not contain a signature")
+	}
+
+	// Signatures are formatted as vault:v<version>:<base64 signature>
+	parts := strings.SplitN(raw, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, errors.New("malformed signature")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	if spec.transformer != nil {
+		k.backend.logger.Debug("transforming signature to jws-compatible format")
+		if signature, err = spec.transformer(signature); err != nil {
+			return nil, fmt.Errorf("failed to transform signature to JWT-compatible format: %w", err)
+		}
+	}
+
+	return signature, nil
+}
+
+// vaultKeySpec describes how to sign with a particular transit key type
+type vaultKeySpec struct {
+	algorithm          jose.SignatureAlgorithm
+	hash               string
+	signatureAlgorithm string
+	transformer        func([]byte) ([]byte, error)
+}
+
+var vaultKeySpecs = map[string]vaultKeySpec{
+	"rsa-2048":   {jose.RS256, "sha2-256", "pkcs1v15", nil},
+	"rsa-3072":   {jose.RS384, "sha2-384", "pkcs1v15", nil},
+	"rsa-4096":   {jose.RS512, "sha2-512", "pkcs1v15", nil},
+	"ecdsa-p256": {jose.ES256, "sha2-256", "", transformEcdsaSignature(32)},
+	"ecdsa-p384": {jose.ES384, "sha2-384", "", transformEcdsaSignature(48)},
+	"ecdsa-p521": {jose.ES512, "sha2-512", "", transformEcdsaSignature(66)},
+	"ed25519":    {jose.EdDSA, "", "", nil},
+}
+
+// vaultKeyDetails is the response from reading a transit key
+type vaultKeyDetails struct {
+	Type                 string           `json:"type"`
+	SupportsSigning      bool             `json:"supports_signing"`
+	LatestVersion        int              `json:"latest_version"`
+	MinDecryptionVersion int              `json:"min_decryption_version"`
+	Keys                 vaultKeyVersions `json:"keys"`
+}
+
+// vaultKeyVersion contains the details about a single version of a transit key
+type vaultKeyVersion struct {
+	PublicKey string `json:"public_key"`
+}
+
+// vaultKeyVersions maps key versions to their details. Vault encodes the versions as strings.
+type vaultKeyVersions map[int]vaultKeyVersion
+
+func (v *vaultKeyVersions) UnmarshalJSON(raw []byte) error {
+	var encoded map[string]vaultKeyVersion
+	if err := json.Unmarshal(raw, &encoded); err != nil {
+		return err
+	}
+
+	*v = make(vaultKeyVersions, len(encoded))
+	for key, value := range encoded {
+		version, err := strconv.Atoi(key)
+		if err != nil {
+			return fmt.Errorf("invalid key version %q: %w", key, err)
+		}
+
+		(*v)[version] = value
+	}
+
+	return nil
+}
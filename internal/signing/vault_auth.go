@@ -0,0 +1,119 @@
+package signing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// VaultAuthKind is a unique identifier for a VaultAuthentication implementation
+type VaultAuthKind string
+
+const (
+	// VaultAuthKindToken is used for the VaultToken authentication
+	VaultAuthKindToken VaultAuthKind = "token"
+	// VaultAuthKindAppRole is used for the VaultAppRole authentication
+	VaultAuthKindAppRole VaultAuthKind = "approle"
+	// VaultAuthKindJWT is used for the VaultJWT authentication
+	VaultAuthKindJWT VaultAuthKind = "jwt"
+)
+
+// VaultAuthentication determines how the signer will authenticate with Vault
+type VaultAuthentication interface {
+	Kind() VaultAuthKind
+	// login retrieves a client token and how long it is valid for. A zero duration means the token does not expire.
+	login(ctx context.Context, client *vaultapi.Client) (string, time.Duration, error)
+}
+
+type vaultToken struct {
+	token string
+}
+
+var _ VaultAuthentication = (*vaultToken)(nil)
+
+// VaultToken authenticates using a static client token
+func VaultToken(token string) VaultAuthentication {
+	return &vaultToken{token}
+}
+
+func (v *vaultToken) Kind() VaultAuthKind {
+	return VaultAuthKindToken
+}
+
+func (v *vaultToken) login(context.Context, *vaultapi.Client) (string, time.Duration, error) {
+	return v.token, 0, nil
+}
+
+type vaultAppRole struct {
+	mount    string
+	roleId   string
+	secretId string
+}
+
+var _ VaultAuthentication = (*vaultAppRole)(nil)
+
+// VaultAppRole authenticates using the AppRole auth method mounted at the given path
+func VaultAppRole(mount, roleId, secretId string) VaultAuthentication {
+	return &vaultAppRole{mount, roleId, secretId}
+}
+
+func (v *vaultAppRole) Kind() VaultAuthKind {
+	return VaultAuthKindAppRole
+}
+
+func (v *vaultAppRole) login(ctx context.Context, client *vaultapi.Client) (string, time.Duration, error) {
+	return vaultLogin(ctx, client, v.mount, map[string]any{
+		"role_id":   v.roleId,
+		"secret_id": v.secretId,
+	})
+}
+
+type vaultJwt struct {
+	mount string
+	role  string
+	token string
+}
+
+var _ VaultAuthentication = (*vaultJwt)(nil)
+
+// VaultJWT authenticates using a signed JWT with the JWT/OIDC auth method mounted at the given path
+func VaultJWT(mount, role, token string) VaultAuthentication {
+	return &vaultJwt{mount, role, token}
+}
+
+func (v *vaultJwt) Kind() VaultAuthKind {
+	return VaultAuthKindJWT
+}
+
+func (v *vaultJwt) login(ctx context.Context, client *vaultapi.Client) (string, time.Duration, error) {
+	return vaultLogin(ctx, client, v.mount, map[string]any{
+		"role": v.role,
+		"jwt":  strings.TrimSpace(v.token),
+	})
+}
+
+// vaultLogin exchanges credentials for a client token with the auth method mounted at the given path
+func vaultLogin(ctx context.Context, client *vaultapi.Client, mount string, data map[string]any) (string, time.Duration, error) {
+	path := fmt.Sprintf("auth/%s/login", strings.Trim(mount, "/"))
+
+	// Login requests must not include a token, otherwise Vault may reject them
+	unauthenticated, err := client.Clone()
+	if err != nil {
+		return "", 0, err
+	}
+	unauthenticated.ClearToken()
+
+	secret, err := unauthenticated.Logical().WriteWithContext(ctx, path, data)
+	if err != nil {
+		return "", 0, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return "", 0, errors.New("login response did not contain authentication details")
+	}
+
+	return secret.Auth.ClientToken, time.Duration(secret.Auth.LeaseDuration) * time.Second, nil
+}
@@ -0,0 +1,408 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/oidc"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	fakeVaultToken = "s.test"
+	fakeVaultKey   = "tailfed"
+)
+
+// fakeVault implements the parts of the Vault transit secrets engine and login endpoints used by the signer
+type fakeVault struct {
+	t *testing.T
+
+	mu                   sync.Mutex
+	keyType              string
+	supportsSigning      bool
+	minDecryptionVersion int
+	versions             []crypto.Signer
+	logins               int
+}
+
+// newFakeVault starts a fake Vault server with a single version of a key of the given type
+func newFakeVault(t *testing.T, keyType string) (*fakeVault, *httptest.Server) {
+	t.Helper()
+
+	// Clients pick up tokens from the environment, which would be used instead of the configured authentication
+	t.Setenv("VAULT_TOKEN", "")
+
+	v := &fakeVault{t: t, keyType: keyType, supportsSigning: true, minDecryptionVersion: 1}
+	v.rotate()
+
+	server := httptest.NewServer(v)
+	t.Cleanup(server.Close)
+
+	return v, server
+}
+
+// rotate adds a new version of the key
+func (v *fakeVault) rotate() {
+	v.t.Helper()
+
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch v.keyType {
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "ecdsa-p256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "rsa-2048":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		// Unsupported types are served with an arbitrary key so the signer can reject them
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		v.t.Fatal(err)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.versions = append(v.versions, key)
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if strings.HasPrefix(path, "auth/") && strings.HasSuffix(path, "/login") {
+		v.logins++
+		writeVaultResponse(w, map[string]any{
+			"auth": map[string]any{"client_token": fakeVaultToken, "lease_duration": 3600},
+		})
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != fakeVaultToken {
+		w.WriteHeader(http.StatusForbidden)
+		writeVaultResponse(w, map[string]any{"errors": []string{"permission denied"}})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path == "transit/keys/"+fakeVaultKey:
+		v.readKey(w)
+	case r.Method == http.MethodPut || r.Method == http.MethodPost:
+		if rest, ok := strings.CutPrefix(path, "transit/sign/"+fakeVaultKey); ok {
+			v.sign(w, r, strings.TrimPrefix(rest, "/"))
+			return
+		}
+		fallthrough
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		writeVaultResponse(w, map[string]any{"errors": []string{}})
+	}
+}
+
+func (v *fakeVault) readKey(w http.ResponseWriter) {
+	keys := make(map[string]any, len(v.versions))
+	for i, key := range v.versions {
+		var public string
+		if raw, ok := key.Public().(ed25519.PublicKey); ok {
+			public = base64.StdEncoding.EncodeToString(raw)
+		} else {
+			der, err := x509.MarshalPKIXPublicKey(key.Public())
+			if err != nil {
+				v.t.Error(err)
+			}
+			public = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		}
+
+		keys[strconv.Itoa(i+1)] = map[string]any{"public_key": public}
+	}
+
+	writeVaultResponse(w, map[string]any{
+		"data": map[string]any{
+			"type":                   v.keyType,
+			"supports_signing":       v.supportsSigning,
+			"latest_version":         len(v.versions),
+			"min_decryption_version": v.minDecryptionVersion,
+			"keys":                   keys,
+		},
+	})
+}
+
+func (v *fakeVault) sign(w http.ResponseWriter, r *http.Request, hash string) {
+	var body struct {
+		Input               string `json:"input"`
+		KeyVersion          int    `json:"key_version"`
+		SignatureAlgorithm  string `json:"signature_algorithm"`
+		MarshalingAlgorithm string `json:"marshaling_algorithm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		v.t.Errorf("invalid sign request: %v", err)
+		return
+	}
+	if body.KeyVersion < 1 || body.KeyVersion > len(v.versions) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeVaultResponse(w, map[string]any{"errors": []string{"invalid key version"}})
+		return
+	}
+
+	input, err := base64.StdEncoding.DecodeString(body.Input)
+	if err != nil {
+		v.t.Errorf("invalid sign input: %v", err)
+		return
+	}
+
+	var signature []byte
+	switch key := v.versions[body.KeyVersion-1].(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, input)
+	case *ecdsa.PrivateKey:
+		if body.MarshalingAlgorithm != "asn1" {
+			v.t.Errorf("expected asn1 marshaling, got %q", body.MarshalingAlgorithm)
+		}
+		signature, err = ecdsa.SignASN1(rand.Reader, key, digest(v.t, hash, input))
+	case *rsa.PrivateKey:
+		if body.SignatureAlgorithm != "pkcs1v15" {
+			v.t.Errorf("expected pkcs1v15 signatures, got %q", body.SignatureAlgorithm)
+		}
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, vaultHashes[hash], digest(v.t, hash, input))
+	}
+	if err != nil {
+		v.t.Errorf("failed to sign: %v", err)
+		return
+	}
+
+	writeVaultResponse(w, map[string]any{
+		"data": map[string]any{
+			"signature": "vault:v" + strconv.Itoa(body.KeyVersion) + ":" + base64.StdEncoding.EncodeToString(signature),
+		},
+	})
+}
+
+var vaultHashes = map[string]crypto.Hash{
+	"sha2-256": crypto.SHA256,
+	"sha2-384": crypto.SHA384,
+	"sha2-512": crypto.SHA512,
+}
+
+func digest(t *testing.T, hash string, input []byte) []byte {
+	h, ok := vaultHashes[hash]
+	if !ok {
+		t.Errorf("unexpected hash algorithm %q", hash)
+		return nil
+	}
+
+	hasher := h.New()
+	hasher.Write(input)
+	return hasher.Sum(nil)
+}
+
+func writeVaultResponse(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func newTestVault(t *testing.T, server *httptest.Server, auth VaultAuthentication) Backend {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	backend, err := NewVault(logger, VaultConfig{Address: server.URL, Key: fakeVaultKey, Auth: auth})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return backend
+}
+
+// verifyToken checks the token was signed by one of the published keys, returning the ID of the key that signed it
+func verifyToken(t *testing.T, backend Backend, token string) string {
+	t.Helper()
+
+	keys, err := backend.PublicKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	algorithms := make([]jose.SignatureAlgorithm, 0, len(keys))
+	for _, key := range keys {
+		algorithms = append(algorithms, jose.SignatureAlgorithm(key.Algorithm))
+	}
+
+	parsed, err := jwt.ParseSigned(token, algorithms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid := parsed.Headers[0].KeyID
+	for _, key := range keys {
+		if key.KeyID != kid {
+			continue
+		}
+
+		var claims oidc.Claims
+		if err := parsed.Claims(key.Key, &claims); err != nil {
+			t.Fatalf("signature does not verify: %v", err)
+		}
+		if claims.Subject != "node" {
+			t.Fatalf("unexpected subject %q", claims.Subject)
+		}
+
+		return kid
+	}
+
+	t.Fatalf("token signed by unpublished key %q", kid)
+	return ""
+}
+
+func testClaims() oidc.Claims {
+	return oidc.Claims{Claims: jwt.Claims{Subject: "node", IssuedAt: jwt.NewNumericDate(time.Now())}}
+}
+
+func TestVaultSign(t *testing.T) {
+	tests := map[string]jose.SignatureAlgorithm{
+		"ed25519":    jose.EdDSA,
+		"ecdsa-p256": jose.ES256,
+		"ecdsa-p384": jose.ES384,
+		"rsa-2048":   jose.RS256,
+	}
+
+	for keyType, algorithm := range tests {
+		t.Run(keyType, func(t *testing.T) {
+			_, server := newFakeVault(t, keyType)
+			backend := newTestVault(t, server, VaultToken(fakeVaultToken))
+
+			keys, err := backend.PublicKeys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 1 || keys[0].Algorithm != string(algorithm) {
+				t.Fatalf("expected a single %s key, got %+v", algorithm, keys)
+			}
+
+			token, err := backend.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifyToken(t, backend, token)
+		})
+	}
+}
+
+func TestVaultRotation(t *testing.T) {
+	vault, server := newFakeVault(t, "ecdsa-p256")
+	backend := newTestVault(t, server, VaultToken(fakeVaultToken))
+
+	before, err := backend.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := verifyToken(t, backend, before)
+
+	vault.rotate()
+
+	// Rotations are only checked for periodically
+	backend.(*vaultBackend).checkedAt = time.Now().Add(-2 * vaultKeyRefreshInterval)
+
+	after, err := backend.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated := verifyToken(t, backend, after); rotated == original {
+		t.Fatal("expected token to be signed with the rotated key")
+	}
+
+	keys, err := backend.PublicKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected both key versions to be published, got %d", len(keys))
+	}
+
+	vault.mu.Lock()
+	vault.minDecryptionVersion = 2
+	vault.mu.Unlock()
+
+	keys, err = backend.PublicKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].KeyID == original {
+		t.Fatalf("expected archived key version to be unpublished, got %+v", keys)
+	}
+}
+
+func TestVaultInvalidKeys(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	t.Run("unsupported type", func(t *testing.T) {
+		_, server := newFakeVault(t, "aes256-gcm96")
+		if _, err := NewVault(logger, VaultConfig{Address: server.URL, Key: fakeVaultKey, Auth: VaultToken(fakeVaultToken)}); err == nil {
+			t.Fatal("expected unsupported key type to be rejected")
+		}
+	})
+
+	t.Run("signing unsupported", func(t *testing.T) {
+		vault, server := newFakeVault(t, "ed25519")
+		vault.supportsSigning = false
+		if _, err := NewVault(logger, VaultConfig{Address: server.URL, Key: fakeVaultKey, Auth: VaultToken(fakeVaultToken)}); err == nil {
+			t.Fatal("expected key without signing support to be rejected")
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		_, server := newFakeVault(t, "ed25519")
+		if _, err := NewVault(logger, VaultConfig{Address: server.URL, Key: "missing", Auth: VaultToken(fakeVaultToken)}); err == nil {
+			t.Fatal("expected missing key to be rejected")
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		_, server := newFakeVault(t, "ed25519")
+		if _, err := NewVault(logger, VaultConfig{Address: server.URL, Key: fakeVaultKey, Auth: VaultToken("wrong")}); err == nil {
+			t.Fatal("expected invalid token to be rejected")
+		}
+	})
+}
+
+func TestVaultAppRoleLogin(t *testing.T) {
+	vault, server := newFakeVault(t, "ed25519")
+	backend := newTestVault(t, server, VaultAppRole("approle", "role", "secret"))
+
+	token, err := backend.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyToken(t, backend, token)
+
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	if vault.logins != 1 {
+		t.Fatalf("expected a single login while the token is valid, got %d", vault.logins)
+	}
+}
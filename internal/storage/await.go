@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Await polls the pending flow until it is no longer pending, it no longer exists, or the context is done. The most
+// recently seen state of the flow is returned, which is still pending if the context finished first, even if it
+// finished while the flow was being fetched.
+func Await(ctx context.Context, store Backend, flow *Flow, interval time.Duration) (*Flow, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for flow != nil && flow.Status == StatusPending {
+		select {
+		case <-ctx.Done():
+			return flow, nil
+		case <-ticker.C:
+		}
+
+		current, err := store.Get(ctx, flow.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return flow, nil
+			}
+
+			return nil, err
+		}
+
+		flow = current
+	}
+
+	return flow, nil
+}
@@ -2,6 +2,10 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -37,9 +41,16 @@ func (d *dynamo) Get(ctx context.Context, id string) (*Flow, error) {
 	logger := d.logger.WithField("id", id)
 	logger.Debug("fetching item from table")
 
-	output, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(d.table), Key: d.primaryKey(id)})
+	output, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            d.primaryKey(id),
+		ConsistentRead: aws.Bool(true),
+	})
 	if err != nil {
 		return nil, err
+	} else if output.Item == nil {
+		logger.Debug("item does not exist")
+		return nil, nil
 	}
 
 	logger.Debug("deserializing attribute values")
@@ -61,16 +72,377 @@ func (d *dynamo) Put(ctx context.Context, flow *Flow) error {
 	}
 
 	logger.Debug("writing item to table")
-	_, err = d.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(d.table), Item: av})
+	_, err = d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.table),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	if isConditionFailed(err) {
+		return ErrConflict
+	}
+
+	return err
+}
+
+func (d *dynamo) Transition(ctx context.Context, id string, from, to Status) error {
+	d.logger.WithFields(map[string]any{
+		"id":   id,
+		"from": from,
+		"to":   to,
+	}).Debug("transitioning flow status")
+
+	_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 d.primaryKey(id),
+		UpdateExpression:    aws.String("SET #status = :to ADD #version :one"),
+		ConditionExpression: aws.String("attribute_exists(ID) AND #status = :from"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":  "Status",
+			"#version": "Version",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":from": &types.AttributeValueMemberS{Value: string(from)},
+			":to":   &types.AttributeValueMemberS{Value: string(to)},
+			":one":  &types.AttributeValueMemberN{Value: "1"},
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrConflict
+	}
+
+	return err
+}
+
+func (d *dynamo) RecordAttempt(ctx context.Context, id string, attempt Attempt) error {
+	d.logger.WithField("id", id).Debug("recording verification attempt")
+
+	attempts, err := attributevalue.Marshal([]Attempt{attempt})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 d.primaryKey(id),
+		UpdateExpression:    aws.String("SET Attempts = list_append(if_not_exists(Attempts, :empty), :attempts)"),
+		ConditionExpression: aws.String("attribute_exists(ID)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty":    &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+			":attempts": attempts,
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrConflict
+	}
+
+	return err
+}
+
+func (d *dynamo) RecordLaunch(ctx context.Context, id string, addresses []string) error {
+	d.logger.WithField("id", id).Debug("recording launch")
+
+	encoded, err := attributevalue.Marshal(addresses)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 d.primaryKey(id),
+		UpdateExpression:    aws.String("SET LaunchAddresses = :addresses"),
+		ConditionExpression: aws.String("attribute_exists(ID)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":addresses": encoded,
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrConflict
+	}
+
 	return err
 }
 
+func (d *dynamo) Consume(ctx context.Context, id string) (*Flow, error) {
+	logger := d.logger.WithField("id", id)
+	logger.Debug("consuming flow")
+
+	output, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 d.primaryKey(id),
+		ConditionExpression: aws.String("#status = :success AND ExpiresAt > :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":success": &types.AttributeValueMemberS{Value: string(StatusSuccess)},
+			":now":     unixAttribute(time.Now()),
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if isConditionFailed(err) {
+		return nil, ErrConflict
+	} else if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("deserializing attribute values")
+	var flow Flow
+	if err := attributevalue.UnmarshalMap(output.Attributes, &flow); err != nil {
+		return nil, err
+	}
+
+	return &flow, nil
+}
+
 func (d *dynamo) Delete(ctx context.Context, id string) error {
 	d.logger.WithField("id", id).Debug("deleting item (if exists)")
 	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(d.table), Key: d.primaryKey(id)})
 	return err
 }
 
+func (d *dynamo) List(ctx context.Context, filter Filter) ([]Flow, error) {
+	conditions := []string{"NOT begins_with(ID, :counter)", "NOT begins_with(ID, :idempotency)"}
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{
+		":counter":     &types.AttributeValueMemberS{Value: counterKey("")},
+		":idempotency": &types.AttributeValueMemberS{Value: idempotencyKey("")},
+	}
+
+	if len(filter.Status) != 0 {
+		conditions = append(conditions, "#status = :status")
+		names["#status"] = "Status"
+		values[":status"] = &types.AttributeValueMemberS{Value: string(filter.Status)}
+	}
+	if len(filter.Node) != 0 {
+		conditions = append(conditions, "#node = :node")
+		names["#node"] = "Node"
+		values[":node"] = &types.AttributeValueMemberS{Value: filter.Node}
+	}
+	if !filter.ExpiredBefore.IsZero() {
+		conditions = append(conditions, "ExpiresAt <= :expired")
+		values[":expired"] = unixAttribute(filter.ExpiredBefore)
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(d.table),
+		FilterExpression:          aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeValues: values,
+	}
+	if len(names) != 0 {
+		input.ExpressionAttributeNames = names
+	}
+
+	var flows []Flow
+	paginator := dynamodb.NewScanPaginator(d.client, input)
+	for paginator.HasMorePages() && !filter.Full(flows) {
+		d.logger.Debug("scanning page of table")
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var items []Flow
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		flows = append(flows, items...)
+	}
+
+	if filter.Full(flows) {
+		flows = flows[:filter.Limit]
+	}
+
+	return flows, nil
+}
+
 type primaryKey struct {
 	ID string
 }
+
+// counterKey namespaces counters so they cannot collide with flows
+func counterKey(key string) string {
+	return "counter:" + key
+}
+
+func (d *dynamo) Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	logger := d.logger.WithField("counter", key)
+
+	// Two attempts are enough as losing the race to create the counter means it now exists
+	for range 2 {
+		now := time.Now()
+
+		logger.Debug("incrementing existing counter")
+		output, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:           aws.String(d.table),
+			Key:                 d.primaryKey(counterKey(key)),
+			UpdateExpression:    aws.String("ADD #count :one"),
+			ConditionExpression: aws.String("attribute_exists(ID) AND ExpiresAt > :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#count": "Count",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":one": &types.AttributeValueMemberN{Value: "1"},
+				":now": unixAttribute(now),
+			},
+			ReturnValues: types.ReturnValueAllNew,
+		})
+		if err == nil {
+			var c counter
+			if err := attributevalue.UnmarshalMap(output.Attributes, &c); err != nil {
+				return 0, time.Time{}, err
+			}
+
+			return c.Count, time.Unix(c.ExpiresAt, 0), nil
+		} else if !isConditionFailed(err) {
+			return 0, time.Time{}, err
+		}
+
+		logger.Debug("counter missing or expired, starting new window")
+		resetAt := now.Add(window)
+		item, err := attributevalue.MarshalMap(&counter{ID: counterKey(key), Count: 1, ExpiresAt: resetAt.Unix()})
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+
+		_, err = d.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(d.table),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(ID) OR ExpiresAt <= :now"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": unixAttribute(now),
+			},
+		})
+		if err == nil {
+			return 1, time.Unix(resetAt.Unix(), 0), nil
+		} else if !isConditionFailed(err) {
+			return 0, time.Time{}, err
+		}
+	}
+
+	return 0, time.Time{}, errors.New("counter was concurrently modified")
+}
+
+func (d *dynamo) Decrement(ctx context.Context, key string, resetBy time.Time) error {
+	d.logger.WithField("counter", key).Debug("decrementing counter")
+
+	_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 d.primaryKey(counterKey(key)),
+		UpdateExpression:    aws.String("ADD #count :minusOne"),
+		ConditionExpression: aws.String("attribute_exists(ID) AND ExpiresAt > :now AND ExpiresAt <= :resetBy AND #count > :zero"),
+		ExpressionAttributeNames: map[string]string{
+			"#count": "Count",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":minusOne": &types.AttributeValueMemberN{Value: "-1"},
+			":zero":     &types.AttributeValueMemberN{Value: "0"},
+			":now":      unixAttribute(time.Now()),
+			":resetBy":  unixAttribute(resetBy),
+		},
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+
+	return err
+}
+
+// counter is the representation of a counter in the table
+type counter struct {
+	ID        string
+	Count     int
+	ExpiresAt int64
+}
+
+// idempotencyKey namespaces idempotency keys so they cannot collide with flows
+func idempotencyKey(key string) string {
+	return "idempotency:" + key
+}
+
+// idempotencyItem is the DynamoDB representation of an idempotency key
+type idempotencyItem struct {
+	ID          string
+	FlowID      string
+	Fingerprint []byte
+	ExpiresAt   int64
+}
+
+func (d *dynamo) PutIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	if key == nil {
+		return errors.New("received nil idempotency key")
+	}
+
+	d.logger.WithField("key", key.Key).Debug("claiming idempotency key")
+	item, err := attributevalue.MarshalMap(&idempotencyItem{
+		ID:          idempotencyKey(key.Key),
+		FlowID:      key.FlowID,
+		Fingerprint: key.Fingerprint,
+		ExpiresAt:   key.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ID) OR ExpiresAt <= :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": unixAttribute(time.Now()),
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrConflict
+	}
+
+	return err
+}
+
+func (d *dynamo) GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyKey, error) {
+	d.logger.WithField("key", key).Debug("fetching idempotency key")
+
+	output, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            d.primaryKey(idempotencyKey(key)),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || output.Item == nil {
+		return nil, err
+	}
+
+	var item idempotencyItem
+	if err := attributevalue.UnmarshalMap(output.Item, &item); err != nil {
+		return nil, err
+	}
+
+	// TTL deletion is not immediate, so expired items may still be present
+	expiresAt := time.Unix(item.ExpiresAt, 0)
+	if !time.Now().Before(expiresAt) {
+		return nil, nil
+	}
+
+	return &IdempotencyKey{
+		Key:         key,
+		FlowID:      item.FlowID,
+		Fingerprint: item.Fingerprint,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+func (d *dynamo) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	d.logger.WithField("key", key).Debug("releasing idempotency key")
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key:       d.primaryKey(idempotencyKey(key)),
+	})
+	return err
+}
+
+func unixAttribute(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
+
+func isConditionFailed(err error) bool {
+	var conditionFailed *types.ConditionalCheckFailedException
+	return errors.As(err, &conditionFailed)
+}
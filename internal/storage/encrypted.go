@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// encryptedPrefix marks string fields that contain ciphertext
+const encryptedPrefix = "enc:v1:"
+
+// ErrNotEncrypted is returned when reading a flow that was stored without encryption
+var ErrNotEncrypted = errors.New("flow is not encrypted")
+
+// IsEncrypted checks whether a stored string field contains ciphertext
+func IsEncrypted(field string) bool {
+	return strings.HasPrefix(field, encryptedPrefix)
+}
+
+// encrypted seals the sensitive fields of flows before passing them to another backend
+type encrypted struct {
+	logger logrus.FieldLogger
+
+	inner           Backend
+	wrapper         KeyWrapper
+	includeIdentity bool
+	allowPlaintext  bool
+}
+
+var _ Backend = (*encrypted)(nil)
+
+// NewEncrypted wraps a backend such that each flow's secret is encrypted at rest with a unique data key. When
+// includeIdentity is set, the node's public key, DNS name, machine name, and hostname are also encrypted. Flows stored
+// without encryption are rejected unless allowPlaintext is set, which is only intended for migrating existing storage.
+func NewEncrypted(logger logrus.FieldLogger, inner Backend, wrapper KeyWrapper, includeIdentity, allowPlaintext bool) Backend {
+	logger.WithFields(map[string]any{
+		"identity":        includeIdentity,
+		"allow-plaintext": allowPlaintext,
+	}).Info("created new encrypted storage")
+	return &encrypted{logger, inner, wrapper, includeIdentity, allowPlaintext}
+}
+
+func (e *encrypted) Get(ctx context.Context, id string) (*Flow, error) {
+	flow, err := e.inner.Get(ctx, id)
+	if err != nil || flow == nil {
+		return flow, err
+	}
+
+	return e.decrypt(ctx, flow)
+}
+
+func (e *encrypted) Put(ctx context.Context, flow *Flow) error {
+	if flow == nil {
+		return errors.New("received nil flow")
+	}
+
+	sealed, err := e.encrypt(ctx, flow)
+	if err != nil {
+		return err
+	}
+
+	return e.inner.Put(ctx, sealed)
+}
+
+func (e *encrypted) Delete(ctx context.Context, id string) error {
+	return e.inner.Delete(ctx, id)
+}
+
+func (e *encrypted) PutIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	return e.inner.PutIdempotencyKey(ctx, key)
+}
+
+func (e *encrypted) GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyKey, error) {
+	return e.inner.GetIdempotencyKey(ctx, key)
+}
+
+func (e *encrypted) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	return e.inner.DeleteIdempotencyKey(ctx, key)
+}
+
+func (e *encrypted) RecordAttempt(ctx context.Context, id string, attempt Attempt) error {
+	return e.inner.RecordAttempt(ctx, id, attempt)
+}
+
+func (e *encrypted) RecordLaunch(ctx context.Context, id string, addresses []string) error {
+	return e.inner.RecordLaunch(ctx, id, addresses)
+}
+
+// List returns the flows as they are stored, sensitive fields remain encrypted. Use IsEncrypted to tell which
+// identity fields can be displayed.
+func (e *encrypted) List(ctx context.Context, filter Filter) ([]Flow, error) {
+	return e.inner.List(ctx, filter)
+}
+
+func (e *encrypted) Transition(ctx context.Context, id string, from, to Status) error {
+	return e.inner.Transition(ctx, id, from, to)
+}
+
+func (e *encrypted) Consume(ctx context.Context, id string) (*Flow, error) {
+	flow, err := e.inner.Consume(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.decrypt(ctx, flow)
+}
+
+func (e *encrypted) Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	return e.inner.Increment(ctx, key, window)
+}
+
+func (e *encrypted) Decrement(ctx context.Context, key string, resetBy time.Time) error {
+	return e.inner.Decrement(ctx, key, resetBy)
+}
+
+// encryptionContext binds the data key to a single flow
+func encryptionContext(id string) map[string]string {
+	return map[string]string{"tailfed:flow": id}
+}
+
+// encrypt seals a copy of the flow with a newly generated data key
+func (e *encrypted) encrypt(ctx context.Context, flow *Flow) (*Flow, error) {
+	e.logger.WithField("id", flow.ID).Debug("encrypting flow")
+
+	key, wrapped, err := e.wrapper.GenerateDataKey(ctx, encryptionContext(flow.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := *flow
+	sealed.DataKey = wrapped
+
+	if sealed.Secret, err = seal(aead, flow.Secret, fieldData(flow.ID, "Secret")); err != nil {
+		return nil, err
+	}
+
+	if e.includeIdentity {
+		for name, field := range identityFields(&sealed) {
+			ciphertext, err := seal(aead, []byte(*field), fieldData(flow.ID, name))
+			if err != nil {
+				return nil, err
+			}
+
+			*field = encryptedPrefix + base64.StdEncoding.EncodeToString(ciphertext)
+		}
+	}
+
+	return &sealed, nil
+}
+
+// decrypt opens any encrypted fields in the flow. Flows without a data key are only returned as-is when plaintext
+// flows are allowed, otherwise anyone able to write to the store could downgrade them.
+func (e *encrypted) decrypt(ctx context.Context, flow *Flow) (*Flow, error) {
+	if len(flow.DataKey) == 0 {
+		if !e.allowPlaintext {
+			return nil, fmt.Errorf("%w: %q", ErrNotEncrypted, flow.ID)
+		}
+
+		e.logger.WithField("id", flow.ID).Warn("flow is not encrypted")
+		return flow, nil
+	}
+
+	e.logger.WithField("id", flow.ID).Debug("decrypting flow")
+
+	key, err := e.wrapper.Unwrap(ctx, flow.DataKey, encryptionContext(flow.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if flow.Secret, err = open(aead, flow.Secret, fieldData(flow.ID, "Secret")); err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+
+	// Identity fields are checked individually as they may have been written with a different configuration
+	for name, field := range identityFields(flow) {
+		encoded, ok := strings.CutPrefix(*field, encryptedPrefix)
+		if !ok && e.includeIdentity && !e.allowPlaintext {
+			return nil, fmt.Errorf("%w: %s of %q", ErrNotEncrypted, name, flow.ID)
+		} else if !ok {
+			continue
+		}
+
+		ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ciphertext for %s: %w", name, err)
+		}
+
+		plaintext, err := open(aead, ciphertext, fieldData(flow.ID, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", name, err)
+		}
+
+		*field = string(plaintext)
+	}
+
+	flow.DataKey = nil
+	return flow, nil
+}
+
+// identityFields lists the optionally encrypted fields which identify the node
+func identityFields(flow *Flow) map[string]*string {
+	return map[string]*string{
+		"PublicKey":   &flow.PublicKey,
+		"DNSName":     &flow.DNSName,
+		"MachineName": &flow.MachineName,
+		"Hostname":    &flow.Hostname,
+	}
+}
+
+// fieldData binds a ciphertext to both the flow and the field it belongs to
+func fieldData(id, field string) []byte {
+	return []byte(id + "|" + field)
+}
+
+// Prune forwards to the wrapped backend if it stores its own expiring data
+func (e *encrypted) Prune(ctx context.Context, now time.Time) (int64, error) {
+	if pruner, ok := e.inner.(interface {
+		Prune(context.Context, time.Time) (int64, error)
+	}); ok {
+		return pruner.Prune(ctx, now)
+	}
+
+	return 0, nil
+}
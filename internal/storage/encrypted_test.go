@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestEncrypted wraps a fresh filesystem storage, returning both the encrypted and underlying backends
+func newTestEncrypted(t *testing.T, includeIdentity, allowPlaintext bool) (Backend, Backend) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	inner, err := NewFilesystem(logger, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapper, err := NewLocalKeyWrapper(logger, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewEncrypted(logger, inner, wrapper, includeIdentity, allowPlaintext), inner
+}
+
+func newIdentityFlow(id string) *Flow {
+	return &Flow{
+		ID:          id,
+		Status:      StatusSuccess,
+		ExpiresAt:   UnixTime(time.Now().Add(time.Minute)),
+		Secret:      []byte("secret"),
+		Node:        "node",
+		PublicKey:   "nodekey:abc",
+		DNSName:     "node.example.ts.net.",
+		MachineName: "node",
+		Hostname:    "laptop",
+	}
+}
+
+// replace overwrites the stored form of a flow
+func replace(t *testing.T, store Backend, flow *Flow) {
+	t.Helper()
+
+	if err := store.Delete(context.Background(), flow.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), flow); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	for name, includeIdentity := range map[string]bool{"secret only": false, "with identity": true} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store, inner := newTestEncrypted(t, includeIdentity, false)
+
+			original := newIdentityFlow("flow")
+			if err := store.Put(ctx, original); err != nil {
+				t.Fatal(err)
+			}
+
+			stored, err := inner.Get(ctx, "flow")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored.DataKey) == 0 || bytes.Equal(stored.Secret, original.Secret) {
+				t.Fatalf("expected secret to be encrypted at rest, got %+v", stored)
+			}
+			for name, field := range identityFields(stored) {
+				if IsEncrypted(*field) != includeIdentity {
+					t.Errorf("expected %s to be encrypted: %t, got %q", name, includeIdentity, *field)
+				}
+			}
+
+			// Listing leaves flows as they are stored
+			listed, err := store.List(ctx, Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(listed) != 1 || IsEncrypted(listed[0].Hostname) != includeIdentity {
+				t.Fatalf("expected listed flow to be in its stored form, got %+v", listed)
+			}
+
+			for _, read := range []func() (*Flow, error){
+				func() (*Flow, error) { return store.Get(ctx, "flow") },
+				func() (*Flow, error) { return store.Consume(ctx, "flow") },
+			} {
+				flow, err := read()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(flow.Secret, original.Secret) || len(flow.DataKey) != 0 {
+					t.Fatalf("expected secret to be decrypted, got %+v", flow)
+				}
+				for name, field := range identityFields(flow) {
+					if want := *identityFields(original)[name]; *field != want {
+						t.Errorf("expected %s to be %q, got %q", name, want, *field)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptedRejectsTampering(t *testing.T) {
+	tests := map[string]struct {
+		includeIdentity bool
+		allowPlaintext  bool
+		// tamper modifies the stored form of the flow, given the stored form of another flow
+		tamper   func(flow, other *Flow)
+		rejected bool
+		// err is the specific error expected when the flow is rejected, if any
+		err error
+	}{
+		"stripped data key": {
+			tamper: func(flow, _ *Flow) {
+				flow.DataKey = nil
+				flow.Secret = []byte("attacker")
+			},
+			rejected: true,
+			err:      ErrNotEncrypted,
+		},
+		"stripped data key while migrating": {
+			allowPlaintext: true,
+			tamper: func(flow, _ *Flow) {
+				flow.DataKey = nil
+				flow.Secret = []byte("attacker")
+			},
+		},
+		"plaintext identity field": {
+			includeIdentity: true,
+			tamper:          func(flow, _ *Flow) { flow.DNSName = "attacker.example.ts.net." },
+			rejected:        true,
+			err:             ErrNotEncrypted,
+		},
+		"plaintext identity field while migrating": {
+			includeIdentity: true,
+			allowPlaintext:  true,
+			tamper:          func(flow, _ *Flow) { flow.DNSName = "attacker.example.ts.net." },
+		},
+		"swapped identity fields": {
+			includeIdentity: true,
+			tamper:          func(flow, _ *Flow) { flow.DNSName, flow.Hostname = flow.Hostname, flow.DNSName },
+			rejected:        true,
+		},
+		"secret from another flow": {
+			tamper:   func(flow, other *Flow) { flow.Secret = other.Secret },
+			rejected: true,
+		},
+		"data key from another flow": {
+			tamper:   func(flow, other *Flow) { flow.DataKey, flow.Secret = other.DataKey, other.Secret },
+			rejected: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store, inner := newTestEncrypted(t, tt.includeIdentity, tt.allowPlaintext)
+
+			for _, id := range []string{"flow", "other"} {
+				if err := store.Put(ctx, newIdentityFlow(id)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			flow, err := inner.Get(ctx, "flow")
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := inner.Get(ctx, "other")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.tamper(flow, other)
+			replace(t, inner, flow)
+
+			_, err = store.Get(ctx, "flow")
+			switch {
+			case !tt.rejected && err != nil:
+				t.Fatalf("expected flow to be readable, got %v", err)
+			case tt.rejected && err == nil:
+				t.Fatal("expected tampered flow to be rejected")
+			case tt.err != nil && !errors.Is(err, tt.err):
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -2,17 +2,27 @@ package storage
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-// filesystem stores data as JSON files in a directory
+// filesystem stores data as JSON files in a directory. Multiple processes can share the directory, such as the server
+// and the admin commands, as every read-modify-write holds an advisory lock on a file in the directory. Advisory
+// locks are unsupported on windows, where the directory must only be used by a single process.
 type filesystem struct {
 	logger logrus.FieldLogger
 	inner  *os.Root
+
+	flows    fileLock
+	counters fileLock
+	keys     fileLock
 }
 
 var _ Backend = (*filesystem)(nil)
@@ -32,10 +42,56 @@ func NewFilesystem(logger logrus.FieldLogger, base string) (Backend, error) {
 	}
 
 	logger.Info("created new filesystem storage")
-	return &filesystem{logger, fs}, nil
+	return &filesystem{
+		logger:   logger,
+		inner:    fs,
+		flows:    fileLock{name: ".flows.lock"},
+		counters: fileLock{name: ".counters.lock"},
+		keys:     fileLock{name: ".keys.lock"},
+	}, nil
+}
+
+// fileLock serializes access to one kind of file. The mutex covers goroutines within this process, while an advisory
+// lock on a file in the directory covers other processes.
+type fileLock struct {
+	mu   sync.Mutex
+	name string
+}
+
+// lock acquires both halves of the lock, returning a function that releases them
+func (fs *filesystem) lock(l *fileLock) (func(), error) {
+	l.mu.Lock()
+
+	file, err := fs.inner.OpenFile(l.name, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+		l.mu.Unlock()
+		return nil, err
+	}
+
+	// Closing the file releases the advisory lock
+	return func() {
+		file.Close()
+		l.mu.Unlock()
+	}, nil
 }
 
 func (fs *filesystem) Get(_ context.Context, id string) (*Flow, error) {
+	unlock, err := fs.lock(&fs.flows)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return fs.read(id)
+}
+
+func (fs *filesystem) read(id string) (*Flow, error) {
 	logger := fs.logger.WithField("id", id)
 	logger.Debug("attempting to open file")
 
@@ -64,11 +120,26 @@ func (fs *filesystem) Put(_ context.Context, flow *Flow) error {
 		return errors.New("received nil flow")
 	}
 
+	unlock, err := fs.lock(&fs.flows)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fs.write(flow, os.O_EXCL)
+}
+
+// write serializes a flow to its file, with additional flags for opening the file
+func (fs *filesystem) write(flow *Flow, flags int) error {
 	logger := fs.logger.WithField("id", flow.ID)
 	logger.Debug("attempting to create file")
 
-	file, err := fs.inner.Create(flow.ID + ".json")
+	file, err := fs.inner.OpenFile(flow.ID+".json", os.O_WRONLY|os.O_CREATE|flags, 0o644)
 	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return ErrConflict
+		}
+
 		return err
 	}
 	defer file.Close()
@@ -81,6 +152,16 @@ func (fs *filesystem) Put(_ context.Context, flow *Flow) error {
 }
 
 func (fs *filesystem) Delete(_ context.Context, id string) error {
+	unlock, err := fs.lock(&fs.flows)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fs.remove(id)
+}
+
+func (fs *filesystem) remove(id string) error {
 	logger := fs.logger.WithField("id", id)
 	logger.Debug("attempting to remove file")
 
@@ -95,3 +176,367 @@ func (fs *filesystem) Delete(_ context.Context, id string) error {
 
 	return nil
 }
+
+func (fs *filesystem) List(_ context.Context, filter Filter) ([]Flow, error) {
+	unlock, err := fs.lock(&fs.flows)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	fs.logger.Debug("listing flow files")
+	entries, err := fs.readDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var flows []Flow
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, "counter-") || strings.HasPrefix(name, "idempotency-") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		flow, err := fs.read(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			return nil, err
+		} else if flow == nil || !filter.Matches(flow) {
+			continue
+		}
+
+		flows = append(flows, *flow)
+		if filter.Full(flows) {
+			break
+		}
+	}
+
+	return flows, nil
+}
+
+// readDir lists the entries in the storage directory
+func (fs *filesystem) readDir() ([]os.DirEntry, error) {
+	dir, err := fs.inner.Open(".")
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	return dir.ReadDir(-1)
+}
+
+func (fs *filesystem) RecordAttempt(_ context.Context, id string, attempt Attempt) error {
+	unlock, err := fs.lock(&fs.flows)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	flow, err := fs.read(id)
+	if err != nil {
+		return err
+	} else if flow == nil {
+		return ErrConflict
+	}
+
+	flow.Attempts = append(flow.Attempts, attempt)
+	return fs.write(flow, os.O_TRUNC)
+}
+
+func (fs *filesystem) RecordLaunch(_ context.Context, id string, addresses []string) error {
+	unlock, err := fs.lock(&fs.flows)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	flow, err := fs.read(id)
+	if err != nil {
+		return err
+	} else if flow == nil {
+		return ErrConflict
+	}
+
+	flow.LaunchAddresses = addresses
+	return fs.write(flow, os.O_TRUNC)
+}
+
+func (fs *filesystem) Transition(_ context.Context, id string, from, to Status) error {
+	unlock, err := fs.lock(&fs.flows)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	flow, err := fs.read(id)
+	if err != nil {
+		return err
+	} else if flow == nil || flow.Status != from {
+		return ErrConflict
+	}
+
+	flow.Status = to
+	flow.Version++
+	return fs.write(flow, os.O_TRUNC)
+}
+
+func (fs *filesystem) Consume(_ context.Context, id string) (*Flow, error) {
+	unlock, err := fs.lock(&fs.flows)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	flow, err := fs.read(id)
+	if err != nil {
+		return nil, err
+	} else if flow == nil || !flow.Consumable(time.Now()) {
+		return nil, ErrConflict
+	}
+
+	if err := fs.remove(id); err != nil {
+		return nil, err
+	}
+
+	return flow, nil
+}
+
+// fileCounter is the on-disk representation of a counter
+type fileCounter struct {
+	Count     int
+	ExpiresAt time.Time
+}
+
+// counterPath encodes the key so it is always a valid file name
+func counterPath(key string) string {
+	return "counter-" + base64.RawURLEncoding.EncodeToString([]byte(key)) + ".json"
+}
+
+func (fs *filesystem) Increment(_ context.Context, key string, window time.Duration) (int, time.Time, error) {
+	unlock, err := fs.lock(&fs.counters)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer unlock()
+
+	now := time.Now()
+	c, err := fs.readCounter(key)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	if c == nil || !now.Before(c.ExpiresAt) {
+		fs.logger.WithField("counter", key).Debug("starting new counter window")
+		c = &fileCounter{ExpiresAt: now.Add(window)}
+	}
+	c.Count++
+
+	if err := fs.writeCounter(key, c); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return c.Count, c.ExpiresAt, nil
+}
+
+func (fs *filesystem) Decrement(_ context.Context, key string, resetBy time.Time) error {
+	unlock, err := fs.lock(&fs.counters)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	c, err := fs.readCounter(key)
+	if err != nil {
+		return err
+	} else if c == nil || c.Count == 0 || !time.Now().Before(c.ExpiresAt) || c.ExpiresAt.Unix() > resetBy.Unix() {
+		return nil
+	}
+
+	c.Count--
+	return fs.writeCounter(key, c)
+}
+
+func (fs *filesystem) readCounter(key string) (*fileCounter, error) {
+	file, err := fs.inner.Open(counterPath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return nil, err
+	}
+	defer file.Close()
+
+	c := new(fileCounter)
+	if err := json.NewDecoder(file).Decode(c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (fs *filesystem) writeCounter(key string, c *fileCounter) error {
+	file, err := fs.inner.Create(counterPath(key))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(c)
+}
+
+// idempotencyKeyPath encodes the key so it is always a valid file name
+func idempotencyKeyPath(key string) string {
+	return "idempotency-" + base64.RawURLEncoding.EncodeToString([]byte(key)) + ".json"
+}
+
+func (fs *filesystem) PutIdempotencyKey(_ context.Context, key *IdempotencyKey) error {
+	if key == nil {
+		return errors.New("received nil idempotency key")
+	}
+
+	unlock, err := fs.lock(&fs.keys)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	existing, err := fs.readIdempotencyKey(key.Key)
+	if err != nil {
+		return err
+	} else if existing != nil && time.Now().Before(existing.ExpiresAt) {
+		return ErrConflict
+	}
+
+	file, err := fs.inner.Create(idempotencyKeyPath(key.Key))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(key)
+}
+
+func (fs *filesystem) GetIdempotencyKey(_ context.Context, key string) (*IdempotencyKey, error) {
+	unlock, err := fs.lock(&fs.keys)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	existing, err := fs.readIdempotencyKey(key)
+	if err != nil || existing == nil || !time.Now().Before(existing.ExpiresAt) {
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+func (fs *filesystem) DeleteIdempotencyKey(_ context.Context, key string) error {
+	unlock, err := fs.lock(&fs.keys)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := fs.inner.Remove(idempotencyKeyPath(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (fs *filesystem) readIdempotencyKey(key string) (*IdempotencyKey, error) {
+	file, err := fs.inner.Open(idempotencyKeyPath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return nil, err
+	}
+	defer file.Close()
+
+	k := new(IdempotencyKey)
+	if err := json.NewDecoder(file).Decode(k); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Prune deletes all the rate limit counters and idempotency keys that expired at or before now, returning how many
+// were removed. Flows are removed through Delete.
+func (fs *filesystem) Prune(_ context.Context, now time.Time) (int64, error) {
+	entries, err := fs.readDir()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		var (
+			lock      *fileLock
+			expiresAt func(*os.File) (time.Time, error)
+		)
+		switch name := entry.Name(); {
+		case entry.IsDir() || !strings.HasSuffix(name, ".json"):
+			continue
+		case strings.HasPrefix(name, "counter-"):
+			lock = &fs.counters
+			expiresAt = func(file *os.File) (time.Time, error) {
+				var c fileCounter
+				err := json.NewDecoder(file).Decode(&c)
+				return c.ExpiresAt, err
+			}
+		case strings.HasPrefix(name, "idempotency-"):
+			lock = &fs.keys
+			expiresAt = func(file *os.File) (time.Time, error) {
+				var k IdempotencyKey
+				err := json.NewDecoder(file).Decode(&k)
+				return k.ExpiresAt, err
+			}
+		default:
+			continue
+		}
+
+		removed, err := fs.pruneFile(lock, entry.Name(), now, expiresAt)
+		if err != nil {
+			return total, err
+		} else if removed {
+			total++
+		}
+	}
+
+	fs.logger.WithField("removed", total).Debug("pruned expired counters and idempotency keys")
+	return total, nil
+}
+
+// pruneFile removes the file if it expired at or before now
+func (fs *filesystem) pruneFile(lock *fileLock, name string, now time.Time, expiresAt func(*os.File) (time.Time, error)) (bool, error) {
+	unlock, err := fs.lock(lock)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	file, err := fs.inner.Open(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	expires, err := expiresAt(file)
+	file.Close()
+	if err != nil {
+		return false, err
+	} else if expires.After(now) {
+		return false, nil
+	}
+
+	if err := fs.inner.Remove(name); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFilesystemPrune(t *testing.T) {
+	ctx := context.Background()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	backend, err := NewFilesystem(logger, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := backend.(*filesystem)
+
+	now := time.Now()
+	if _, _, err := fs.Increment(ctx, "expired", -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fs.Increment(ctx, "active", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for key, expiresAt := range map[string]time.Time{"expired-key": now.Add(-time.Minute), "active-key": now.Add(time.Minute)} {
+		if err := fs.PutIdempotencyKey(ctx, &IdempotencyKey{Key: key, FlowID: "flow", ExpiresAt: expiresAt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putTestFlow(t, fs, "flow", StatusPending, -time.Minute)
+
+	removed, err := fs.Prune(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected 2 entries to be pruned, got %d", removed)
+	}
+
+	if c, err := fs.readCounter("expired"); err != nil || c != nil {
+		t.Fatalf("expected expired counter to be removed, got %+v (%v)", c, err)
+	}
+	if c, err := fs.readCounter("active"); err != nil || c == nil {
+		t.Fatalf("expected active counter to remain, got %+v (%v)", c, err)
+	}
+	if k, err := fs.readIdempotencyKey("expired-key"); err != nil || k != nil {
+		t.Fatalf("expected expired idempotency key to be removed, got %+v (%v)", k, err)
+	}
+	if k, err := fs.readIdempotencyKey("active-key"); err != nil || k == nil {
+		t.Fatalf("expected active idempotency key to remain, got %+v (%v)", k, err)
+	}
+
+	// Flows are only removed by the sweeper
+	if flow, err := fs.Get(ctx, "flow"); err != nil || flow == nil {
+		t.Fatalf("expected expired flow to remain, got %+v (%v)", flow, err)
+	}
+}
+
+func TestFilesystemSharedDirectory(t *testing.T) {
+	ctx := context.Background()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// Separate stores stand in for separate processes, as they only share the advisory locks
+	dir := t.TempDir()
+	stores := make([]Backend, 2)
+	for i := range stores {
+		store, err := NewFilesystem(logger, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores[i] = store
+	}
+
+	putTestFlow(t, stores[0], "flow", StatusSuccess, time.Minute)
+
+	const increments = 50
+	var (
+		wg       sync.WaitGroup
+		consumed atomic.Int32
+	)
+	for _, store := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for range increments {
+				if _, _, err := store.Increment(ctx, "counter", time.Minute); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+
+			if _, err := store.Consume(ctx, "flow"); err == nil {
+				consumed.Add(1)
+			} else if !errors.Is(err, ErrConflict) {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := consumed.Load(); n != 1 {
+		t.Fatalf("expected the flow to be consumed exactly once, got %d", n)
+	}
+
+	count, _, err := stores[1].Increment(ctx, "counter", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(stores)*increments + 1; count != want {
+		t.Fatalf("expected no increments to be lost, got count %d want %d", count, want)
+	}
+}
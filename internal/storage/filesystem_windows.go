@@ -0,0 +1,11 @@
+//go:build windows
+
+package storage
+
+import "os"
+
+// lockFile is a no-op as advisory locks are unsupported on windows, so the directory must only be used by a single
+// process at a time
+func lockFile(*os.File) error {
+	return nil
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/sirupsen/logrus"
+)
+
+// dataKeySize is the length of the AES-256 data keys
+const dataKeySize = 32
+
+// KeyWrapper protects the data keys used to encrypt flows
+type KeyWrapper interface {
+	// GenerateDataKey creates a new data key, returning both the plaintext and wrapped forms. The wrapped form can only
+	// be unwrapped with the same encryption context.
+	GenerateDataKey(ctx context.Context, encryptionContext map[string]string) ([]byte, []byte, error)
+	// Unwrap recovers the plaintext data key
+	Unwrap(ctx context.Context, wrapped []byte, encryptionContext map[string]string) ([]byte, error)
+}
+
+// kmsWrapper wraps data keys using a symmetric AWS KMS key
+type kmsWrapper struct {
+	logger logrus.FieldLogger
+
+	key    string
+	client *kms.Client
+}
+
+var _ KeyWrapper = (*kmsWrapper)(nil)
+
+// NewKMSKeyWrapper creates a new key wrapper using the given AWS KMS key ID, ARN, or alias
+func NewKMSKeyWrapper(logger logrus.FieldLogger, config aws.Config, key string) KeyWrapper {
+	logger = logger.WithField("key", key)
+	logger.Info("created new KMS key wrapper")
+	return &kmsWrapper{logger, key, kms.NewFromConfig(config)}
+}
+
+func (k *kmsWrapper) GenerateDataKey(ctx context.Context, encryptionContext map[string]string) ([]byte, []byte, error) {
+	k.logger.Debug("generating data key")
+	output, err := k.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(k.key),
+		KeySpec:           types.DataKeySpecAes256,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return output.Plaintext, output.CiphertextBlob, nil
+}
+
+func (k *kmsWrapper) Unwrap(ctx context.Context, wrapped []byte, encryptionContext map[string]string) ([]byte, error) {
+	k.logger.Debug("decrypting data key")
+	output, err := k.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(k.key),
+		CiphertextBlob:    wrapped,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Plaintext, nil
+}
+
+// localWrapper wraps data keys with a locally held AES-256 key
+type localWrapper struct {
+	aead cipher.AEAD
+}
+
+var _ KeyWrapper = (*localWrapper)(nil)
+
+// NewLocalKeyWrapperFromFile creates a new key wrapper from a base64-encoded 32-byte key stored at the given path
+func NewLocalKeyWrapperFromFile(logger logrus.FieldLogger, path string) (KeyWrapper, error) {
+	logger.WithField("path", path).Debug("reading key encryption key from file")
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key encryption key: %w", err)
+	}
+
+	return NewLocalKeyWrapper(logger, string(contents))
+}
+
+// NewLocalKeyWrapper creates a new key wrapper from a base64-encoded 32-byte key
+func NewLocalKeyWrapper(logger logrus.FieldLogger, encoded string) (KeyWrapper, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("key encryption key is not valid base64: %w", err)
+	}
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("key encryption key must be %d bytes, got %d", dataKeySize, len(key))
+	}
+
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("created new local key wrapper")
+	return &localWrapper{aead}, nil
+}
+
+func (l *localWrapper) GenerateDataKey(_ context.Context, encryptionContext map[string]string) ([]byte, []byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := seal(l.aead, key, serializeContext(encryptionContext))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, wrapped, nil
+}
+
+func (l *localWrapper) Unwrap(_ context.Context, wrapped []byte, encryptionContext map[string]string) ([]byte, error) {
+	return open(l.aead, wrapped, serializeContext(encryptionContext))
+}
+
+// serializeContext deterministically encodes the encryption context for use as additional data
+func serializeContext(encryptionContext map[string]string) []byte {
+	var sb strings.Builder
+	for _, key := range slices.Sorted(maps.Keys(encryptionContext)) {
+		sb.WriteString(key)
+		sb.WriteRune('=')
+		sb.WriteString(encryptionContext[key])
+		sb.WriteRune(';')
+	}
+
+	return []byte(sb.String())
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext, prefixing the result with a random nonce
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts a ciphertext produced by seal
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
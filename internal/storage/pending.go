@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// ReleasePending frees the flow's slot in its node's pending flow cap. Slots are only released from the window the
+// flow was counted in, so flows outliving their window cannot free a slot claimed by a newer flow.
+func ReleasePending(ctx context.Context, store Backend, flow *Flow) error {
+	return store.Decrement(ctx, PendingFlowsKey(flow.Node), time.Time(flow.ExpiresAt))
+}
+
+// MarkFailed transitions the pending flow to failed and releases its pending flow slot. ErrConflict is returned if the
+// flow no longer exists or is no longer pending.
+func MarkFailed(ctx context.Context, store Backend, id string) error {
+	flow, err := store.Get(ctx, id)
+	if err != nil {
+		return err
+	} else if flow == nil {
+		return ErrConflict
+	}
+
+	if err := store.Transition(ctx, id, StatusPending, StatusFailed); err != nil {
+		return err
+	}
+
+	return ReleasePending(ctx, store, flow)
+}
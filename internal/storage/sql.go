@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/database"
+	"github.com/sirupsen/logrus"
+)
+
+// flowColumns lists every column of the flows table in the order they are scanned
+const flowColumns = "id, status, version, expires_at, secret, node, public_key, dns_name, machine_name, hostname, tailnet, os, tags, authorized, external, data_key, attempts, launch_addresses"
+
+// sqlStorage stores data in a SQLite or PostgreSQL database
+type sqlStorage struct {
+	logger logrus.FieldLogger
+	db     *database.DB
+}
+
+var _ Backend = (*sqlStorage)(nil)
+
+// NewSQL creates a new database-backed storage
+func NewSQL(logger logrus.FieldLogger, db *database.DB) (Backend, error) {
+	logger = logger.WithField("dialect", db.Dialect())
+	logger.Info("created new SQL storage")
+	return &sqlStorage{logger, db}, nil
+}
+
+// rowScanner abstracts over [sql.Row] and [sql.Rows]
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFlow(row rowScanner) (*Flow, error) {
+	var (
+		flow      Flow
+		status    string
+		expiresAt int64
+		tags      string
+		attempts  sql.NullString
+		addresses sql.NullString
+	)
+
+	err := row.Scan(
+		&flow.ID, &status, &flow.Version, &expiresAt, &flow.Secret, &flow.Node, &flow.PublicKey, &flow.DNSName,
+		&flow.MachineName, &flow.Hostname, &flow.Tailnet, &flow.OS, &tags, &flow.Authorized, &flow.External,
+		&flow.DataKey, &attempts, &addresses,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := flow.Status.UnmarshalText(status); err != nil {
+		return nil, err
+	}
+	flow.ExpiresAt = UnixTime(time.Unix(expiresAt, 0))
+
+	if err := json.Unmarshal([]byte(tags), &flow.Tags); err != nil {
+		return nil, err
+	}
+
+	if attempts.Valid {
+		if err := json.Unmarshal([]byte(attempts.String), &flow.Attempts); err != nil {
+			return nil, err
+		}
+	}
+
+	if addresses.Valid {
+		if err := json.Unmarshal([]byte(addresses.String), &flow.LaunchAddresses); err != nil {
+			return nil, err
+		}
+	}
+
+	return &flow, nil
+}
+
+func (s *sqlStorage) Get(ctx context.Context, id string) (*Flow, error) {
+	s.logger.WithField("id", id).Debug("fetching flow from database")
+
+	row := s.db.QueryRowContext(ctx, s.db.Rebind("SELECT "+flowColumns+" FROM flows WHERE id = ?"), id)
+	flow, err := scanFlow(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return flow, err
+}
+
+func (s *sqlStorage) Put(ctx context.Context, flow *Flow) error {
+	if flow == nil {
+		return errors.New("received nil flow")
+	}
+
+	s.logger.WithField("id", flow.ID).Debug("inserting flow into database")
+
+	tags, err := json.Marshal(flow.Tags)
+	if err != nil {
+		return err
+	}
+
+	var attempts sql.NullString
+	if len(flow.Attempts) != 0 {
+		encoded, err := json.Marshal(flow.Attempts)
+		if err != nil {
+			return err
+		}
+		attempts = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	var addresses sql.NullString
+	if len(flow.LaunchAddresses) != 0 {
+		encoded, err := json.Marshal(flow.LaunchAddresses)
+		if err != nil {
+			return err
+		}
+		addresses = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	result, err := s.db.ExecContext(
+		ctx,
+		s.db.Rebind("INSERT INTO flows ("+flowColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING"),
+		flow.ID, string(flow.Status), flow.Version, time.Time(flow.ExpiresAt).Unix(), flow.Secret, flow.Node,
+		flow.PublicKey, flow.DNSName, flow.MachineName, flow.Hostname, flow.Tailnet, flow.OS, string(tags),
+		flow.Authorized, flow.External, flow.DataKey, attempts, addresses,
+	)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+func (s *sqlStorage) Transition(ctx context.Context, id string, from, to Status) error {
+	s.logger.WithFields(logrus.Fields{"id": id, "from": from, "to": to}).Debug("transitioning flow")
+
+	result, err := s.db.ExecContext(
+		ctx,
+		s.db.Rebind("UPDATE flows SET status = ?, version = version + 1 WHERE id = ? AND status = ?"),
+		string(to), id, string(from),
+	)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+func (s *sqlStorage) RecordAttempt(ctx context.Context, id string, attempt Attempt) error {
+	s.logger.WithField("id", id).Debug("recording verification attempt")
+
+	encoded, err := json.Marshal(&attempt)
+	if err != nil {
+		return err
+	}
+
+	// Appending in a single statement avoids lost updates from concurrent attempts
+	var appendAttempt string
+	switch s.db.Dialect() {
+	case database.DialectPostgres:
+		appendAttempt = "(COALESCE(attempts, '[]')::jsonb || jsonb_build_array(?::jsonb))::text"
+	default:
+		appendAttempt = "json_insert(COALESCE(attempts, '[]'), '$[#]', json(?))"
+	}
+
+	result, err := s.db.ExecContext(
+		ctx,
+		s.db.Rebind("UPDATE flows SET attempts = "+appendAttempt+" WHERE id = ?"),
+		string(encoded), id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+func (s *sqlStorage) RecordLaunch(ctx context.Context, id string, addresses []string) error {
+	s.logger.WithField("id", id).Debug("recording launch")
+
+	encoded, err := json.Marshal(addresses)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.ExecContext(ctx, s.db.Rebind("UPDATE flows SET launch_addresses = ? WHERE id = ?"), string(encoded), id)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+func (s *sqlStorage) Consume(ctx context.Context, id string) (*Flow, error) {
+	s.logger.WithField("id", id).Debug("consuming flow")
+
+	row := s.db.QueryRowContext(
+		ctx,
+		s.db.Rebind("DELETE FROM flows WHERE id = ? AND status = ? AND expires_at > ? RETURNING "+flowColumns),
+		id, string(StatusSuccess), time.Now().Unix(),
+	)
+	flow, err := scanFlow(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrConflict
+	}
+
+	return flow, err
+}
+
+func (s *sqlStorage) Delete(ctx context.Context, id string) error {
+	s.logger.WithField("id", id).Debug("deleting flow from database")
+
+	_, err := s.db.ExecContext(ctx, s.db.Rebind("DELETE FROM flows WHERE id = ?"), id)
+	return err
+}
+
+func (s *sqlStorage) List(ctx context.Context, filter Filter) ([]Flow, error) {
+	var (
+		conditions []string
+		args       []any
+	)
+	if len(filter.Status) != 0 {
+		conditions = append(conditions, "status = ?")
+		args = append(args, string(filter.Status))
+	}
+	if len(filter.Node) != 0 {
+		conditions = append(conditions, "node = ?")
+		args = append(args, filter.Node)
+	}
+	if !filter.ExpiredBefore.IsZero() {
+		conditions = append(conditions, "expires_at <= ?")
+		args = append(args, filter.ExpiredBefore.Unix())
+	}
+
+	query := "SELECT " + flowColumns + " FROM flows"
+	if len(conditions) != 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	s.logger.WithField("filter", filter).Debug("listing flows from database")
+	rows, err := s.db.QueryContext(ctx, s.db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flows []Flow
+	for rows.Next() {
+		flow, err := scanFlow(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		flows = append(flows, *flow)
+	}
+
+	return flows, rows.Err()
+}
+
+func (s *sqlStorage) Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	now := time.Now()
+
+	var (
+		count     int
+		expiresAt int64
+	)
+	err := s.db.QueryRowContext(
+		ctx,
+		s.db.Rebind(`INSERT INTO counters (name, count, expires_at) VALUES (?, 1, ?)
+ON CONFLICT (name) DO UPDATE SET
+	count = CASE WHEN counters.expires_at > ? THEN counters.count + 1 ELSE 1 END,
+	expires_at = CASE WHEN counters.expires_at > ? THEN counters.expires_at ELSE excluded.expires_at END
+RETURNING count, expires_at`),
+		key, now.Add(window).Unix(), now.Unix(), now.Unix(),
+	).Scan(&count, &expiresAt)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return count, time.Unix(expiresAt, 0), nil
+}
+
+func (s *sqlStorage) Decrement(ctx context.Context, key string, resetBy time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		s.db.Rebind("UPDATE counters SET count = count - 1 WHERE name = ? AND count > 0 AND expires_at > ? AND expires_at <= ?"),
+		key, time.Now().Unix(), resetBy.Unix(),
+	)
+	return err
+}
+
+func (s *sqlStorage) PutIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	if key == nil {
+		return errors.New("received nil idempotency key")
+	}
+
+	result, err := s.db.ExecContext(
+		ctx,
+		s.db.Rebind(`INSERT INTO idempotency_keys (name, flow_id, fingerprint, expires_at) VALUES (?, ?, ?, ?)
+ON CONFLICT (name) DO UPDATE SET
+	flow_id = excluded.flow_id,
+	fingerprint = excluded.fingerprint,
+	expires_at = excluded.expires_at
+WHERE idempotency_keys.expires_at <= ?`),
+		key.Key, key.FlowID, key.Fingerprint, key.ExpiresAt.Unix(), time.Now().Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+func (s *sqlStorage) GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyKey, error) {
+	var (
+		k         IdempotencyKey
+		expiresAt int64
+	)
+	err := s.db.QueryRowContext(
+		ctx,
+		s.db.Rebind("SELECT name, flow_id, fingerprint, expires_at FROM idempotency_keys WHERE name = ? AND expires_at > ?"),
+		key, time.Now().Unix(),
+	).Scan(&k.Key, &k.FlowID, &k.Fingerprint, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	k.ExpiresAt = time.Unix(expiresAt, 0)
+	return &k, nil
+}
+
+func (s *sqlStorage) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.db.Rebind("DELETE FROM idempotency_keys WHERE name = ?"), key)
+	return err
+}
+
+// requireAffected converts a statement that changed no rows into ErrConflict
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/database"
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+// newTestSQL creates a storage backed by a fresh SQLite database
+func newTestSQL(t *testing.T) Backend {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	db, err := database.Open(context.Background(), logger, database.DialectSQLite, filepath.Join(t.TempDir(), "tailfed.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	store, err := NewSQL(logger, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// putTestFlow saves a flow in the given state that expires after the given duration
+func putTestFlow(t *testing.T, store Backend, id string, status Status, expiresIn time.Duration) {
+	t.Helper()
+
+	err := store.Put(context.Background(), &Flow{
+		ID:        id,
+		Status:    status,
+		ExpiresAt: UnixTime(time.Now().Add(expiresIn)),
+		Secret:    []byte("secret"),
+		Node:      "node",
+		Tags:      []string{"tag:test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLPut(t *testing.T) {
+	ctx := context.Background()
+	store := newTestSQL(t)
+
+	putTestFlow(t, store, "flow", StatusPending, time.Minute)
+
+	flow, err := store.Get(ctx, "flow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flow == nil || flow.Status != StatusPending || string(flow.Secret) != "secret" || len(flow.Tags) != 1 {
+		t.Fatalf("flow was not saved correctly, got %+v", flow)
+	}
+
+	if err := store.Put(ctx, flow); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected duplicate flow to conflict, got %v", err)
+	}
+
+	missing, err := store.Get(ctx, "missing")
+	if err != nil || missing != nil {
+		t.Fatalf("expected missing flow to be nil, got %+v (%v)", missing, err)
+	}
+}
+
+func TestSQLTransition(t *testing.T) {
+	ctx := context.Background()
+	store := newTestSQL(t)
+	putTestFlow(t, store, "flow", StatusPending, time.Minute)
+
+	tests := []struct {
+		name     string
+		id       string
+		from, to Status
+		err      error
+	}{
+		{"from expected status", "flow", StatusPending, StatusSuccess, nil},
+		{"from stale status", "flow", StatusPending, StatusFailed, ErrConflict},
+		{"missing flow", "missing", StatusPending, StatusSuccess, ErrConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := store.Transition(ctx, tt.id, tt.from, tt.to); !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	flow, err := store.Get(ctx, "flow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flow.Status != StatusSuccess || flow.Version != 1 {
+		t.Fatalf("expected a single transition to success, got status %q at version %d", flow.Status, flow.Version)
+	}
+}
+
+func TestSQLConsume(t *testing.T) {
+	ctx := context.Background()
+	store := newTestSQL(t)
+
+	putTestFlow(t, store, "success", StatusSuccess, time.Minute)
+	putTestFlow(t, store, "pending", StatusPending, time.Minute)
+	putTestFlow(t, store, "failed", StatusFailed, time.Minute)
+	putTestFlow(t, store, "expired", StatusSuccess, -time.Minute)
+
+	tests := []struct {
+		name string
+		id   string
+		err  error
+	}{
+		{"verified flow", "success", nil},
+		{"already consumed", "success", ErrConflict},
+		{"pending flow", "pending", ErrConflict},
+		{"failed flow", "failed", ErrConflict},
+		{"expired flow", "expired", ErrConflict},
+		{"missing flow", "missing", ErrConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow, err := store.Consume(ctx, tt.id)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err == nil && (flow == nil || flow.ID != tt.id || string(flow.Secret) != "secret") {
+				t.Fatalf("expected consumed flow to be returned, got %+v", flow)
+			}
+		})
+	}
+
+	if flow, err := store.Get(ctx, "success"); err != nil || flow != nil {
+		t.Fatalf("expected consumed flow to be deleted, got %+v (%v)", flow, err)
+	}
+	if flow, err := store.Get(ctx, "pending"); err != nil || flow == nil {
+		t.Fatalf("expected unconsumed flow to remain, got %+v (%v)", flow, err)
+	}
+}
+
+func TestSQLIncrement(t *testing.T) {
+	ctx := context.Background()
+	store := newTestSQL(t)
+
+	var resetAt time.Time
+	for want := 1; want <= 3; want++ {
+		count, expiresAt, err := store.Increment(ctx, "counter", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Fatalf("expected count %d, got %d", want, count)
+		}
+
+		if resetAt.IsZero() {
+			resetAt = expiresAt
+		} else if !expiresAt.Equal(resetAt) {
+			t.Fatalf("expected window to stay fixed at %s, got %s", resetAt, expiresAt)
+		}
+	}
+
+	if count, _, err := store.Increment(ctx, "other", time.Minute); err != nil || count != 1 {
+		t.Fatalf("expected counters to be independent, got %d (%v)", count, err)
+	}
+
+	// A window that already elapsed starts over on the next increment
+	if _, _, err := store.Increment(ctx, "elapsed", -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if count, _, err := store.Increment(ctx, "elapsed", time.Minute); err != nil || count != 1 {
+		t.Fatalf("expected elapsed window to reset, got %d (%v)", count, err)
+	}
+}
+
+func TestSQLDecrement(t *testing.T) {
+	ctx := context.Background()
+	store := newTestSQL(t)
+
+	_, resetAt, err := store.Increment(ctx, "counter", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		resetBy time.Time
+		want    int
+	}{
+		{"window resets later", resetAt.Add(-time.Second), 2},
+		{"window resets in time", resetAt, 1},
+		{"never below zero", resetAt.Add(time.Minute), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := store.Decrement(ctx, "counter", tt.resetBy); err != nil {
+				t.Fatal(err)
+			}
+
+			// Incrementing reveals the current count, so undo it afterwards
+			count, _, err := store.Increment(ctx, "counter", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.want {
+				t.Fatalf("expected count %d after decrementing, got %d", tt.want, count)
+			}
+			if err := store.Decrement(ctx, "counter", resetAt); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSQLRecordAttempt(t *testing.T) {
+	ctx := context.Background()
+	store := newTestSQL(t)
+	putTestFlow(t, store, "flow", StatusPending, time.Minute)
+
+	reasons := []FailureReason{ReasonTimeout, ReasonUnreachable, ReasonSignatureMismatch}
+	for _, reason := range reasons {
+		err := store.RecordAttempt(ctx, "flow", Attempt{Address: "100.64.0.1:1234", Reason: reason, At: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flow, err := store.Get(ctx, "flow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flow.Attempts) != len(reasons) {
+		t.Fatalf("expected %d attempts, got %d", len(reasons), len(flow.Attempts))
+	}
+	for i, attempt := range flow.Attempts {
+		if attempt.Reason != reasons[i] || attempt.Address != "100.64.0.1:1234" {
+			t.Fatalf("expected attempts in the order they were made, got %+v", flow.Attempts)
+		}
+	}
+
+	if err := store.RecordAttempt(ctx, "missing", Attempt{Reason: ReasonTimeout}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected missing flow to conflict, got %v", err)
+	}
+}
@@ -16,16 +16,95 @@ import (
 type Backend interface {
 	// Get retrieves a flow by its ID
 	Get(ctx context.Context, id string) (*Flow, error)
-	// Put writes a flow
+	// Put creates a new flow, failing with ErrConflict if it already exists
 	Put(ctx context.Context, flow *Flow) error
+	// Transition atomically moves a flow between statuses, failing with ErrConflict if the flow does not exist or is not
+	// in the expected status
+	Transition(ctx context.Context, id string, from, to Status) error
+	// Consume atomically deletes a successfully verified, unexpired flow and returns it. This fails with ErrConflict if
+	// the flow is not in a consumable state, guaranteeing each flow is consumed at most once.
+	Consume(ctx context.Context, id string) (*Flow, error)
 	// Delete permanently deletes a flow
 	Delete(ctx context.Context, id string) error
+	// RecordAttempt appends a failed verification attempt to a flow, failing with ErrConflict if it does not exist
+	RecordAttempt(ctx context.Context, id string, attempt Attempt) error
+	// RecordLaunch saves the addresses a flow's challenge is verified against, so verification can be resumed after
+	// a restart. This fails with ErrConflict if the flow does not exist.
+	RecordLaunch(ctx context.Context, id string, addresses []string) error
+	// List finds the flows matching the filter in no particular order
+	List(ctx context.Context, filter Filter) ([]Flow, error)
+
+	// Increment atomically adds one to a counter, returning the new count and when it resets. Counters start at zero
+	// and reset once the window since their first increment has elapsed.
+	Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+	// Decrement atomically subtracts one from a counter if it has not yet reset, never going below zero. Only a window
+	// resetting no later than resetBy is decremented, so a count made in an earlier window cannot be taken from a later
+	// one.
+	Decrement(ctx context.Context, key string, resetBy time.Time) error
+
+	// PutIdempotencyKey claims an idempotency key until it expires, failing with ErrConflict if it is already claimed
+	PutIdempotencyKey(ctx context.Context, key *IdempotencyKey) error
+	// GetIdempotencyKey retrieves a claimed idempotency key, returning nil if it is unclaimed or has expired
+	GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyKey, error)
+	// DeleteIdempotencyKey releases a claimed idempotency key
+	DeleteIdempotencyKey(ctx context.Context, key string) error
+}
+
+// ErrConflict indicates the flow was not in the state required by the operation
+var ErrConflict = errors.New("flow state conflict")
+
+// PendingFlowsKey is the counter tracking the number of in-progress flows for a node
+func PendingFlowsKey(node string) string {
+	return "pending:" + node
+}
+
+// IdempotencyKey records which flow was started for a client-supplied idempotency key
+type IdempotencyKey struct {
+	Key    string
+	FlowID string
+	// Fingerprint is a digest of the request that started the flow
+	Fingerprint []byte
+	ExpiresAt   time.Time
+}
+
+// Filter restricts which flows are listed. Empty fields match everything.
+type Filter struct {
+	// Status finds flows in the given state
+	Status Status
+	// Node finds the flows started by a node
+	Node string
+	// ExpiredBefore finds flows that expired at or before the given time
+	ExpiredBefore time.Time
+
+	// Limit caps the number of flows returned
+	Limit int
+}
+
+// Matches checks whether the flow satisfies the filter
+func (f *Filter) Matches(flow *Flow) bool {
+	if len(f.Status) != 0 && flow.Status != f.Status {
+		return false
+	}
+	if len(f.Node) != 0 && flow.Node != f.Node {
+		return false
+	}
+	if !f.ExpiredBefore.IsZero() && time.Time(flow.ExpiresAt).After(f.ExpiredBefore) {
+		return false
+	}
+
+	return true
+}
+
+// Full checks whether enough flows were found to satisfy the limit
+func (f *Filter) Full(flows []Flow) bool {
+	return f.Limit > 0 && len(flows) >= f.Limit
 }
 
 // Flow represents all the data associated with a single token issuance process
 type Flow struct {
 	ID        string
 	Status    Status
+	Version   int
 	ExpiresAt UnixTime
 
 	Secret      []byte
@@ -39,11 +118,76 @@ type Flow struct {
 	Tags        []string
 	Authorized  bool
 	External    bool
+
+	// DataKey is the wrapped key used to encrypt the flow's sensitive fields, empty if the flow is not encrypted
+	DataKey []byte `json:",omitempty" dynamodbav:",omitempty"`
+	// Attempts records each failed verification attempt in the order they were made
+	Attempts []Attempt `json:",omitempty" dynamodbav:",omitempty"`
+	// LaunchAddresses are the addresses being verified by the local launcher, empty if it was launched elsewhere
+	LaunchAddresses []string `json:",omitempty" dynamodbav:",omitempty"`
+}
+
+// Attempt describes a single failed verification of a flow's challenge
+type Attempt struct {
+	Address string
+	Reason  FailureReason
+	// Detail is the underlying error message
+	Detail string
+	At     time.Time `dynamodbav:",unixtime"`
+}
+
+// FailureReason classifies why a verification attempt failed
+type FailureReason string
+
+const (
+	// ReasonUnknown is used when no attempts were recorded
+	ReasonUnknown FailureReason = "unknown"
+	// ReasonUnreachable means the verifier could not connect to the address
+	ReasonUnreachable FailureReason = "unreachable"
+	// ReasonTimeout means the connection or response timed out
+	ReasonTimeout FailureReason = "timeout"
+	// ReasonInvalidResponse means the response could not be decoded
+	ReasonInvalidResponse FailureReason = "invalid-response"
+	// ReasonRejected means the client responded with an error
+	ReasonRejected FailureReason = "rejected"
+	// ReasonSignatureMismatch means the challenge signature was incorrect, such as when the tailnet does not match
+	ReasonSignatureMismatch FailureReason = "signature-mismatch"
+	// ReasonSkipped means the address was not probed to completion as the policy was already decided. It is never
+	// recorded as an attempt.
+	ReasonSkipped FailureReason = "skipped"
+)
+
+// reasonPrecedence orders reasons from most to least actionable. Later failures, like a bad signature, show the
+// client was reached and are more useful than earlier ones.
+var reasonPrecedence = []FailureReason{
+	ReasonSignatureMismatch,
+	ReasonRejected,
+	ReasonInvalidResponse,
+	ReasonTimeout,
+	ReasonUnreachable,
+}
+
+// FailureReason summarizes why the flow's challenge could not be verified
+func (f *Flow) FailureReason() FailureReason {
+	for _, reason := range reasonPrecedence {
+		for _, attempt := range f.Attempts {
+			if attempt.Reason == reason {
+				return reason
+			}
+		}
+	}
+
+	return ReasonUnknown
 }
 
 // Status represents the current status of the flow
 type Status string
 
+// Consumable checks whether a token can be issued for the flow
+func (f *Flow) Consumable(now time.Time) bool {
+	return f.Status == StatusSuccess && now.Before(time.Time(f.ExpiresAt))
+}
+
 var (
 	StatusPending Status = "pending"
 	StatusFailed  Status = "failed"
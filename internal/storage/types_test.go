@@ -0,0 +1,35 @@
+package storage
+
+import "testing"
+
+func TestFlowFailureReason(t *testing.T) {
+	tests := map[string]struct {
+		attempts []FailureReason
+		want     FailureReason
+	}{
+		"no attempts":               {nil, ReasonUnknown},
+		"single attempt":            {[]FailureReason{ReasonTimeout}, ReasonTimeout},
+		"repeated reason":           {[]FailureReason{ReasonUnreachable, ReasonUnreachable}, ReasonUnreachable},
+		"signature beats rejection": {[]FailureReason{ReasonRejected, ReasonSignatureMismatch}, ReasonSignatureMismatch},
+		"rejection beats timeout":   {[]FailureReason{ReasonTimeout, ReasonRejected, ReasonUnreachable}, ReasonRejected},
+		"invalid response beats timeout": {
+			[]FailureReason{ReasonUnreachable, ReasonTimeout, ReasonInvalidResponse},
+			ReasonInvalidResponse,
+		},
+		"timeout beats unreachable": {[]FailureReason{ReasonUnreachable, ReasonTimeout}, ReasonTimeout},
+		"unrecognized reason":       {[]FailureReason{"something-else"}, ReasonUnknown},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			flow := Flow{}
+			for _, reason := range tt.attempts {
+				flow.Attempts = append(flow.Attempts, Attempt{Reason: reason})
+			}
+
+			if got := flow.FailureReason(); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package sweeper
+
+import (
+	"context"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultBatchSize is the number of expired flows deleted per batch when none is specified
+const DefaultBatchSize = 100
+
+// Pruner removes additional expired data alongside flows, such as rate limit counters
+type Pruner interface {
+	// Prune deletes everything that expired at or before now, returning how much was removed
+	Prune(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Sweeper deletes expired flows from storage in batches
+type Sweeper struct {
+	logger    logrus.FieldLogger
+	store     storage.Backend
+	batchSize int
+	pruners   []Pruner
+}
+
+// Option configures the sweeper
+type Option func(*Sweeper)
+
+// WithBatchSize sets how many expired flows are listed and deleted at once
+func WithBatchSize(size int) Option {
+	return func(s *Sweeper) {
+		if size > 0 {
+			s.batchSize = size
+		}
+	}
+}
+
+// WithPruner removes additional expired data on every sweep
+func WithPruner(pruner Pruner) Option {
+	return func(s *Sweeper) {
+		s.pruners = append(s.pruners, pruner)
+	}
+}
+
+// New creates a sweeper for the store
+func New(logger logrus.FieldLogger, store storage.Backend, opts ...Option) *Sweeper {
+	s := &Sweeper{logger: logger, store: store, batchSize: DefaultBatchSize}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Sweep deletes every flow that expired at or before now, returning how many were removed
+func (s *Sweeper) Sweep(ctx context.Context, now time.Time) (int, error) {
+	filter := storage.Filter{ExpiredBefore: now, Limit: s.batchSize}
+
+	var removed int
+	for {
+		flows, err := s.store.List(ctx, filter)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, flow := range flows {
+			// Failed flows already released their slot when they failed
+			if flow.Status != storage.StatusFailed {
+				if err := storage.ReleasePending(ctx, s.store, &flow); err != nil {
+					return removed, err
+				}
+			}
+
+			s.logger.WithField("id", flow.ID).Debug("deleting expired flow")
+			if err := s.store.Delete(ctx, flow.ID); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+
+		if len(flows) < s.batchSize {
+			break
+		}
+	}
+
+	for _, pruner := range s.pruners {
+		if _, err := pruner.Prune(ctx, now); err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// Run periodically sweeps expired flows until the context is cancelled
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration) {
+	logger := s.logger.WithField("interval", interval)
+	logger.Info("starting expired flow sweeper")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("stopping expired flow sweeper")
+			return
+
+		case now := <-ticker.C:
+			removed, err := s.Sweep(ctx, now)
+			if err != nil {
+				logger.WithError(err).Error("failed to sweep expired flows")
+				continue
+			}
+
+			logger.WithField("removed", removed).Debug("swept expired flows")
+		}
+	}
+}
@@ -8,6 +8,17 @@ type StartRequest struct {
 	Node string `json:"node"`
 	// Ports contains the listening ports for the tailnet addresses
 	Ports Ports `json:"ports"`
+	// IdempotencyKey optionally identifies the request so retries return the same flow instead of starting a new one.
+	// It must be unguessable as replaying it returns the flow's signing secret.
+	IdempotencyKey string `json:"idempotency-key,omitempty"`
+	// SigningSecret optionally replaces the secret generated by the server. Clients supplying it must already be
+	// serving their challenge, as the server may verify it before responding.
+	SigningSecret []byte `json:"signing-secret,omitempty"`
+}
+
+// Redacted returns a copy of the request that is safe to log, without the idempotency key or signing secret
+func (r StartRequest) Redacted() any {
+	return StartRequest{Node: r.Node, Ports: r.Ports}
 }
 
 // Ports contains the listening ports for the IPv4 and IPv6 tailnet addresses
@@ -22,18 +33,34 @@ type Ports struct {
 type VerifyRequest struct {
 	// ID contains the flow's unique identifier
 	ID string `json:"id"`
-	// Address contains the IP address-port pairs to test
-	Address netip.AddrPort `json:"address"`
+	// Address contains a single IP address-port pair to test, it is only used when Addresses is empty
+	Address netip.AddrPort `json:"address,omitzero"`
+	// Addresses contains the IP address-port pairs to test concurrently
+	Addresses []netip.AddrPort `json:"addresses,omitempty"`
+	// Final marks the flow as failed if this attempt does not verify it
+	Final bool `json:"final,omitempty"`
 }
 
 // FinalizeRequest is sent by the client once the challenge has been sent
 type FinalizeRequest struct {
 	// ID is the unique identifier for the challenge from StartRequest
 	ID string `json:"id"`
+	// Timestamp is when the proof was generated, in seconds since the unix epoch
+	Timestamp int64 `json:"timestamp"`
+	// Proof is the HMAC-SHA256 of "finalize|<id>|<timestamp>" using the signing secret from StartResponse
+	Proof []byte `json:"proof"`
+	// Wait is how many seconds to wait for the challenge to be verified before responding, zero responds immediately
+	Wait int `json:"wait,omitempty"`
+}
+
+// Redacted returns a copy of the request that is safe to log, without the proof
+func (r FinalizeRequest) Redacted() any {
+	r.Proof = nil
+	return r
 }
 
 // GenerateRequest is sent by an EventBridge schedule to re-generate the OIDC metadata
 type GenerateRequest struct {
-	// Issuer is the base URL of the OIDC provider
-	Issuer string `json:"issuer"`
+	// Issuer is the base URL of the OIDC provider. It is only used to detect drift from the configured issuer.
+	Issuer string `json:"issuer,omitempty"`
 }
@@ -8,6 +8,70 @@ type Response[T any] struct {
 	Data *T `json:"data,omitempty"`
 	// Error is a description of what went wrong, only present when Success is `false`
 	Error string `json:"error,omitempty"`
+	// Code is a stable, machine-readable identifier for what went wrong, only present when Success is `false`
+	Code ErrorCode `json:"code,omitempty"`
+	// Verification explains why the challenge failed, only present when finalizing a failed flow
+	Verification *VerificationFailure `json:"verification,omitempty"`
+}
+
+// ErrorCode identifies why a request failed. Unlike the error message, codes are stable and safe to act on.
+type ErrorCode string
+
+const (
+	// ErrorInternal is an unexpected server-side failure
+	ErrorInternal ErrorCode = "internal_error"
+	// ErrorInvalidRequest means the request was malformed or failed validation
+	ErrorInvalidRequest ErrorCode = "invalid_request"
+	// ErrorNotFound means the requested resource does not exist
+	ErrorNotFound ErrorCode = "not_found"
+	// ErrorMethodNotAllowed means the resource does not support the request method
+	ErrorMethodNotAllowed ErrorCode = "method_not_allowed"
+	// ErrorPolicyDenied means the request was refused by the server's policy, such as an unknown hostname
+	ErrorPolicyDenied ErrorCode = "policy_denied"
+	// ErrorUnsupportedVersion means the server does not support the client's API version
+	ErrorUnsupportedVersion ErrorCode = "unsupported_version"
+	// ErrorRateLimited means too many requests were made, the client should wait before trying again
+	ErrorRateLimited ErrorCode = "rate_limited"
+	// ErrorUnavailable means the server is temporarily overloaded, the client should wait before trying again
+	ErrorUnavailable ErrorCode = "unavailable"
+	// ErrorNodeNotFound means the node is not part of the tailnet
+	ErrorNodeNotFound ErrorCode = "node_not_found"
+	// ErrorFlowNotFound means the flow does not exist
+	ErrorFlowNotFound ErrorCode = "flow_not_found"
+	// ErrorFlowExpired means the flow can no longer be finalized, a new one must be started
+	ErrorFlowExpired ErrorCode = "flow_expired"
+	// ErrorFlowFinalized means a token has already been issued for the flow
+	ErrorFlowFinalized ErrorCode = "flow_finalized"
+	// ErrorInvalidProof means the proof of flow ownership did not match
+	ErrorInvalidProof ErrorCode = "invalid_proof"
+	// ErrorChallengePending means the challenge has not been verified yet, the client should try again
+	ErrorChallengePending ErrorCode = "challenge_pending"
+	// ErrorChallengeFailed means the challenge could not be verified
+	ErrorChallengeFailed ErrorCode = "challenge_failed"
+	// ErrorIdempotencyMismatch means the idempotency key was already used for a different request
+	ErrorIdempotencyMismatch ErrorCode = "idempotency_mismatch"
+	// ErrorIdempotencyInProgress means a request with the same idempotency key is still being processed
+	ErrorIdempotencyInProgress ErrorCode = "idempotency_in_progress"
+)
+
+// VerificationFailure explains why a challenge could not be verified
+type VerificationFailure struct {
+	// Reason summarizes the most actionable failure across all attempts
+	Reason string `json:"reason"`
+	// Attempts lists each failed verification attempt in the order they were made
+	Attempts []VerificationAttempt `json:"attempts"`
+}
+
+// VerificationAttempt describes a single failed attempt to verify a challenge
+type VerificationAttempt struct {
+	// Address is the address-port pair the verifier tried to reach
+	Address string `json:"address"`
+	// Reason classifies the failure, one of unreachable, timeout, invalid-response, rejected, or signature-mismatch
+	Reason string `json:"reason"`
+	// Detail is the underlying error message
+	Detail string `json:"detail,omitempty"`
+	// Timestamp is when the attempt was made, in seconds since the unix epoch
+	Timestamp int64 `json:"timestamp"`
 }
 
 // ConfigResponse provides configuration to the daemon
@@ -22,6 +86,8 @@ type StartResponse struct {
 	ID string `json:"id"`
 	// SigningSecret is used to generate a HMAC-SHA256 signature of the client details
 	SigningSecret []byte `json:"signing-secret"`
+	// Status is the state of the flow if it was already verified, one of success or failed
+	Status string `json:"status,omitempty"`
 }
 
 // ChallengeResponse is returned by the client challenge handler
@@ -34,6 +100,28 @@ type ChallengeResponse struct {
 type VerifyResponse struct {
 	// Success denotes whether the verification was successful
 	Success bool `json:"success"`
+	// Results contains the outcome of probing each address
+	Results []AddressResult `json:"results,omitempty"`
+}
+
+// AddressResult is the outcome of probing a single address during verification
+type AddressResult struct {
+	// Address is the IP address-port pair that was probed
+	Address string `json:"address"`
+	// Success denotes whether the address responded with a valid signature
+	Success bool `json:"success"`
+	// Reason classifies why the probe failed
+	Reason string `json:"reason,omitempty"`
+}
+
+// FlowStatusResponse is returned by the flow status handler
+type FlowStatusResponse struct {
+	// ID is the unique identifier for the challenge
+	ID string `json:"id"`
+	// Status is the current state of the challenge, one of pending, success, or failed
+	Status string `json:"status"`
+	// ExpiresAt is when the flow must be finalized by, in seconds since the unix epoch
+	ExpiresAt int64 `json:"expires-at"`
 }
 
 // FinalizeResponse is sent by the finalize handler once the challenge has been successfully authenticated
@@ -0,0 +1,56 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// classifyRequestError determines why the challenge request could not be completed
+func classifyRequestError(err error) storage.FailureReason {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return storage.ReasonTimeout
+	}
+
+	return storage.ReasonUnreachable
+}
+
+// fail records the failed attempt on the flow so the reason can be reported to the client. Probes cancelled because
+// the policy was already decided are skipped rather than recorded.
+func (h *Handler) fail(ctx context.Context, logger logrus.FieldLogger, id string, address netip.AddrPort, reason storage.FailureReason, detail string) result {
+	if errors.Is(context.Cause(ctx), errDecided) {
+		logger.Debug("probe skipped")
+		return result{address: address, reason: storage.ReasonSkipped}
+	}
+
+	// The attempt is recorded even if the probe was cancelled while it failed
+	err := h.store.RecordAttempt(context.WithoutCancel(ctx), id, storage.Attempt{
+		Address: address.String(),
+		Reason:  reason,
+		Detail:  detail,
+		At:      time.Now(),
+	})
+	if err != nil {
+		logger.WithError(err).Warn("failed to record verification attempt")
+	}
+
+	return result{address: address, reason: reason}
+}
+
+// markFailed fails the flow after its final attempt, releasing its pending flow slot
+func (h *Handler) markFailed(ctx context.Context, logger logrus.FieldLogger, id string) {
+	err := storage.MarkFailed(ctx, h.store, id)
+	if errors.Is(err, storage.ErrConflict) {
+		logger.Debug("flow is no longer pending")
+	} else if err != nil {
+		logger.WithError(err).Error("failed to mark flow as failed")
+	} else {
+		logger.Error("verification failed")
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
 
 	"github.com/akrantz01/tailfed/internal/logging"
 	"github.com/akrantz01/tailfed/internal/storage"
@@ -16,51 +18,143 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// Handler is triggered by a step function, performing a single verification request for an address
+// ErrFlowNotFound is returned when the flow being verified no longer exists
+var ErrFlowNotFound = errors.New("flow no longer exists")
+
+// errDecided cancels the remaining probes once their results can no longer change the outcome
+var errDecided = errors.New("verification policy decided")
+
+// Handler is triggered by a launcher backend, probing the flow's addresses and deciding whether it is verified using
+// the configured policy
 type Handler struct {
 	client *http.Client
 	store  storage.Backend
+	policy Policy
 
 	tailnet string
 }
 
 // New creates a new handler
-func New(client *http.Client, store storage.Backend, tailnet string) *Handler {
-	return &Handler{client, store, tailnet}
+func New(client *http.Client, store storage.Backend, tailnet string, policy Policy) *Handler {
+	return &Handler{client, store, policy, tailnet}
 }
 
 func (h *Handler) Serve(ctx context.Context, req types.VerifyRequest) (*types.VerifyResponse, error) {
 	logger := logging.FromContext(ctx).WithFields(map[string]any{
 		"component": "verifier",
 		"flow":      req.ID,
-		"address":   req.Address.String(),
+		"policy":    h.policy,
 	})
 
+	addresses := req.Addresses
+	if len(addresses) == 0 {
+		addresses = []netip.AddrPort{req.Address}
+	}
+
 	flow, err := h.store.Get(ctx, req.ID)
 	if err != nil {
 		logger.WithError(err).Error("failed to find flow")
 		return &types.VerifyResponse{Success: false}, nil
 	} else if flow == nil {
 		logger.Error("flow no longer exists")
-		return nil, fmt.Errorf("flow %q no longer exists", req.ID)
+		return nil, fmt.Errorf("%w: %q", ErrFlowNotFound, req.ID)
+	}
+
+	// Addresses are probed concurrently, each bounded by the client's timeout. Once the policy is decided, the
+	// remaining probes are cancelled and reported as skipped.
+	probeCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	type probed struct {
+		index  int
+		result result
+	}
+	done := make(chan probed, len(addresses))
+	for i, address := range addresses {
+		go func() {
+			done <- probed{i, h.probe(probeCtx, logger.WithField("address", address.String()), flow, address)}
+		}()
+	}
+
+	// Unfinished results keep their address so the policy knows which families are still pending
+	results := make([]result, len(addresses))
+	for i, address := range addresses {
+		results[i].address = address
+	}
+
+	finished := make([]bool, len(addresses))
+	for range addresses {
+		p := <-done
+		results[p.index], finished[p.index] = p.result, true
+
+		if probeCtx.Err() == nil && h.policy.decided(results, finished) {
+			logger.Debug("verification policy decided, skipping remaining addresses")
+			cancel(errDecided)
+		}
+	}
+
+	res := &types.VerifyResponse{Results: make([]types.AddressResult, 0, len(results))}
+	for _, result := range results {
+		res.Results = append(res.Results, types.AddressResult{
+			Address: result.address.String(),
+			Success: result.success,
+			Reason:  string(result.reason),
+		})
+	}
+
+	if !h.policy.satisfied(results) {
+		logger.Warn("addresses do not satisfy verification policy")
+		if req.Final {
+			h.markFailed(ctx, logger, flow.ID)
+		}
+		return res, nil
 	}
 
-	res, err := h.client.Get(fmt.Sprintf("http://%s/%s", req.Address, req.ID))
+	err = h.store.Transition(ctx, flow.ID, storage.StatusPending, storage.StatusSuccess)
+	if errors.Is(err, storage.ErrConflict) {
+		// Another verification may have already succeeded, or the flow was marked as failed
+		current, err := h.store.Get(ctx, flow.ID)
+		if err != nil {
+			logger.WithError(err).Error("failed to get flow state")
+			return nil, fmt.Errorf("failed to get flow %q", flow.ID)
+		}
+
+		res.Success = current != nil && current.Status == storage.StatusSuccess
+		logger.WithField("success", res.Success).Debug("flow was concurrently transitioned")
+		return res, nil
+	} else if err != nil {
+		logger.WithError(err).Error("failed to save flow state")
+		return nil, fmt.Errorf("failed to save flow %q", flow.ID)
+	}
+
+	res.Success = true
+	return res, nil
+}
+
+// probe requests the challenge from a single address and checks its signature, recording the attempt if it fails
+func (h *Handler) probe(ctx context.Context, logger logrus.FieldLogger, flow *storage.Flow, address netip.AddrPort) result {
+	challengeReq, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/%s", address, flow.ID), nil)
+	if err != nil {
+		logger.WithError(err).Error("failed to build request")
+		return h.fail(ctx, logger, flow.ID, address, storage.ReasonInvalidResponse, err.Error())
+	}
+
+	res, err := h.client.Do(challengeReq)
 	if err != nil {
 		logger.WithError(err).Error("failed to send request")
-		return &types.VerifyResponse{Success: false}, nil
+		return h.fail(ctx, logger, flow.ID, address, classifyRequestError(err), err.Error())
 	}
 	defer res.Body.Close()
 
 	var challenge types.Response[types.ChallengeResponse]
 	if err := json.NewDecoder(res.Body).Decode(&challenge); err != nil {
 		logger.WithError(err).Error("failed to deserialize challenge response")
-		return &types.VerifyResponse{Success: false}, nil
+		return h.fail(ctx, logger, flow.ID, address, storage.ReasonInvalidResponse, err.Error())
 	}
 
 	if !challenge.Success {
 		logger.WithField("err", challenge.Error).Error("unsuccessful response from client")
-		return &types.VerifyResponse{Success: false}, nil
+		return h.fail(ctx, logger, flow.ID, address, storage.ReasonRejected, challenge.Error)
 	}
 
 	expected := h.generateMac(logger, flow)
@@ -69,16 +163,11 @@ func (h *Handler) Serve(ctx context.Context, req types.VerifyRequest) (*types.Ve
 			"want": hex.EncodeToString(expected),
 			"got":  hex.EncodeToString(challenge.Data.Signature),
 		}).Warn("invalid signature")
-		return &types.VerifyResponse{Success: false}, nil
-	}
-
-	flow.Status = storage.StatusSuccess
-	if err := h.store.Put(ctx, flow); err != nil {
-		logger.WithError(err).Error("failed to save flow state")
-		return nil, fmt.Errorf("failed to save flow %q", flow.ID)
+		return h.fail(ctx, logger, flow.ID, address, storage.ReasonSignatureMismatch, "signature does not match the expected tailnet, DNS name, and node key")
 	}
 
-	return &types.VerifyResponse{Success: true}, nil
+	logger.Debug("address verified")
+	return result{address: address, success: true}
 }
 
 func (h *Handler) generateMac(logger logrus.FieldLogger, flow *storage.Flow) []byte {
@@ -0,0 +1,180 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/sirupsen/logrus"
+)
+
+const testTailnet = "example.ts.net"
+
+// newTestFlow saves a pending flow to a fresh store
+func newTestFlow(t *testing.T) (storage.Backend, *storage.Flow) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	store, err := storage.NewFilesystem(logger, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flow := &storage.Flow{
+		ID:        "flow",
+		Status:    storage.StatusPending,
+		ExpiresAt: storage.UnixTime(time.Now().Add(time.Minute)),
+		Secret:    []byte("secret"),
+		DNSName:   "node.example.ts.net",
+		PublicKey: "nodekey:abc",
+	}
+	if err := store.Put(context.Background(), flow); err != nil {
+		t.Fatal(err)
+	}
+
+	return store, flow
+}
+
+// serveChallenge starts a client challenge server, returning its address
+func serveChallenge(t *testing.T, handler http.HandlerFunc) netip.AddrPort {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return netip.MustParseAddrPort(server.Listener.Addr().String())
+}
+
+// signed responds to challenges with the flow's expected signature
+func signed(h *Handler, flow *storage.Flow) http.HandlerFunc {
+	signature := h.generateMac(logrus.New(), flow)
+	return func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(types.Response[types.ChallengeResponse]{
+			Success: true,
+			Data:    &types.ChallengeResponse{Signature: signature},
+		})
+	}
+}
+
+// hanging never responds until the request is cancelled
+func hanging(w http.ResponseWriter, r *http.Request) {
+	<-r.Context().Done()
+}
+
+func TestServeSkipsUndecidedProbes(t *testing.T) {
+	store, flow := newTestFlow(t)
+	h := New(&http.Client{Timeout: 10 * time.Second}, store, testTailnet, PolicyAny)
+
+	verified := serveChallenge(t, signed(h, flow))
+	slow := serveChallenge(t, hanging)
+
+	started := time.Now()
+	res, err := h.Serve(context.Background(), types.VerifyRequest{ID: flow.ID, Addresses: []netip.AddrPort{slow, verified}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("expected the slow probe to be cancelled, took %s", elapsed)
+	}
+	if !res.Success {
+		t.Fatal("expected verification to succeed")
+	}
+
+	if res.Results[0].Success || res.Results[0].Reason != string(storage.ReasonSkipped) {
+		t.Fatalf("expected slow address to be skipped, got %+v", res.Results[0])
+	}
+	if !res.Results[1].Success {
+		t.Fatalf("expected address to be verified, got %+v", res.Results[1])
+	}
+
+	saved, err := store.Get(context.Background(), flow.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != storage.StatusSuccess {
+		t.Fatalf("expected flow to succeed, got %q", saved.Status)
+	}
+	if len(saved.Attempts) != 0 {
+		t.Fatalf("expected skipped probes not to be recorded, got %+v", saved.Attempts)
+	}
+}
+
+func TestServeRecordsFailures(t *testing.T) {
+	store, flow := newTestFlow(t)
+	h := New(&http.Client{Timeout: 10 * time.Second}, store, testTailnet, PolicyAll)
+
+	verified := serveChallenge(t, signed(h, flow))
+	rejected := serveChallenge(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(types.Response[types.ChallengeResponse]{Success: false, Error: "nope"})
+	})
+
+	res, err := h.Serve(context.Background(), types.VerifyRequest{ID: flow.ID, Addresses: []netip.AddrPort{verified, rejected}, Final: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Success {
+		t.Fatal("expected verification to fail")
+	}
+
+	saved, err := store.Get(context.Background(), flow.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != storage.StatusFailed {
+		t.Fatalf("expected final attempt to fail the flow, got %q", saved.Status)
+	}
+	if saved.FailureReason() != storage.ReasonRejected {
+		t.Fatalf("expected rejection to be recorded, got %+v", saved.Attempts)
+	}
+}
+
+func TestServeWaitsOnPreferredFamily(t *testing.T) {
+	store, flow := newTestFlow(t)
+	h := New(&http.Client{Timeout: time.Second}, store, testTailnet, PolicyPreferIPv4)
+
+	slow := serveChallenge(t, hanging)
+
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback is unavailable: %v", err)
+	}
+	server := httptest.NewUnstartedServer(signed(h, flow))
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	verified := netip.MustParseAddrPort(listener.Addr().String())
+
+	res, err := h.Serve(context.Background(), types.VerifyRequest{ID: flow.ID, Addresses: []netip.AddrPort{slow, verified}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Success {
+		t.Fatal("expected verification to fall back to IPv6")
+	}
+
+	if res.Results[0].Success || res.Results[0].Reason != string(storage.ReasonTimeout) {
+		t.Fatalf("expected IPv4 address to be probed until it timed out, got %+v", res.Results[0])
+	}
+	if !res.Results[1].Success {
+		t.Fatalf("expected IPv6 address to be verified, got %+v", res.Results[1])
+	}
+
+	saved, err := store.Get(context.Background(), flow.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.FailureReason() != storage.ReasonTimeout {
+		t.Fatalf("expected IPv4 timeout to be recorded, got %+v", saved.Attempts)
+	}
+}
@@ -0,0 +1,102 @@
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/akrantz01/tailfed/internal/storage"
+)
+
+// ErrUnknownPolicy is returned when validating an unsupported verification policy
+var ErrUnknownPolicy = errors.New("unknown verification policy")
+
+// Policy decides whether a flow is verified from the results of probing each of its addresses
+type Policy string
+
+const (
+	// PolicyAny verifies the flow if any address succeeds
+	PolicyAny Policy = "any"
+	// PolicyAll verifies the flow only if every address succeeds
+	PolicyAll Policy = "all"
+	// PolicyPreferIPv4 verifies the flow if any address succeeds, always waiting on the IPv4 address unless it succeeds
+	// first. Other addresses are only a fallback when the IPv4 address fails.
+	PolicyPreferIPv4 Policy = "prefer-ipv4"
+	// PolicyPreferIPv6 verifies the flow if any address succeeds, always waiting on the IPv6 address unless it succeeds
+	// first. Other addresses are only a fallback when the IPv6 address fails.
+	PolicyPreferIPv6 Policy = "prefer-ipv6"
+)
+
+func (p Policy) Validate() error {
+	switch p {
+	case PolicyAny, PolicyAll, PolicyPreferIPv4, PolicyPreferIPv6:
+		return nil
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownPolicy, string(p))
+	}
+}
+
+// result is the outcome of probing a single address
+type result struct {
+	address netip.AddrPort
+	success bool
+	reason  storage.FailureReason
+}
+
+// satisfied checks whether the results meet the policy
+func (p Policy) satisfied(results []result) bool {
+	verified := 0
+	for _, res := range results {
+		if res.success {
+			verified++
+		}
+	}
+
+	if p == PolicyAll {
+		return len(results) != 0 && verified == len(results)
+	}
+
+	return verified != 0
+}
+
+// decided checks whether the outcome is known before every address finished probing, as it is the same whether the
+// unfinished probes succeed or fail. The preferred address family is always probed to completion unless it already
+// verified the flow, so its failures are recorded even when another family succeeds.
+func (p Policy) decided(results []result, finished []bool) bool {
+	if is := p.family(); is != nil {
+		preferredPending, preferredVerified := false, false
+		for i, res := range results {
+			if is(res.address.Addr()) {
+				preferredPending = preferredPending || !finished[i]
+				preferredVerified = preferredVerified || res.success
+			}
+		}
+
+		if preferredPending && !preferredVerified {
+			return false
+		}
+	}
+
+	pessimistic := make([]result, len(results))
+	optimistic := make([]result, len(results))
+	for i, res := range results {
+		pessimistic[i], optimistic[i] = res, res
+		if !finished[i] {
+			optimistic[i].success = true
+		}
+	}
+
+	return p.satisfied(pessimistic) == p.satisfied(optimistic)
+}
+
+// family checks whether an address is in the policy's preferred family, nil if it has no preference
+func (p Policy) family() func(netip.Addr) bool {
+	switch p {
+	case PolicyPreferIPv4:
+		return netip.Addr.Is4
+	case PolicyPreferIPv6:
+		return netip.Addr.Is6
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package verifier
+
+import (
+	"net/netip"
+	"testing"
+)
+
+var (
+	ipv4 = netip.MustParseAddrPort("100.64.0.1:1234")
+	ipv6 = netip.MustParseAddrPort("[fd7a:115c:a1e0::1]:1234")
+)
+
+func TestPolicySatisfied(t *testing.T) {
+	tests := map[string]struct {
+		policy  Policy
+		results []result
+		want    bool
+	}{
+		"any with none":              {PolicyAny, nil, false},
+		"any with one success":       {PolicyAny, []result{{address: ipv4}, {address: ipv6, success: true}}, true},
+		"any with all failures":      {PolicyAny, []result{{address: ipv4}, {address: ipv6}}, false},
+		"all with none":              {PolicyAll, nil, false},
+		"all with every success":     {PolicyAll, []result{{address: ipv4, success: true}, {address: ipv6, success: true}}, true},
+		"all with one failure":       {PolicyAll, []result{{address: ipv4, success: true}, {address: ipv6}}, false},
+		"prefer ipv4 with ipv4":      {PolicyPreferIPv4, []result{{address: ipv4, success: true}, {address: ipv6}}, true},
+		"prefer ipv4 falls back":     {PolicyPreferIPv4, []result{{address: ipv4}, {address: ipv6, success: true}}, true},
+		"prefer ipv4 without ipv4":   {PolicyPreferIPv4, []result{{address: ipv6, success: true}}, true},
+		"prefer ipv4 with failures":  {PolicyPreferIPv4, []result{{address: ipv4}, {address: ipv6}}, false},
+		"prefer ipv6 with ipv6":      {PolicyPreferIPv6, []result{{address: ipv4}, {address: ipv6, success: true}}, true},
+		"prefer ipv6 falls back":     {PolicyPreferIPv6, []result{{address: ipv4, success: true}, {address: ipv6}}, true},
+		"prefer ipv6 with failures":  {PolicyPreferIPv6, []result{{address: ipv4}, {address: ipv6}}, false},
+		"prefer ipv6 without ipv6":   {PolicyPreferIPv6, []result{{address: ipv4}}, false},
+		"prefer ipv6 single success": {PolicyPreferIPv6, []result{{address: ipv4, success: true}}, true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.policy.satisfied(tt.results); got != tt.want {
+				t.Fatalf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPolicyDecided(t *testing.T) {
+	tests := map[string]struct {
+		policy   Policy
+		results  []result
+		finished []bool
+		want     bool
+	}{
+		"any after success": {
+			PolicyAny, []result{{address: ipv4, success: true}, {address: ipv6}}, []bool{true, false}, true,
+		},
+		"any after failure": {
+			PolicyAny, []result{{address: ipv4}, {address: ipv6}}, []bool{true, false}, false,
+		},
+		"all after success": {
+			PolicyAll, []result{{address: ipv4, success: true}, {address: ipv6}}, []bool{true, false}, false,
+		},
+		"all after failure": {
+			PolicyAll, []result{{address: ipv4}, {address: ipv6}}, []bool{true, false}, true,
+		},
+		"prefer ipv4 after ipv4 success": {
+			PolicyPreferIPv4, []result{{address: ipv4, success: true}, {address: ipv6}}, []bool{true, false}, true,
+		},
+		"prefer ipv4 waits on ipv4 after ipv6 success": {
+			PolicyPreferIPv4, []result{{address: ipv4}, {address: ipv6, success: true}}, []bool{false, true}, false,
+		},
+		"prefer ipv4 after ipv4 failure and ipv6 success": {
+			PolicyPreferIPv4, []result{{address: ipv4}, {address: ipv6, success: true}}, []bool{true, true}, true,
+		},
+		"prefer ipv4 falls back after ipv4 failure": {
+			PolicyPreferIPv4, []result{{address: ipv4}, {address: ipv6}}, []bool{true, false}, false,
+		},
+		"prefer ipv6 after ipv6 success": {
+			PolicyPreferIPv6, []result{{address: ipv4}, {address: ipv6, success: true}}, []bool{false, true}, true,
+		},
+		"every probe finished": {
+			PolicyAll, []result{{address: ipv4, success: true}, {address: ipv6, success: true}}, []bool{true, true}, true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.policy.decided(tt.results, tt.finished); got != tt.want {
+				t.Fatalf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for _, policy := range []Policy{PolicyAny, PolicyAll, PolicyPreferIPv4, PolicyPreferIPv6} {
+		if err := policy.Validate(); err != nil {
+			t.Errorf("expected %q to be valid, got %v", policy, err)
+		}
+	}
+
+	if err := Policy("most").Validate(); err == nil {
+		t.Error("expected unknown policy to be invalid")
+	}
+}
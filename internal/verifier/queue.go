@@ -0,0 +1,147 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/launcher"
+	"github.com/akrantz01/tailfed/internal/logging"
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/akrantz01/tailfed/internal/types"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sirupsen/logrus"
+)
+
+// Retry controls how failed attempts are retried when triggered by a queue
+type Retry struct {
+	// MaxAttempts is how many times a flow is attempted before it is marked as failed
+	MaxAttempts int
+	// Backoff is how long to wait after the first failed attempt, doubling after each subsequent attempt
+	Backoff time.Duration
+	// MaxBackoff caps how long to wait between attempts
+	MaxBackoff time.Duration
+}
+
+// Delay computes how long to wait before the next attempt
+func (r *Retry) Delay(attempt int) time.Duration {
+	delay := r.Backoff
+	for range attempt {
+		delay = min(delay*2, r.MaxBackoff)
+	}
+
+	return delay
+}
+
+// QueueHandler is triggered by SQS, performing a single verification attempt for each message. Failed attempts are
+// retried by sending a new delayed message, while unexpected errors are reported as batch item failures so SQS
+// redelivers them. Messages from the dead-letter queue mark their flow as failed.
+type QueueHandler struct {
+	handler *Handler
+	queue   *launcher.SQS
+	store   storage.Backend
+	retry   Retry
+
+	deadLetterArn string
+}
+
+// NewQueue creates a new queue-triggered handler
+func NewQueue(handler *Handler, queue *launcher.SQS, store storage.Backend, retry Retry, deadLetterArn string) *QueueHandler {
+	return &QueueHandler{handler, queue, store, retry, deadLetterArn}
+}
+
+func (h *QueueHandler) Serve(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	var response events.SQSEventResponse
+	for _, record := range event.Records {
+		logger := logging.FromContext(ctx).WithFields(map[string]any{
+			"component": "verifier",
+			"message":   record.MessageId,
+		})
+
+		var err error
+		if len(h.deadLetterArn) != 0 && record.EventSourceARN == h.deadLetterArn {
+			err = h.deadLetter(ctx, logger, &record)
+		} else {
+			err = h.attempt(ctx, logger, &record)
+		}
+
+		if err != nil {
+			logger.WithError(err).Error("failed to process message, will be retried")
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		}
+	}
+
+	return response, nil
+}
+
+// attempt verifies the message's flow against all of its addresses, queueing another attempt if it fails
+func (h *QueueHandler) attempt(ctx context.Context, logger logrus.FieldLogger, record *events.SQSMessage) error {
+	var message launcher.QueueMessage
+	if err := json.Unmarshal([]byte(record.Body), &message); err != nil || len(message.Addresses) == 0 {
+		// Malformed messages can never succeed, so there's no point redelivering them
+		logger.WithError(err).Error("dropping malformed message")
+		return nil
+	}
+	logger = logger.WithFields(map[string]any{"flow": message.ID, "attempt": message.Attempt})
+
+	flow, err := h.store.Get(ctx, message.ID)
+	if err != nil {
+		return err
+	} else if flow == nil || flow.Status != storage.StatusPending || !time.Now().Before(time.Time(flow.ExpiresAt)) {
+		logger.Debug("flow is no longer pending, stopping verification")
+		return nil
+	}
+
+	resp, err := h.handler.Serve(logging.WithLogger(ctx, logger), types.VerifyRequest{
+		ID:        message.ID,
+		Addresses: message.Addresses,
+	})
+	if errors.Is(err, ErrFlowNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if resp.Success {
+		logger.Info("verification succeeded")
+		return nil
+	}
+
+	if message.Attempt+1 >= h.retry.MaxAttempts {
+		logger.Error("verification failed")
+		return h.markFailed(ctx, logger, message.ID)
+	}
+
+	delay := h.retry.Delay(message.Attempt)
+	logger.WithField("wait", delay).Warn("attempt failed, retrying soon")
+
+	message.Attempt++
+	return h.queue.Send(ctx, &message, delay)
+}
+
+// deadLetter marks the flow as failed after its message could not be processed
+func (h *QueueHandler) deadLetter(ctx context.Context, logger logrus.FieldLogger, record *events.SQSMessage) error {
+	var message launcher.QueueMessage
+	if err := json.Unmarshal([]byte(record.Body), &message); err != nil {
+		logger.WithError(err).Error("dropping malformed dead-letter message")
+		return nil
+	}
+
+	logger = logger.WithField("flow", message.ID)
+	logger.Warn("message exhausted its deliveries")
+	return h.markFailed(ctx, logger, message.ID)
+}
+
+func (h *QueueHandler) markFailed(ctx context.Context, logger logrus.FieldLogger, id string) error {
+	err := storage.MarkFailed(ctx, h.store, id)
+	if errors.Is(err, storage.ErrConflict) {
+		logger.Debug("flow is no longer pending")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	logger.Debug("flow marked as failed")
+	return nil
+}
@@ -0,0 +1,159 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/akrantz01/tailfed/internal/launcher"
+	"github.com/akrantz01/tailfed/internal/storage"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const testDeadLetterArn = "arn:aws:sqs:us-east-1:123456789012:tailfed-verifier-dead-letter"
+
+func TestRetryDelay(t *testing.T) {
+	retry := Retry{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	tests := map[int]time.Duration{
+		0: time.Second,
+		1: 2 * time.Second,
+		2: 4 * time.Second,
+		3: 5 * time.Second,
+		9: 5 * time.Second,
+	}
+
+	for attempt, want := range tests {
+		if got := retry.Delay(attempt); got != want {
+			t.Errorf("attempt %d: expected %s, got %s", attempt, want, got)
+		}
+	}
+}
+
+// failingStore fails every read
+type failingStore struct {
+	storage.Backend
+}
+
+func (failingStore) Get(context.Context, string) (*storage.Flow, error) {
+	return nil, errors.New("unavailable")
+}
+
+func queueRecord(t *testing.T, id, source string, message launcher.QueueMessage) events.SQSMessage {
+	t.Helper()
+
+	encoded, err := json.Marshal(&message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return events.SQSMessage{MessageId: id, Body: string(encoded), EventSourceARN: source}
+}
+
+func TestQueueServe(t *testing.T) {
+	store, flow := newTestFlow(t)
+	h := New(&http.Client{Timeout: 5 * time.Second}, store, testTailnet, PolicyAny)
+
+	rejected := serveChallenge(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("not json"))
+	})
+
+	// Messages are never re-queued here, so no queue is needed
+	queue := NewQueue(h, nil, store, Retry{MaxAttempts: 2, Backoff: time.Second, MaxBackoff: time.Second}, testDeadLetterArn)
+
+	res, err := queue.Serve(context.Background(), events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "malformed", Body: "{"},
+		queueRecord(t, "missing", "", launcher.QueueMessage{Request: launcher.Request{ID: "missing", Addresses: []netip.AddrPort{rejected}}}),
+		queueRecord(t, "final", "", launcher.QueueMessage{Request: launcher.Request{ID: flow.ID, Addresses: []netip.AddrPort{rejected}}, Attempt: 1}),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.BatchItemFailures) != 0 {
+		t.Fatalf("expected every message to be handled, got %+v", res.BatchItemFailures)
+	}
+
+	saved, err := store.Get(context.Background(), flow.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != storage.StatusFailed {
+		t.Fatalf("expected final attempt to fail the flow, got %q", saved.Status)
+	}
+	if saved.FailureReason() != storage.ReasonInvalidResponse {
+		t.Fatalf("expected invalid response to be recorded, got %+v", saved.Attempts)
+	}
+}
+
+func TestQueueServeSuccess(t *testing.T) {
+	store, flow := newTestFlow(t)
+	h := New(&http.Client{Timeout: 5 * time.Second}, store, testTailnet, PolicyAny)
+	verified := serveChallenge(t, signed(h, flow))
+
+	queue := NewQueue(h, nil, store, Retry{MaxAttempts: 6, Backoff: time.Second, MaxBackoff: time.Minute}, testDeadLetterArn)
+
+	res, err := queue.Serve(context.Background(), events.SQSEvent{Records: []events.SQSMessage{
+		queueRecord(t, "verify", "", launcher.QueueMessage{Request: launcher.Request{ID: flow.ID, Addresses: []netip.AddrPort{verified}}}),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.BatchItemFailures) != 0 {
+		t.Fatalf("expected message to be handled, got %+v", res.BatchItemFailures)
+	}
+
+	saved, err := store.Get(context.Background(), flow.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != storage.StatusSuccess {
+		t.Fatalf("expected flow to be verified, got %q", saved.Status)
+	}
+}
+
+func TestQueueServeDeadLetter(t *testing.T) {
+	store, flow := newTestFlow(t)
+	h := New(&http.Client{Timeout: 5 * time.Second}, store, testTailnet, PolicyAny)
+	queue := NewQueue(h, nil, store, Retry{MaxAttempts: 6, Backoff: time.Second, MaxBackoff: time.Minute}, testDeadLetterArn)
+
+	res, err := queue.Serve(context.Background(), events.SQSEvent{Records: []events.SQSMessage{
+		queueRecord(t, "dead", testDeadLetterArn, launcher.QueueMessage{Request: launcher.Request{ID: flow.ID}}),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.BatchItemFailures) != 0 {
+		t.Fatalf("expected message to be handled, got %+v", res.BatchItemFailures)
+	}
+
+	saved, err := store.Get(context.Background(), flow.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != storage.StatusFailed {
+		t.Fatalf("expected dead-lettered flow to fail, got %q", saved.Status)
+	}
+}
+
+func TestQueueServeReportsFailures(t *testing.T) {
+	store, flow := newTestFlow(t)
+	broken := failingStore{store}
+	h := New(&http.Client{Timeout: 5 * time.Second}, broken, testTailnet, PolicyAny)
+	queue := NewQueue(h, nil, broken, Retry{MaxAttempts: 6, Backoff: time.Second, MaxBackoff: time.Minute}, testDeadLetterArn)
+
+	address := netip.MustParseAddrPort("127.0.0.1:1")
+	res, err := queue.Serve(context.Background(), events.SQSEvent{Records: []events.SQSMessage{
+		queueRecord(t, "retry", "", launcher.QueueMessage{Request: launcher.Request{ID: flow.ID, Addresses: []netip.AddrPort{address}}}),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.BatchItemFailures) != 1 || res.BatchItemFailures[0].ItemIdentifier != "retry" {
+		t.Fatalf("expected message to be redelivered, got %+v", res.BatchItemFailures)
+	}
+}
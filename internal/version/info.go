@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -35,10 +36,31 @@ type Info struct {
 	Compiler  string `json:"compiler,omitempty"`
 	Platform  string `json:"platform,omitempty"`
 
+	// Features lists the optional API capabilities the server supports
+	Features []string `json:"features,omitempty"`
+
 	Name        string `json:"-"`
 	Description string `json:"-"`
 }
 
+// Optional API capabilities that clients can check for before using
+const (
+	// FeatureFlowStatus is the GET /flows/{id} endpoint
+	FeatureFlowStatus = "flow-status"
+	// FeatureFinalizeWait is the wait parameter on POST /finalize
+	FeatureFinalizeWait = "finalize-wait"
+	// FeatureClientSecret is the signing secret parameter on POST /start
+	FeatureClientSecret = "client-secret"
+)
+
+// features are the capabilities of this build
+var features = []string{FeatureFlowStatus, FeatureFinalizeWait, FeatureClientSecret}
+
+// Supports checks whether the build advertised the feature
+func (i *Info) Supports(feature string) bool {
+	return slices.Contains(i.Features, feature)
+}
+
 func getBuildInfo() *debug.BuildInfo {
 	bi, ok := debug.ReadBuildInfo()
 	if !ok {
@@ -116,6 +138,7 @@ func GetInfo() Info {
 		info.GoVersion = runtime.Version()
 		info.Compiler = runtime.Compiler
 		info.Platform = fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
+		info.Features = features
 	})
 
 	return info
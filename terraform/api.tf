@@ -1,6 +1,12 @@
 locals {
   custom_domain = var.domain != null
   invoke_url    = local.custom_domain ? "https://${aws_api_gateway_domain_name.production[0].domain_name}" : aws_api_gateway_stage.production.invoke_url
+
+  issuer_environment = {
+    TAILFED_ISSUER__URL        = local.invoke_url
+    TAILFED_ISSUER__ALTERNATES = join(",", var.issuer_alternate_hostnames)
+    TAILFED_ISSUER__POLICY     = var.issuer_host_policy
+  }
 }
 
 resource "aws_api_gateway_rest_api" "default" {
@@ -22,6 +28,7 @@ resource "aws_api_gateway_deployment" "default" {
     module.metadata_jwks,
     module.initializer_apigateway,
     module.finalizer_apigateway,
+    module.finalizer_status_apigateway,
   ]
 
   rest_api_id = aws_api_gateway_rest_api.default.id
@@ -39,12 +46,15 @@ resource "aws_api_gateway_deployment" "default" {
       aws_api_gateway_resource.config,
       aws_api_gateway_resource.start,
       aws_api_gateway_resource.finalize,
+      aws_api_gateway_resource.flows,
+      aws_api_gateway_resource.flow,
       module.metadata_version.requires_redeployment,
       module.metadata_config.requires_redeployment,
       module.metadata_openid_discovery_document.requires_redeployment,
       module.metadata_jwks.requires_redeployment,
       module.initializer_apigateway.requires_redeployment,
       module.finalizer_apigateway.requires_redeployment,
+      module.finalizer_status_apigateway.requires_redeployment,
     ]))
   }
 }
@@ -104,6 +114,18 @@ resource "aws_api_gateway_resource" "finalize" {
   path_part   = "finalize"
 }
 
+resource "aws_api_gateway_resource" "flows" {
+  rest_api_id = aws_api_gateway_rest_api.default.id
+  parent_id   = aws_api_gateway_rest_api.default.root_resource_id
+  path_part   = "flows"
+}
+
+resource "aws_api_gateway_resource" "flow" {
+  rest_api_id = aws_api_gateway_rest_api.default.id
+  parent_id   = aws_api_gateway_resource.flows.id
+  path_part   = "{id}"
+}
+
 resource "aws_api_gateway_resource" "well_known" {
   rest_api_id = aws_api_gateway_rest_api.default.id
   parent_id   = aws_api_gateway_rest_api.default.root_resource_id
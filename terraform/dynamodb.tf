@@ -19,3 +19,41 @@ resource "aws_dynamodb_table" "storage" {
     enabled = false
   }
 }
+
+resource "aws_dynamodb_table" "audit" {
+  name         = "TailfedAudit"
+  billing_mode = "PAY_PER_REQUEST"
+
+  hash_key = "ID"
+
+  attribute {
+    name = "ID"
+    type = "S"
+  }
+
+  attribute {
+    name = "Node"
+    type = "S"
+  }
+
+  attribute {
+    name = "IssuedAt"
+    type = "N"
+  }
+
+  global_secondary_index {
+    name            = "NodeIssuedAt"
+    hash_key        = "Node"
+    range_key       = "IssuedAt"
+    projection_type = "ALL"
+  }
+
+  point_in_time_recovery {
+    enabled = true
+  }
+
+  # Use AWS-managed key
+  server_side_encryption {
+    enabled = false
+  }
+}
@@ -23,3 +23,15 @@ data "aws_iam_policy_document" "signer" {
     }
   }
 }
+
+resource "aws_kms_key" "storage" {
+  description         = "Flow secret encryption key for Tailfed"
+  key_usage           = "ENCRYPT_DECRYPT"
+  enable_key_rotation = true
+  policy              = data.aws_iam_policy_document.signer.json
+}
+
+resource "aws_kms_alias" "storage" {
+  target_key_id = aws_kms_key.storage.id
+  name          = "alias/tailfed-storage"
+}
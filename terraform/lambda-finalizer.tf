@@ -6,16 +6,22 @@ module "finalizer" {
   name = "finalizer"
   arch = var.architecture
 
+  # Requests can wait up to 20 seconds for the challenge to be verified
+  timeout = 25
+
   bucket   = module.artifacts_proxy.id
   checksum = local.artifact_hashes["finalizer"]
 
-  environment = {
-    TAILFED_LOG_LEVEL         = var.log_level
-    TAILFED_SIGNING__AUDIENCE = var.audience
-    TAILFED_SIGNING__KEY      = aws_kms_alias.signer.arn
-    TAILFED_SIGNING__VALIDITY = var.validity
-    TAILFED_STORAGE__TABLE    = aws_dynamodb_table.storage.arn
-  }
+  environment = merge(local.issuer_environment, {
+    TAILFED_LOG_LEVEL                 = var.log_level
+    TAILFED_AUDIT__TABLE              = aws_dynamodb_table.audit.arn
+    TAILFED_SIGNING__AUDIENCE         = var.audience
+    TAILFED_SIGNING__KEY              = aws_kms_alias.signer.arn
+    TAILFED_SIGNING__VALIDITY         = var.validity
+    TAILFED_STORAGE__TABLE            = aws_dynamodb_table.storage.arn
+    TAILFED_STORAGE__ENCRYPTION_KEY   = aws_kms_key.storage.arn
+    TAILFED_STORAGE__ENCRYPT_IDENTITY = var.encrypt_flow_identity
+  })
 
   policies = merge({ Lambda = data.aws_iam_policy_document.finalizer.json }, var.execution_role_policies)
 }
@@ -29,6 +35,17 @@ module "finalizer_apigateway" {
   function = module.finalizer
 }
 
+module "finalizer_status_apigateway" {
+  source = "./modules/apigateway-lambda"
+
+  rest_api = aws_api_gateway_rest_api.default
+  resource = aws_api_gateway_resource.flow
+  method   = "GET"
+
+  function      = module.finalizer
+  permission_id = "AllowFlowStatusFromAPIGateway"
+}
+
 data "aws_iam_policy_document" "finalizer" {
   statement {
     sid    = "Storage"
@@ -36,10 +53,18 @@ data "aws_iam_policy_document" "finalizer" {
     actions = [
       "dynamodb:DeleteItem",
       "dynamodb:GetItem",
+      "dynamodb:UpdateItem",
     ]
     resources = [aws_dynamodb_table.storage.arn]
   }
 
+  statement {
+    sid       = "Audit"
+    effect    = "Allow"
+    actions   = ["dynamodb:PutItem"]
+    resources = [aws_dynamodb_table.audit.arn]
+  }
+
   statement {
     sid    = "Signer"
     effect = "Allow"
@@ -49,4 +74,17 @@ data "aws_iam_policy_document" "finalizer" {
     ]
     resources = [aws_kms_key.signer.arn]
   }
+
+  statement {
+    sid       = "StorageEncryption"
+    effect    = "Allow"
+    actions   = ["kms:Decrypt"]
+    resources = [aws_kms_key.storage.arn]
+
+    condition {
+      test     = "StringLike"
+      variable = "kms:EncryptionContext:tailfed:flow"
+      values   = ["*"]
+    }
+  }
 }
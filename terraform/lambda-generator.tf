@@ -15,12 +15,12 @@ module "generator" {
   bucket   = module.artifacts_proxy.id
   checksum = local.artifact_hashes["generator"]
 
-  environment = {
+  environment = merge(local.issuer_environment, {
     TAILFED_LOG_LEVEL         = var.log_level
     TAILFED_METADATA__BUCKET  = module.metadata.id
     TAILFED_SIGNING__KEY      = aws_kms_alias.signer.arn
     TAILFED_SIGNING__VALIDITY = var.validity
-  }
+  })
 
   policies = merge({ Lambda = data.aws_iam_policy_document.generator.json }, var.execution_role_policies)
 }
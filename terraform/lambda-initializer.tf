@@ -9,10 +9,19 @@ module "initializer" {
   bucket   = module.artifacts_proxy.id
   checksum = local.artifact_hashes["initializer"]
 
-  environment = {
+  environment = merge(local.issuer_environment, {
     TAILFED_LOG_LEVEL                      = var.log_level
-    TAILFED_LAUNCHER__STATE_MACHINE        = aws_sfn_state_machine.verifier.arn
+    TAILFED_LAUNCHER__BACKEND              = var.verification_launcher
+    TAILFED_LAUNCHER__STATE_MACHINE        = local.verification_queue ? null : aws_sfn_state_machine.verifier.arn
+    TAILFED_LAUNCHER__QUEUE_URL            = one(aws_sqs_queue.verifier[*].url)
+    TAILFED_LIMITS__NODE__REQUESTS         = var.rate_limits.node_requests
+    TAILFED_LIMITS__NODE__WINDOW           = var.rate_limits.node_window
+    TAILFED_LIMITS__SOURCE__REQUESTS       = var.rate_limits.source_requests
+    TAILFED_LIMITS__SOURCE__WINDOW         = var.rate_limits.source_window
+    TAILFED_LIMITS__PENDING                = var.rate_limits.pending
     TAILFED_STORAGE__TABLE                 = aws_dynamodb_table.storage.arn
+    TAILFED_STORAGE__ENCRYPTION_KEY        = aws_kms_key.storage.arn
+    TAILFED_STORAGE__ENCRYPT_IDENTITY      = var.encrypt_flow_identity
     TAILFED_TAILSCALE__BACKEND             = var.tailscale_backend
     TAILFED_TAILSCALE__BASE_URL            = var.tailscale_base_url
     TAILFED_TAILSCALE__TLS_MODE            = var.tailscale_tls_mode
@@ -20,9 +29,13 @@ module "initializer" {
     TAILFED_TAILSCALE__API_KEY             = var.tailscale_api_key
     TAILFED_TAILSCALE__OAUTH_CLIENT_ID     = var.tailscale_oauth.client_id
     TAILFED_TAILSCALE__OAUTH_CLIENT_SECRET = var.tailscale_oauth.client_secret
-  }
+  })
 
-  policies = merge({ Lambda = data.aws_iam_policy_document.initializer.json }, var.execution_role_policies)
+  policies = merge(
+    { Lambda = data.aws_iam_policy_document.initializer.json },
+    { for document in data.aws_iam_policy_document.initializer_queue : "Queue" => document.json },
+    var.execution_role_policies,
+  )
 }
 
 module "initializer_apigateway" {
@@ -46,9 +59,30 @@ data "aws_iam_policy_document" "initializer" {
   }
 
   statement {
-    sid       = "Storage"
-    effect    = "Allow"
-    actions   = ["dynamodb:PutItem"]
+    sid    = "Storage"
+    effect = "Allow"
+    actions = [
+      "dynamodb:DeleteItem",
+      "dynamodb:GetItem",
+      "dynamodb:PutItem",
+      "dynamodb:UpdateItem",
+    ]
     resources = [aws_dynamodb_table.storage.arn]
   }
+
+  statement {
+    sid    = "StorageEncryption"
+    effect = "Allow"
+    actions = [
+      "kms:Decrypt",
+      "kms:GenerateDataKey",
+    ]
+    resources = [aws_kms_key.storage.arn]
+
+    condition {
+      test     = "StringLike"
+      variable = "kms:EncryptionContext:tailfed:flow"
+      values   = ["*"]
+    }
+  }
 }
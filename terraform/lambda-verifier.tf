@@ -8,6 +8,8 @@ locals {
     debug = "ALL"
     trace = "ALL"
   }
+
+  verifier_timeout = 60
 }
 
 module "verifier" {
@@ -21,16 +23,25 @@ module "verifier" {
   bucket   = module.artifacts_proxy.id
   checksum = local.artifact_hashes["verifier"]
 
-  timeout = 60
+  timeout = local.verifier_timeout
 
   environment = {
-    TAILFED_LOG_LEVEL           = var.log_level
-    TAILFED_STORAGE__TABLE      = aws_dynamodb_table.storage.arn
-    TAILFED_TAILSCALE__TAILNET  = var.tailscale_tailnet
-    TAILFED_TAILSCALE__AUTH_KEY = var.tailscale_auth_key
+    TAILFED_LOG_LEVEL                 = var.log_level
+    TAILFED_POLICY                    = var.verification_policy
+    TAILFED_QUEUE__URL                = one(aws_sqs_queue.verifier[*].url)
+    TAILFED_QUEUE__DEAD_LETTER_ARN    = one(aws_sqs_queue.verifier_dead_letter[*].arn)
+    TAILFED_STORAGE__TABLE            = aws_dynamodb_table.storage.arn
+    TAILFED_STORAGE__ENCRYPTION_KEY   = aws_kms_key.storage.arn
+    TAILFED_STORAGE__ENCRYPT_IDENTITY = var.encrypt_flow_identity
+    TAILFED_TAILSCALE__TAILNET        = var.tailscale_tailnet
+    TAILFED_TAILSCALE__AUTH_KEY       = var.tailscale_auth_key
   }
 
-  policies = merge({ Lambda = data.aws_iam_policy_document.verifier.json }, var.execution_role_policies)
+  policies = merge(
+    { Lambda = data.aws_iam_policy_document.verifier.json },
+    { for document in data.aws_iam_policy_document.verifier_queue : "Queue" => document.json },
+    var.execution_role_policies,
+  )
 }
 
 resource "aws_iam_role" "verifier_state_machine" {
@@ -72,7 +83,7 @@ resource "aws_sfn_state_machine" "verifier" {
     States = {
       Initialize = {
         Type = "Pass"
-        Next = "ForEachAddress"
+        Next = "AttemptVerification"
         Assign = {
           retries    = 0
           maxRetries = 5
@@ -81,48 +92,34 @@ resource "aws_sfn_state_machine" "verifier" {
         }
       }
 
-      ForEachAddress = {
-        Type  = "Map"
-        Next  = "CheckResult"
-        Items = "{% $states.context.Execution.Input.addresses %}"
-        ItemSelector = {
-          id      = "{% $states.context.Execution.Input.id %}"
-          address = "{% $states.context.Map.Item.Value %}"
-        }
-        ItemProcessor = {
-          ProcessorConfig = { Mode = "INLINE" }
-          StartAt         = "AttemptVerification"
-          States = {
-            AttemptVerification = {
-              Type     = "Task"
-              End      = true
-              Resource = "arn:aws:states:::lambda:invoke"
-              Arguments = {
-                FunctionName = "${module.verifier.arn}:$LATEST"
-                Payload      = "{% $states.input %}"
-              }
-              Output = "{% $states.result.Payload %}"
-              Retry = [
-                {
-                  Comment         = "On Lambda Failures"
-                  IntervalSeconds = 1
-                  MaxAttempts     = 3
-                  BackoffRate     = 2
-                  JitterStrategy  = "FULL"
-                  ErrorEquals = [
-                    "Lambda.ServiceException",
-                    "Lambda.AWSLambdaException",
-                    "Lambda.SdkClientException",
-                    "Lambda.TooManyRequestsException",
-                  ]
-                }
-              ]
-            }
+      AttemptVerification = {
+        Type     = "Task"
+        Next     = "CheckResult"
+        Resource = "arn:aws:states:::lambda:invoke"
+        Arguments = {
+          FunctionName = "${module.verifier.arn}:$LATEST"
+          Payload = {
+            id        = "{% $states.context.Execution.Input.id %}"
+            addresses = "{% $states.context.Execution.Input.addresses %}"
+            final     = "{% $retries >= $maxRetries %}"
           }
         }
-        Output = {
-          success = "{% $reduce($states.result, function($acc, $v) { $acc or $v.success }, false) %}"
-        }
+        Output = "{% $states.result.Payload %}"
+        Retry = [
+          {
+            Comment         = "On Lambda Failures"
+            IntervalSeconds = 1
+            MaxAttempts     = 3
+            BackoffRate     = 2
+            JitterStrategy  = "FULL"
+            ErrorEquals = [
+              "Lambda.ServiceException",
+              "Lambda.AWSLambdaException",
+              "Lambda.SdkClientException",
+              "Lambda.TooManyRequestsException",
+            ]
+          }
+        ]
       }
 
       CheckResult = {
@@ -136,7 +133,7 @@ resource "aws_sfn_state_machine" "verifier" {
           },
           {
             Next      = "MarkFailed"
-            Comment   = "Max Retries Reached?"
+            Comment   = "Max Retries Reached? The final attempt already failed the flow unless the verifier errored"
             Condition = "{% $retries >= $maxRetries %}"
           },
         ]
@@ -145,7 +142,7 @@ resource "aws_sfn_state_machine" "verifier" {
       Wait = {
         Type    = "Wait"
         Seconds = "{% $max([$ceil($waitTime), 1]) %}"
-        Next    = "ForEachAddress"
+        Next    = "AttemptVerification"
         Assign = {
           "retries"  = "{% $retries + 1 %}",
           "waitTime" = "{% $waitTime * 2 * (1 + ($waitJitter * ($random() * 2 - 1))) %}"
@@ -155,20 +152,67 @@ resource "aws_sfn_state_machine" "verifier" {
       MarkFailed = {
         Type     = "Task"
         Resource = "arn:aws:states:::dynamodb:updateItem"
-        Next     = "Fail"
+        Next     = "ReleasePending"
         Arguments = {
           TableName = aws_dynamodb_table.storage.name
           Key = {
             ID = { S = "{% $states.context.Execution.Input.id %}" }
           }
-          UpdateExpression = "SET #s = :s"
+          UpdateExpression    = "SET #s = :s ADD #v :one"
+          ConditionExpression = "#s = :pending"
           ExpressionAttributeNames = {
             "#s" = "Status"
+            "#v" = "Version"
           }
           ExpressionAttributeValues = {
-            ":s" = { S = "failed" }
+            ":s"       = { S = "failed" }
+            ":pending" = { S = "pending" }
+            ":one"     = { N = "1" }
           }
+          ReturnValues = "ALL_NEW"
         }
+        Assign = {
+          node      = "{% $states.result.Attributes.Node.S %}"
+          expiresAt = "{% $states.result.Attributes.ExpiresAt.N %}"
+        }
+        Catch = [
+          {
+            Comment     = "Flow Is No Longer Pending"
+            ErrorEquals = ["DynamoDB.ConditionalCheckFailedException"]
+            Next        = "Fail"
+          }
+        ]
+      }
+
+      ReleasePending = {
+        Type     = "Task"
+        Resource = "arn:aws:states:::dynamodb:updateItem"
+        Next     = "Fail"
+        Comment  = "Free the flow's slot in the node's pending flow cap, mirroring storage.ReleasePending"
+        Arguments = {
+          TableName = aws_dynamodb_table.storage.name
+          Key = {
+            ID = { S = "{% 'counter:pending:' & $node %}" }
+          }
+          UpdateExpression    = "ADD #count :minusOne"
+          ConditionExpression = "attribute_exists(ID) AND ExpiresAt > :now AND ExpiresAt <= :resetBy AND #count > :zero"
+          ExpressionAttributeNames = {
+            "#count" = "Count"
+          }
+          ExpressionAttributeValues = {
+            ":minusOne" = { N = "-1" }
+            ":zero"     = { N = "0" }
+            ":now"      = { N = "{% $string($floor($millis() / 1000)) %}" }
+            ":resetBy"  = { N = "{% $expiresAt %}" }
+          }
+        }
+        Catch = [
+          {
+            Comment     = "Slot Already Released Or Window Reset"
+            ErrorEquals = ["DynamoDB.ConditionalCheckFailedException"]
+            Next        = "Fail"
+          }
+        ]
       }
 
       Success = { Type = "Succeed" }
@@ -195,10 +239,23 @@ data "aws_iam_policy_document" "verifier" {
     effect = "Allow"
     actions = [
       "dynamodb:GetItem",
-      "dynamodb:PutItem",
+      "dynamodb:UpdateItem",
     ]
     resources = [aws_dynamodb_table.storage.arn]
   }
+
+  statement {
+    sid       = "StorageEncryption"
+    effect    = "Allow"
+    actions   = ["kms:Decrypt"]
+    resources = [aws_kms_key.storage.arn]
+
+    condition {
+      test     = "StringLike"
+      variable = "kms:EncryptionContext:tailfed:flow"
+      values   = ["*"]
+    }
+  }
 }
 
 data "aws_iam_policy_document" "verifier_state_machine" {
@@ -29,7 +29,7 @@ resource "aws_api_gateway_integration" "integration" {
 resource "aws_lambda_permission" "handler" {
   function_name = var.function.name
 
-  statement_id = "AllowExecutionFromAPIGateway"
+  statement_id = var.permission_id
   action       = "lambda:InvokeFunction"
   principal    = "apigateway.amazonaws.com"
 
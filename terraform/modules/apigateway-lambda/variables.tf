@@ -12,6 +12,12 @@ variable "method" {
   default     = "POST"
 }
 
+variable "permission_id" {
+  type        = string
+  description = "The statement ID of the invoke permission, must be unique when a function serves multiple routes"
+  default     = "AllowExecutionFromAPIGateway"
+}
+
 variable "rest_api" {
   type = object({
     id            = string
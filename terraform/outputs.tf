@@ -12,3 +12,8 @@ output "domain_endpoint" {
   value       = local.custom_domain ? aws_api_gateway_domain_name.production[0].cloudfront_domain_name : null
   description = "The CloudFront domain name to use with CNAME records (custom domains only)"
 }
+
+output "audit_table" {
+  value       = aws_dynamodb_table.audit.name
+  description = "The DynamoDB table containing the record of issued tokens"
+}
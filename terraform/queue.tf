@@ -0,0 +1,98 @@
+locals {
+  verification_queue = var.verification_launcher == "sqs"
+}
+
+resource "aws_sqs_queue" "verifier" {
+  count = local.verification_queue ? 1 : 0
+
+  name = "tailfed-verifier"
+
+  # Must exceed the verifier's timeout, otherwise in-progress messages are redelivered
+  visibility_timeout_seconds = 6 * local.verifier_timeout
+  message_retention_seconds  = 900
+  sqs_managed_sse_enabled    = true
+
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.verifier_dead_letter[0].arn
+    maxReceiveCount     = 3
+  })
+}
+
+resource "aws_sqs_queue" "verifier_dead_letter" {
+  count = local.verification_queue ? 1 : 0
+
+  name = "tailfed-verifier-dead-letter"
+
+  message_retention_seconds = 3600
+  sqs_managed_sse_enabled   = true
+}
+
+resource "aws_sqs_queue_redrive_allow_policy" "verifier_dead_letter" {
+  count = local.verification_queue ? 1 : 0
+
+  queue_url = aws_sqs_queue.verifier_dead_letter[0].id
+
+  redrive_allow_policy = jsonencode({
+    redrivePermission = "byQueue"
+    sourceQueueArns   = [aws_sqs_queue.verifier[0].arn]
+  })
+}
+
+# Both queues trigger the verifier, which marks the flows of dead-lettered messages as failed. Failed messages are
+# reported individually so the rest of the batch is not redelivered.
+resource "aws_lambda_event_source_mapping" "verifier" {
+  for_each = local.verification_queue ? {
+    queue       = aws_sqs_queue.verifier[0].arn
+    dead_letter = aws_sqs_queue.verifier_dead_letter[0].arn
+  } : {}
+
+  function_name    = module.verifier.arn
+  event_source_arn = each.value
+
+  batch_size              = 10
+  function_response_types = ["ReportBatchItemFailures"]
+
+  scaling_config {
+    maximum_concurrency = 10
+  }
+}
+
+data "aws_iam_policy_document" "verifier_queue" {
+  count = local.verification_queue ? 1 : 0
+
+  statement {
+    sid    = "Consume"
+    effect = "Allow"
+    actions = [
+      "sqs:ChangeMessageVisibility",
+      "sqs:DeleteMessage",
+      "sqs:GetQueueAttributes",
+      "sqs:ReceiveMessage",
+    ]
+    resources = [
+      aws_sqs_queue.verifier[0].arn,
+      aws_sqs_queue.verifier_dead_letter[0].arn,
+    ]
+  }
+
+  statement {
+    sid       = "Retry"
+    effect    = "Allow"
+    actions   = ["sqs:SendMessage"]
+    resources = [aws_sqs_queue.verifier[0].arn]
+  }
+}
+
+data "aws_iam_policy_document" "initializer_queue" {
+  count = local.verification_queue ? 1 : 0
+
+  statement {
+    sid    = "Launcher"
+    effect = "Allow"
+    actions = [
+      "sqs:GetQueueAttributes",
+      "sqs:SendMessage",
+    ]
+    resources = [aws_sqs_queue.verifier[0].arn]
+  }
+}
@@ -24,12 +24,35 @@ variable "domain" {
   default     = null
 }
 
+variable "encrypt_flow_identity" {
+  type        = bool
+  description = "Whether to encrypt identifying node details in addition to the flow secret"
+  default     = false
+}
+
 variable "execution_role_policies" {
   type        = map(string)
   description = "Additional policies to attach to the Lambda execution roles"
   default     = {}
 }
 
+variable "issuer_alternate_hostnames" {
+  type        = list(string)
+  description = "Additional hostnames, besides the issuer's, that requests are accepted on"
+  default     = []
+}
+
+variable "issuer_host_policy" {
+  type        = string
+  description = "What to do with requests that arrive on a hostname not belonging to the issuer"
+  default     = "reject"
+
+  validation {
+    condition     = contains(["reject", "log"], var.issuer_host_policy)
+    error_message = "Unknown host policy (options: reject, log)"
+  }
+}
+
 variable "log_level" {
   type        = string
   description = "The level for functions to log at"
@@ -41,6 +64,18 @@ variable "log_level" {
   }
 }
 
+variable "rate_limits" {
+  type = object({
+    node_requests   = optional(number, 12)
+    node_window     = optional(string, "1h")
+    source_requests = optional(number, 60)
+    source_window   = optional(string, "1h")
+    pending         = optional(number, 2)
+  })
+  description = "Limits on how frequently flows can be started, a value of 0 disables the limit"
+  default     = {}
+}
+
 variable "region" {
   type        = string
   description = "The region to deploy resource to"
@@ -172,3 +207,25 @@ variable "validity" {
   description = "How long a token should be valid for. Formatted as a Go duration string"
   default     = "1h"
 }
+
+variable "verification_launcher" {
+  type        = string
+  description = "How verification of new flows is started, either through a state machine or an SQS queue"
+  default     = "step-function"
+
+  validation {
+    condition     = contains(["step-function", "sqs"], var.verification_launcher)
+    error_message = "Unknown verification launcher (options: step-function, sqs)"
+  }
+}
+
+variable "verification_policy" {
+  type        = string
+  description = "Which of a node's addresses must respond to a challenge for it to be verified"
+  default     = "any"
+
+  validation {
+    condition     = contains(["any", "all", "prefer-ipv4", "prefer-ipv6"], var.verification_policy)
+    error_message = "Unknown verification policy (options: any, all, prefer-ipv4, prefer-ipv6)"
+  }
+}